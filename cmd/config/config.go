@@ -2,7 +2,10 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 type AppConfig struct {
@@ -11,9 +14,41 @@ type AppConfig struct {
 	FlagLogLevel  string
 	FlagStorage   string
 	FlagDB        string
+	FlagFileSync  string
+	FlagBolt      string
+	FlagSQLite    string
+	FlagGRPCAddr  string
+	FlagTLSCert   string
+	FlagTLSKey    string
+
+	FlagTrustedSubnet  string
+	FlagTrustedProxies string
+	FlagAdminKey       string
+	FlagAuditLog       string
+	FlagDeadLetter     string
+	FlagGeoIP          string
+
+	FlagEnableHTTPS      bool
+	FlagHTTPRedirectAddr string
+	FlagHSTSMaxAge       time.Duration
+	FlagTLSReload        time.Duration
+
+	FlagFileSyncInterval time.Duration
+	FlagRetention        time.Duration
+	FlagPurgeInterval    time.Duration
+
+	FlagDeletionWorkers    int
+	FlagDeletionQueueSize  int
+	FlagDeletionBatchSize  int
+	FlagDeletionRetries    int
+	FlagDeletionFlush      time.Duration
+	FlagDeletionBackoff    time.Duration
+	FlagDeletionMaxBackoff time.Duration
 }
 
-func ParseFlags() AppConfig {
+// ParseFlags parses the flags and the environment variables overriding them,
+// an invalid variable is reported like an invalid flag.
+func ParseFlags() (AppConfig, error) {
 	var appConfig AppConfig
 	flag.StringVar(&appConfig.FlagRunAddr, "a", ":8080", "address and port to run server")
 	flag.StringVar(&appConfig.FlagShortAddr, "b", "http://localhost:8080", "address and port before short url")
@@ -22,6 +57,50 @@ func ParseFlags() AppConfig {
 	flag.StringVar(
 		&appConfig.FlagDB, "d", "", "database connection",
 	)
+	flag.BoolVar(&appConfig.FlagEnableHTTPS, "s", false, "serve HTTPS")
+	flag.StringVar(&appConfig.FlagTLSCert, "tls-cert", "", "TLS certificate file")
+	flag.StringVar(&appConfig.FlagTLSKey, "tls-key", "", "TLS private key file")
+	flag.DurationVar(
+		&appConfig.FlagTLSReload, "tls-reload-interval", 30*time.Second, "interval of TLS key pair change checks",
+	)
+	flag.StringVar(
+		&appConfig.FlagHTTPRedirectAddr, "redirect-address", "",
+		"address and port to redirect plain HTTP to HTTPS, disabled if empty",
+	)
+	flag.DurationVar(
+		&appConfig.FlagHSTSMaxAge, "hsts-max-age", 365*24*time.Hour, "HSTS max age, disabled if zero",
+	)
+	flag.StringVar(&appConfig.FlagTrustedSubnet, "t", "", "CIDR allowed to access internal endpoints")
+	flag.StringVar(
+		&appConfig.FlagTrustedProxies, "trusted-proxies", "",
+		"comma separated CIDRs of proxies whose X-Real-IP and X-Forwarded-For are trusted",
+	)
+	flag.StringVar(&appConfig.FlagAdminKey, "admin-key", "", "comma separated operator:key API keys of the admin API, a key without operator belongs to admin, the admin API is disabled if empty")
+	flag.StringVar(&appConfig.FlagAuditLog, "audit-log", "", "admin actions audit log file, stderr if empty")
+	flag.StringVar(
+		&appConfig.FlagGeoIP, "geoip-db", "",
+		"MaxMind DB file resolving the countries of routing rules, country conditions never match if empty",
+	)
+	flag.StringVar(&appConfig.FlagGRPCAddr, "grpc-address", "", "address and port to run gRPC server, disabled if empty")
+	flag.StringVar(&appConfig.FlagBolt, "bolt", "", "bolt storage file address")
+	flag.StringVar(&appConfig.FlagSQLite, "sqlite", "", "sqlite storage file address")
+	flag.StringVar(&appConfig.FlagFileSync, "file-sync", "always", "json file fsync policy: always, periodic or none")
+	flag.DurationVar(
+		&appConfig.FlagFileSyncInterval, "file-sync-interval", time.Second, "json file fsync interval for periodic policy",
+	)
+	flag.DurationVar(
+		&appConfig.FlagRetention, "deleted-retention", 0,
+		"how long deleted URLs can be restored before they are purged, kept forever if zero",
+	)
+	flag.DurationVar(&appConfig.FlagPurgeInterval, "purge-interval", time.Hour, "interval of deleted URLs purge runs")
+	flag.IntVar(&appConfig.FlagDeletionWorkers, "deletion-workers", 1, "number of URL deletion workers")
+	flag.IntVar(&appConfig.FlagDeletionQueueSize, "deletion-queue-size", 1000, "number of queued URL deletion requests")
+	flag.IntVar(&appConfig.FlagDeletionBatchSize, "deletion-batch-size", 1000, "max number of URLs deleted at once")
+	flag.IntVar(&appConfig.FlagDeletionRetries, "deletion-retries", 5, "URL deletion attempts before dead lettering")
+	flag.DurationVar(&appConfig.FlagDeletionFlush, "deletion-flush-interval", 10*time.Second, "URL deletion flush interval")
+	flag.DurationVar(&appConfig.FlagDeletionBackoff, "deletion-backoff", time.Second, "initial URL deletion retry backoff")
+	flag.DurationVar(&appConfig.FlagDeletionMaxBackoff, "deletion-max-backoff", time.Minute, "max URL deletion retry backoff")
+	flag.StringVar(&appConfig.FlagDeadLetter, "deletion-dead-letter", "", "failed URL deletions file, logged if empty")
 	flag.Parse()
 
 	if envRunAddr := os.Getenv("SERVER_ADDRESS"); envRunAddr != "" {
@@ -44,5 +123,124 @@ func ParseFlags() AppConfig {
 		appConfig.FlagDB = envDB
 	}
 
-	return appConfig
+	if envEnableHTTPS := os.Getenv("ENABLE_HTTPS"); envEnableHTTPS != "" {
+		if enableHTTPS, err := strconv.ParseBool(envEnableHTTPS); err == nil {
+			appConfig.FlagEnableHTTPS = enableHTTPS
+		}
+	}
+
+	if envTLSCert := os.Getenv("TLS_CERT_FILE"); envTLSCert != "" {
+		appConfig.FlagTLSCert = envTLSCert
+	}
+
+	if envTLSKey := os.Getenv("TLS_KEY_FILE"); envTLSKey != "" {
+		appConfig.FlagTLSKey = envTLSKey
+	}
+
+	if envTLSReload := os.Getenv("TLS_RELOAD_INTERVAL"); envTLSReload != "" {
+		if interval, err := time.ParseDuration(envTLSReload); err == nil {
+			appConfig.FlagTLSReload = interval
+		}
+	}
+
+	if envRedirectAddr := os.Getenv("HTTP_REDIRECT_ADDRESS"); envRedirectAddr != "" {
+		appConfig.FlagHTTPRedirectAddr = envRedirectAddr
+	}
+
+	if envHSTSMaxAge := os.Getenv("HSTS_MAX_AGE"); envHSTSMaxAge != "" {
+		if maxAge, err := time.ParseDuration(envHSTSMaxAge); err == nil {
+			appConfig.FlagHSTSMaxAge = maxAge
+		}
+	}
+
+	if envTrustedSubnet := os.Getenv("TRUSTED_SUBNET"); envTrustedSubnet != "" {
+		appConfig.FlagTrustedSubnet = envTrustedSubnet
+	}
+
+	if envTrustedProxies := os.Getenv("TRUSTED_PROXIES"); envTrustedProxies != "" {
+		appConfig.FlagTrustedProxies = envTrustedProxies
+	}
+
+	if envAdminKey := os.Getenv("ADMIN_API_KEY"); envAdminKey != "" {
+		appConfig.FlagAdminKey = envAdminKey
+	}
+
+	if envAuditLog := os.Getenv("AUDIT_LOG_PATH"); envAuditLog != "" {
+		appConfig.FlagAuditLog = envAuditLog
+	}
+
+	if envGeoIP := os.Getenv("GEOIP_DB_PATH"); envGeoIP != "" {
+		appConfig.FlagGeoIP = envGeoIP
+	}
+
+	if envGRPCAddr := os.Getenv("GRPC_ADDRESS"); envGRPCAddr != "" {
+		appConfig.FlagGRPCAddr = envGRPCAddr
+	}
+
+	if envBolt := os.Getenv("BOLT_STORAGE_PATH"); envBolt != "" {
+		appConfig.FlagBolt = envBolt
+	}
+
+	if envSQLite := os.Getenv("SQLITE_STORAGE_PATH"); envSQLite != "" {
+		appConfig.FlagSQLite = envSQLite
+	}
+
+	if envFileSync := os.Getenv("FILE_STORAGE_SYNC"); envFileSync != "" {
+		appConfig.FlagFileSync = envFileSync
+	}
+
+	if envFileSyncInterval := os.Getenv("FILE_STORAGE_SYNC_INTERVAL"); envFileSyncInterval != "" {
+		interval, err := time.ParseDuration(envFileSyncInterval)
+		if err != nil {
+			return appConfig, fmt.Errorf("invalid value %q for FILE_STORAGE_SYNC_INTERVAL: %w", envFileSyncInterval, err)
+		}
+		appConfig.FlagFileSyncInterval = interval
+	}
+
+	if envRetention := os.Getenv("DELETED_RETENTION"); envRetention != "" {
+		if retention, err := time.ParseDuration(envRetention); err == nil {
+			appConfig.FlagRetention = retention
+		}
+	}
+
+	if envPurgeInterval := os.Getenv("PURGE_INTERVAL"); envPurgeInterval != "" {
+		if interval, err := time.ParseDuration(envPurgeInterval); err == nil {
+			appConfig.FlagPurgeInterval = interval
+		}
+	}
+
+	if envDeadLetter := os.Getenv("DELETION_DEAD_LETTER_PATH"); envDeadLetter != "" {
+		appConfig.FlagDeadLetter = envDeadLetter
+	}
+
+	for env, value := range map[string]*int{
+		"DELETION_WORKERS":    &appConfig.FlagDeletionWorkers,
+		"DELETION_QUEUE_SIZE": &appConfig.FlagDeletionQueueSize,
+		"DELETION_BATCH_SIZE": &appConfig.FlagDeletionBatchSize,
+		"DELETION_RETRIES":    &appConfig.FlagDeletionRetries,
+	} {
+		if envValue := os.Getenv(env); envValue != "" {
+			n, err := strconv.Atoi(envValue)
+			if err != nil {
+				return appConfig, fmt.Errorf("invalid value %q for %s: %w", envValue, env, err)
+			}
+			*value = n
+		}
+	}
+
+	for env, value := range map[string]*time.Duration{
+		"DELETION_FLUSH_INTERVAL": &appConfig.FlagDeletionFlush,
+		"DELETION_BACKOFF":        &appConfig.FlagDeletionBackoff,
+		"DELETION_MAX_BACKOFF":    &appConfig.FlagDeletionMaxBackoff,
+	} {
+		if envValue := os.Getenv(env); envValue != "" {
+			d, err := time.ParseDuration(envValue)
+			if err != nil {
+				return appConfig, fmt.Errorf("invalid value %q for %s: %w", envValue, env, err)
+			}
+			*value = d
+		}
+	}
+
+	return appConfig, nil
 }
@@ -1,88 +1,123 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
 	"github.com/ZhuzhomaAL/go-shortener/internal/app"
-	"github.com/ZhuzhomaAL/go-shortener/internal/file"
+	"github.com/ZhuzhomaAL/go-shortener/internal/audit"
 	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
-	"github.com/ZhuzhomaAL/go-shortener/internal/postgres"
-	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/routing"
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
 	"go.uber.org/zap"
-	"io"
 	"log"
-	"net/http"
-	"sync"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
-var urlList sync.Map
-
 func main() {
-	appConfig := config.ParseFlags()
-	var reader store.Reader
-	var writer store.Writer
-
-	switch {
-	case appConfig.FlagDB != "":
-		db := postgres.GetConnection(appConfig.FlagDB)
-		defer db.Close()
-		err := postgres.InitializeDB(db)
-		if err != nil {
-			log.Fatal(err)
-		}
-		reader = &store.DBReader{DB: db}
-		writer = &store.DBWriter{DB: db}
-	case appConfig.FlagStorage != "":
-		urlList = sync.Map{}
-		memoryReader := store.MemoryReader{
-			URLList: &urlList,
+	if len(os.Args) > 1 {
+		command := os.Args[1]
+		switch command {
+		case "export", "import":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			if err := runTransfer(command); err != nil {
+				log.Fatal(err)
+			}
+			return
 		}
-		reader = &store.FileReader{MemoryReader: &memoryReader}
-		memoryWriter := store.MemoryWriter{
-			URLList: &urlList,
+	}
+
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until SIGINT or SIGTERM, then shuts the servers down, flushes the
+// queued deletions and closes the storage.
+func run() (err error) {
+	appConfig, err := config.ParseFlags()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reader, writer, closeStorage, err := openStorage(appConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeStorage(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close storage: %w", closeErr))
 		}
-		fWriter, err := file.NewFileWriter(appConfig.FlagStorage)
+	}()
+	myLogger, err := logger.Initialize(appConfig.FlagLogLevel)
+	if err != nil {
+		return err
+	}
+	// The workers outlive ctx so that deletions accepted while the servers shut
+	// down are flushed too.
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	opts := []service.Option{service.WithContext(workersCtx)}
+	if appConfig.FlagDeadLetter != "" {
+		deadLetterFile, err := os.OpenFile(appConfig.FlagDeadLetter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 		if err != nil {
-			log.Fatal(err)
-		}
-		writer = &store.FileWriter{
-			MemoryWriter: &memoryWriter, Writer: fWriter,
+			stopWorkers()
+			return err
 		}
-		fReader, err := file.NewFileReader(appConfig.FlagStorage)
+		defer deadLetterFile.Close()
+		opts = append(opts, service.WithDeadLetter(service.NewDeadLetterLog(deadLetterFile)))
+	}
+	a := app.NewApp(appConfig, myLogger, reader, writer, opts...)
+	defer func() {
+		stopWorkers()
+		a.Wait()
+	}()
+	if appConfig.FlagAuditLog != "" {
+		auditFile, err := os.OpenFile(appConfig.FlagAuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 		if err != nil {
-			log.Fatal(err)
-		}
-		for {
-			url, err := fReader.ReadFile()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				log.Fatal(fmt.Errorf("failed to read the storage file: %w", err))
-			}
-			urlList.Store(url.ShortURL, url.OriginalURL)
-		}
-	default:
-		urlList = sync.Map{}
-		reader = &store.MemoryReader{
-			URLList: &urlList,
-		}
-		writer = &store.MemoryWriter{
-			URLList: &urlList,
+			return err
 		}
+		defer auditFile.Close()
+		a.SetAuditLog(audit.NewLog(auditFile))
 	}
-	myLogger, err := logger.Initialize(appConfig.FlagLogLevel)
-	if err != nil {
-		log.Fatal(err)
+	if appConfig.FlagGeoIP != "" {
+		geoIP, err := routing.OpenMaxMindDB(appConfig.FlagGeoIP)
+		if err != nil {
+			return err
+		}
+		defer geoIP.Close()
+		a.SetGeoIP(geoIP)
 	}
-	a := app.NewApp(appConfig, myLogger, reader, writer)
+	go a.RunRetention(ctx)
 	r, err := app.Router(a)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	myLogger.L.Info("Running server", zap.String("address", appConfig.FlagRunAddr))
-	err = http.ListenAndServe(appConfig.FlagRunAddr, r)
-	if err != nil {
-		log.Fatal(err)
+	grpcErr := make(chan error, 1)
+	if appConfig.FlagGRPCAddr != "" {
+		listener, err := net.Listen("tcp", appConfig.FlagGRPCAddr)
+		if err != nil {
+			return err
+		}
+		grpcServer := app.GRPCServer(a)
+		go func() {
+			myLogger.L.Info("Running gRPC server", zap.String("address", appConfig.FlagGRPCAddr))
+			grpcErr <- grpcServer.Serve(listener)
+			stop()
+		}()
+		defer grpcServer.GracefulStop()
+	}
+	err = serve(ctx, appConfig, r, myLogger)
+	select {
+	case grpcServeErr := <-grpcErr:
+		err = errors.Join(err, grpcServeErr)
+	default:
 	}
+	myLogger.L.Info("Server stopped")
+
+	return err
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
+	"github.com/ZhuzhomaAL/go-shortener/internal/certs"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long the servers wait for the active requests on
+// shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// serve runs the HTTP server, or the HTTPS one along with the optional plain
+// HTTP redirect listener if HTTPS is enabled, until ctx is done.
+func serve(ctx context.Context, appConfig config.AppConfig, handler http.Handler, myLogger logger.MyLogger) error {
+	server := &http.Server{Addr: appConfig.FlagRunAddr, Handler: handler}
+	servers := []*http.Server{server}
+	listen := func() error {
+		myLogger.L.Info("Running server", zap.String("address", appConfig.FlagRunAddr))
+		return server.ListenAndServe()
+	}
+	if appConfig.FlagEnableHTTPS {
+		if appConfig.FlagTLSCert == "" || appConfig.FlagTLSKey == "" {
+			return errors.New("TLS certificate and key files are required to serve HTTPS")
+		}
+		reloader, err := certs.NewReloader(appConfig.FlagTLSCert, appConfig.FlagTLSKey)
+		if err != nil {
+			return err
+		}
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		defer signal.Stop(sighup)
+		go reloader.Watch(ctx, appConfig.FlagTLSReload, sighup, myLogger)
+
+		server.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		if appConfig.FlagHTTPRedirectAddr != "" {
+			redirectServer := &http.Server{
+				Addr:    appConfig.FlagHTTPRedirectAddr,
+				Handler: utils.RedirectToHTTPS(appConfig.FlagRunAddr),
+			}
+			servers = append(servers, redirectServer)
+			go func() {
+				myLogger.L.Info("Running HTTP redirect server", zap.String("address", appConfig.FlagHTTPRedirectAddr))
+				if err := redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+					myLogger.L.Error("HTTP redirect server stopped", zap.Error(err))
+				}
+			}()
+		}
+		listen = func() error {
+			myLogger.L.Info("Running HTTPS server", zap.String("address", appConfig.FlagRunAddr))
+			return server.ListenAndServeTLS("", "")
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- listen()
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var errs []error
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
+	"github.com/ZhuzhomaAL/go-shortener/internal/boltdb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/file"
+	"github.com/ZhuzhomaAL/go-shortener/internal/postgres"
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqldb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqlite"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"sync"
+)
+
+var urlList sync.Map
+var fullURLList sync.Map
+
+// openStorage returns the reader and writer of the storage selected by the
+// config and a function releasing the storage resources, the file storage
+// syncs the pending records on it.
+func openStorage(appConfig config.AppConfig) (store.Reader, store.Writer, func() error, error) {
+	switch {
+	case appConfig.FlagDB != "":
+		db := postgres.GetConnection(appConfig.FlagDB)
+		err := postgres.InitializeDB(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, nil, err
+		}
+		return &store.DBReader{DB: db}, &store.DBWriter{DB: db}, db.Close, nil
+	case appConfig.FlagSQLite != "":
+		db, err := sqlite.GetConnection(appConfig.FlagSQLite)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		err = sqlite.InitializeDB(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, nil, err
+		}
+		reader := &store.DBReader{DB: db, Dialect: sqldb.SQLite{}}
+		writer := &store.DBWriter{DB: db, Dialect: sqldb.SQLite{}}
+		return reader, writer, db.Close, nil
+	case appConfig.FlagBolt != "":
+		db, err := boltdb.GetConnection(appConfig.FlagBolt)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		err = boltdb.InitializeDB(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, nil, err
+		}
+		return &store.BoltReader{DB: db}, &store.BoltWriter{DB: db}, db.Close, nil
+	case appConfig.FlagStorage != "":
+		urlList = sync.Map{}
+		fullURLList = sync.Map{}
+		tags := &store.TagIndex{}
+		memoryReader := store.MemoryReader{
+			URLList: &urlList, Tags: tags,
+		}
+		memoryWriter := store.MemoryWriter{
+			URLList: &urlList, FullURLList: &fullURLList, Tags: tags,
+		}
+		fReader, err := file.NewFileReader(appConfig.FlagStorage)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer fReader.Close()
+		err = store.LoadFile(fReader, &memoryWriter)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		syncMode, err := file.ParseSyncMode(appConfig.FlagFileSync)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		fWriter, err := file.NewFileWriter(appConfig.FlagStorage, syncMode, appConfig.FlagFileSyncInterval)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		reader := &store.FileReader{MemoryReader: &memoryReader}
+		writer := &store.FileWriter{
+			MemoryWriter: &memoryWriter, Writer: fWriter,
+		}
+		return reader, writer, fWriter.Close, nil
+	default:
+		urlList = sync.Map{}
+		fullURLList = sync.Map{}
+		tags := &store.TagIndex{}
+		reader := &store.MemoryReader{
+			URLList: &urlList, Tags: tags,
+		}
+		writer := &store.MemoryWriter{
+			URLList: &urlList, FullURLList: &fullURLList, Tags: tags,
+		}
+		return reader, writer, func() error { return nil }, nil
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
+	"github.com/ZhuzhomaAL/go-shortener/internal/file"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/transfer"
+	"io"
+	"os"
+)
+
+// runTransfer runs the export or import command. Both accept the storage flags
+// of the server along with their own ones.
+func runTransfer(command string) (err error) {
+	format := flag.String("format", "jsonl", "records format: jsonl or csv")
+	path := flag.String("path", "-", "file to "+command+", - for standard streams")
+	batchSize := flag.Int("batch-size", 1000, "import: records saved in one batch")
+	dryRun := flag.Bool("dry-run", false, "import: read and check records without saving them")
+	onConflict := flag.String("on-conflict", "fail", "import: existing record policy: skip, overwrite or fail")
+	appConfig, err := config.ParseFlags()
+	if err != nil {
+		return err
+	}
+
+	recordsFormat, err := transfer.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	reader, writer, closeStorage, err := openStorage(appConfig)
+	if errors.Is(err, file.ErrLocked) {
+		return fmt.Errorf("storage file %s is used by a running server, stop it before the %s", appConfig.FlagStorage, command)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeStorage(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close storage: %w", closeErr))
+		}
+	}()
+	ctx := context.Background()
+
+	if command == "export" {
+		iterable, ok := reader.(store.Iterable)
+		if !ok {
+			return errors.New("storage does not support export")
+		}
+		w := io.Writer(os.Stdout)
+		if *path != "-" {
+			f, err := os.Create(*path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		count, err := transfer.Export(ctx, iterable, w, recordsFormat)
+		if err != nil {
+			return fmt.Errorf("export failed after %d records: %w", count, err)
+		}
+		fmt.Fprintf(os.Stderr, "exported %d records\n", count)
+		return nil
+	}
+
+	policy, err := transfer.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+	r := io.Reader(os.Stdin)
+	if *path != "-" {
+		f, err := os.Open(*path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	opts := transfer.ImportOptions{Format: recordsFormat, BatchSize: *batchSize, DryRun: *dryRun, OnConflict: policy}
+	res, err := transfer.Import(ctx, reader, writer, r, opts)
+	fmt.Fprintf(
+		os.Stderr, "read %d, saved %d, overwritten %d, skipped %d records\n",
+		res.Read, res.Saved, res.Overwritten, res.Skipped,
+	)
+	if err != nil {
+		return fmt.Errorf("import failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// adminURL is the stored URL with its owner and metadata as seen by an admin.
+type adminURL struct {
+	ID string `json:"id"`
+	usersURL
+	UserID    uuid.UUID  `json:"user_id"`
+	IsDeleted bool       `json:"is_deleted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type adminResult struct {
+	Affected int `json:"affected"`
+}
+
+func adminActor(req *http.Request) string {
+	actor, _ := req.Context().Value(utils.ContextAdmin).(string)
+	return actor
+}
+
+func (a *app) adminError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		http.Error(rw, "short url not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrNotSupported):
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+	default:
+		a.myLogger.L.Error("failed to process admin request", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+	}
+}
+
+func (a *app) writeJSON(rw http.ResponseWriter, v any, status int) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		a.myLogger.L.Error("failed to process request", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if _, err := rw.Write(resp); err != nil {
+		a.myLogger.L.Error("failed to retrieve response", zap.Error(err))
+	}
+}
+
+func (a *app) adminLookupHandler(rw http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+	URL, err := a.service.AdminLookup(req.Context(), adminActor(req), id)
+	if err != nil {
+		a.adminError(rw, err)
+		return
+	}
+	details, err := a.newUsersURL(URL)
+	if err != nil {
+		a.adminError(rw, err)
+		return
+	}
+	res := adminURL{
+		ID:        URL.ShortURL,
+		usersURL:  details,
+		UserID:    URL.UserID,
+		IsDeleted: URL.IsDeleted,
+	}
+	if !URL.DeletedAt.IsZero() {
+		res.DeletedAt = &URL.DeletedAt
+	}
+	a.writeJSON(rw, res, http.StatusOK)
+}
+
+func (a *app) adminDeleteHandler(rw http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+	err := a.service.AdminDelete(req.Context(), adminActor(req), id)
+	if err != nil {
+		a.adminError(rw, err)
+		return
+	}
+	a.qrCache.forget(id)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *app) adminRestoreHandler(rw http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+	err := a.service.AdminRestore(req.Context(), adminActor(req), id)
+	if err != nil {
+		a.adminError(rw, err)
+		return
+	}
+	a.qrCache.forget(id)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *app) adminDisableUserHandler(rw http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(req, "userID"))
+	if err != nil {
+		http.Error(rw, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+	affected, err := a.service.AdminDisableUser(req.Context(), adminActor(req), userID)
+	if err != nil {
+		a.adminError(rw, err)
+		return
+	}
+	a.qrCache.reset()
+	a.writeJSON(rw, adminResult{Affected: affected}, http.StatusOK)
+}
+
+func (a *app) adminDisableDomainHandler(rw http.ResponseWriter, req *http.Request) {
+	affected, err := a.service.AdminDisableDomain(req.Context(), adminActor(req), chi.URLParam(req, "domain"))
+	if err != nil {
+		a.adminError(rw, err)
+		return
+	}
+	a.qrCache.reset()
+	a.writeJSON(rw, adminResult{Affected: affected}, http.StatusOK)
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
+	"github.com/ZhuzhomaAL/go-shortener/internal/audit"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, ts *httptest.Server, method, path string, header http.Header) (int, string) {
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(body)
+}
+
+func TestAdminHandlers(t *testing.T) {
+	myLogger, err := logger.Initialize("error")
+	require.NoError(t, err)
+	var adminURLList, adminFullURLList sync.Map
+	a := NewApp(
+		config.AppConfig{FlagShortAddr: "http://localhost:8080", FlagAdminKey: "alice:secret,bob:other"}, myLogger,
+		&store.MemoryReader{URLList: &adminURLList},
+		&store.MemoryWriter{URLList: &adminURLList, FullURLList: &adminFullURLList},
+	)
+	var auditLog bytes.Buffer
+	a.SetAuditLog(audit.NewLog(&auditLog))
+	r, err := Router(a)
+	require.NoError(t, err)
+	admin := httptest.NewServer(r)
+	defer admin.Close()
+
+	owner, other := uuid.New(), uuid.New()
+	for _, u := range []store.URL{
+		{
+			ShortURL: "abuse001", OriginalURL: "https://spam.example/a", UserID: owner, Title: "Распродажа",
+			Tags: []string{"promo"}, MaxClicks: 10, Clicks: 3, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{ShortURL: "abuse002", OriginalURL: "https://cdn.spam.example/b", UserID: other},
+		{ShortURL: "normal01", OriginalURL: "https://notspam.example/", UserID: owner},
+		{ShortURL: "normal02", OriginalURL: "https://ya.ru", UserID: other},
+	} {
+		adminURLList.Store(u.ShortURL, u)
+	}
+	userToken, err := utils.GenerateJWT(owner)
+	require.NoError(t, err)
+
+	keyHeader := http.Header{"X-Admin-Key": {"secret"}}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		header         http.Header
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "no_credentials",
+			method:         http.MethodGet,
+			path:           "/api/admin/urls/abuse001",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "wrong_key",
+			method:         http.MethodGet,
+			path:           "/api/admin/urls/abuse001",
+			header:         http.Header{"X-Admin-Key": {"wrong"}},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "user_token",
+			method:         http.MethodGet,
+			path:           "/api/admin/urls/abuse001",
+			header:         http.Header{"Authorization": {"Bearer " + userToken}},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "lookup",
+			method:         http.MethodGet,
+			path:           "/api/admin/urls/abuse001",
+			header:         keyHeader,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"user_id":"` + owner.String() + `"`,
+		},
+		{
+			name:           "lookup_metadata",
+			method:         http.MethodGet,
+			path:           "/api/admin/urls/abuse001",
+			header:         keyHeader,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"created_at":"2024-01-02T03:04:05Z","updated_at":"0001-01-01T00:00:00Z","title":"Распродажа","tags":["promo"],"max_clicks":10,"clicks":3`,
+		},
+		{
+			name:           "lookup_not_found",
+			method:         http.MethodGet,
+			path:           "/api/admin/urls/missing1",
+			header:         keyHeader,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "force_delete",
+			method:         http.MethodDelete,
+			path:           "/api/admin/urls/normal02",
+			header:         keyHeader,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "restore",
+			method:         http.MethodPost,
+			path:           "/api/admin/urls/normal02/restore",
+			header:         keyHeader,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "disable_domain",
+			method:         http.MethodPost,
+			path:           "/api/admin/domains/spam.example/disable",
+			header:         keyHeader,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"affected":2}`,
+		},
+		{
+			name:           "disable_user",
+			method:         http.MethodPost,
+			path:           "/api/admin/users/" + owner.String() + "/disable",
+			header:         keyHeader,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"affected":1}`,
+		},
+		{
+			name:           "disable_invalid_user",
+			method:         http.MethodPost,
+			path:           "/api/admin/users/nobody/disable",
+			header:         keyHeader,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				status, body := adminRequest(t, admin, tt.method, tt.path, tt.header)
+				assert.Equal(t, tt.expectedStatus, status, "Код ответа не совпадает с ожидаемым")
+				assert.Contains(t, body, tt.expectedBody, "Тело ответа не совпадает с ожидаемым")
+			},
+		)
+	}
+
+	for id, deleted := range map[string]bool{"abuse001": true, "abuse002": true, "normal01": true, "normal02": false} {
+		value, ok := adminURLList.Load(id)
+		require.True(t, ok)
+		assert.Equal(t, deleted, value.(store.URL).IsDeleted, "Неверный статус удаления %s", id)
+	}
+
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(auditLog.String()), "\n") {
+		var entry audit.Entry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, "alice", entry.Actor, "Неверный автор действия")
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{"lookup", "lookup", "delete", "restore", "disable_domain", "disable_user"}, actions)
+}
+
+func TestAdminHandlers_NoKey(t *testing.T) {
+	myLogger, err := logger.Initialize("error")
+	require.NoError(t, err)
+	var adminURLList, adminFullURLList sync.Map
+	a := NewApp(
+		config.AppConfig{FlagShortAddr: "http://localhost:8080"}, myLogger,
+		&store.MemoryReader{URLList: &adminURLList},
+		&store.MemoryWriter{URLList: &adminURLList, FullURLList: &adminFullURLList},
+	)
+	r, err := Router(a)
+	require.NoError(t, err)
+	admin := httptest.NewServer(r)
+	defer admin.Close()
+
+	status, _ := adminRequest(t, admin, http.MethodGet, "/api/admin/urls/abuse001", http.Header{"X-Admin-Key": {""}})
+	assert.Equal(t, http.StatusNotFound, status, "Админский API доступен без ключа")
+}
@@ -3,51 +3,70 @@ package app
 import (
 	"context"
 	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
+	"github.com/ZhuzhomaAL/go-shortener/internal/audit"
 	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/ZhuzhomaAL/go-shortener/internal/routing"
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
 	"github.com/ZhuzhomaAL/go-shortener/internal/store"
-	"go.uber.org/zap"
-	"time"
+	"net"
+	"net/url"
 )
 
 type app struct {
 	appConfig config.AppConfig
 	myLogger  logger.MyLogger
-	reader    store.Reader
-	writer    store.Writer
-	storeChan chan []store.URL
+	service   *service.Service
+	qrCache   *qrCache
+	// trustedProxies are set by Router, their headers give the client address.
+	trustedProxies []*net.IPNet
 }
 
-func NewApp(appConfig config.AppConfig, myLogger logger.MyLogger, reader store.Reader, writer store.Writer) *app {
-	a := &app{appConfig: appConfig, myLogger: myLogger, reader: reader, writer: writer, storeChan: make(chan []store.URL, 1000)}
+func NewApp(
+	appConfig config.AppConfig, myLogger logger.MyLogger, reader store.Reader, writer store.Writer,
+	opts ...service.Option,
+) *app {
+	deletionConfig := service.DeletionConfig{
+		Workers:        appConfig.FlagDeletionWorkers,
+		QueueSize:      appConfig.FlagDeletionQueueSize,
+		MaxBatch:       appConfig.FlagDeletionBatchSize,
+		FlushInterval:  appConfig.FlagDeletionFlush,
+		MaxRetries:     appConfig.FlagDeletionRetries,
+		InitialBackoff: appConfig.FlagDeletionBackoff,
+		MaxBackoff:     appConfig.FlagDeletionMaxBackoff,
+	}
+	opts = append([]service.Option{service.WithDeletionConfig(deletionConfig)}, opts...)
 
-	go a.deleteURLS()
+	return &app{
+		appConfig: appConfig, myLogger: myLogger, service: service.New(reader, writer, myLogger, opts...),
+		qrCache: newQRCache(qrCacheSize),
+	}
+}
+
+func (a *app) SetAuditLog(auditLog audit.Recorder) {
+	a.service.SetAuditLog(auditLog)
+}
 
-	return a
+// SetGeoIP sets the database resolving the countries of visitors for routing
+// rules.
+func (a *app) SetGeoIP(geoIP routing.GeoIP) {
+	a.service.SetGeoIP(geoIP)
 }
 
-func (a *app) deleteURLS() {
-	ticker := time.NewTicker(10 * time.Second)
-
-	var URLs []store.URL
-	ctx := context.Background()
-
-	for {
-		select {
-		case URL := <-a.storeChan:
-			URLs = append(URLs, URL...)
-		case <-ticker.C:
-			if len(URLs) == 0 {
-				continue
-			}
-			if writer, ok := a.writer.(store.WriterDeleter); ok {
-				err := writer.DeleteURLs(ctx, URLs)
-				if err != nil {
-					a.myLogger.L.Error("failed to delete URLs", zap.Error(err))
-					continue
-				}
-				a.myLogger.L.Info("successfully deleted URLs")
-				URLs = nil
-			}
-		}
+// Wait waits until the deletion workers flush the queued deletions and stop,
+// see service.WithContext.
+func (a *app) Wait() {
+	a.service.Wait()
+}
+
+// RunRetention purges URLs deleted longer than the configured retention ago
+// until ctx is done, it returns at once if retention is disabled.
+func (a *app) RunRetention(ctx context.Context) {
+	if a.appConfig.FlagRetention <= 0 {
+		return
 	}
+	a.service.RunRetention(ctx, a.appConfig.FlagRetention, a.appConfig.FlagPurgeInterval)
+}
+
+func (a *app) shortURL(id string) (string, error) {
+	return url.JoinPath(a.appConfig.FlagShortAddr, id)
 }
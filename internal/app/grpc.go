@@ -0,0 +1,271 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/pb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"net/url"
+)
+
+const tokenMetadataKey = "token"
+
+type grpcServer struct {
+	pb.UnimplementedShortenerServer
+	app *app
+}
+
+func GRPCServer(app *app) *grpc.Server {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(app.myLogger.UnaryLogger, app.authInterceptor))
+	pb.RegisterShortenerServer(s, &grpcServer{app: app})
+
+	return s
+}
+
+// authInterceptor mirrors utils.AuthMiddleware: callers without a valid token
+// get a new user ID, except for the methods working with existing user URLs.
+func (a *app) authInterceptor(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	requiresUser := info.FullMethod == pb.Shortener_ListUserURLs_FullMethodName ||
+		info.FullMethod == pb.Shortener_DeleteUserURLs_FullMethodName ||
+		info.FullMethod == pb.Shortener_GetDeletion_FullMethodName
+
+	var id uuid.UUID
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(tokenMetadataKey); len(values) > 0 {
+			token = values[0]
+		}
+	}
+	if token != "" {
+		userID, err := utils.GetUserID(token)
+		if err == nil {
+			id = userID
+		}
+	}
+	if id == uuid.Nil {
+		if requiresUser {
+			return nil, status.Error(codes.Unauthenticated, "valid token is required")
+		}
+		id = uuid.New()
+		tokenString, err := utils.GenerateJWT(id)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "internal server error occurred")
+		}
+		if err := grpc.SetHeader(ctx, metadata.Pairs(tokenMetadataKey, tokenString)); err != nil {
+			return nil, status.Error(codes.Internal, "internal server error occurred")
+		}
+	}
+
+	return handler(context.WithValue(ctx, utils.ContextUserID, id), req)
+}
+
+func userIDFromContext(ctx context.Context) (uuid.UUID, error) {
+	userID, ok := ctx.Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		return uuid.Nil, status.Error(codes.Internal, "internal server error occurred")
+	}
+	return userID, nil
+}
+
+func (s *grpcServer) internalError(msg string, err error) error {
+	s.app.myLogger.L.Error(msg, zap.Error(err))
+	return status.Error(codes.Internal, "internal server error occurred")
+}
+
+// serviceError maps domain errors of the service to gRPC status codes.
+func (s *grpcServer) serviceError(msg string, err error) error {
+	switch {
+	case errors.Is(err, service.ErrEmptyURL):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrNotFound), errors.Is(err, service.ErrDeleted),
+		errors.Is(err, service.ErrDeletionNotFound), errors.Is(err, service.ErrClickLimit):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, service.ErrQueueFull):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, service.ErrNotSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, service.ErrPasswordRequired), errors.Is(err, service.ErrWrongPassword):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	var attemptsErr *service.TooManyAttemptsError
+	if errors.As(err, &attemptsErr) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return s.internalError(msg, err)
+}
+
+func (s *grpcServer) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	userID, err := userIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var resp pb.ShortenResponse
+	id, err := s.app.service.Shorten(ctx, userID, req.GetUrl())
+	if err != nil {
+		var conflictErr *service.ConflictError
+		if !errors.As(err, &conflictErr) {
+			return nil, s.serviceError("failed to persist data", err)
+		}
+		id = conflictErr.ID
+		resp.Conflict = true
+	}
+	resp.ShortUrl, err = s.app.shortURL(id)
+	if err != nil {
+		return nil, s.internalError("failed to process request", err)
+	}
+
+	return &resp, nil
+}
+
+func (s *grpcServer) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	userID, err := userIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var originalURLs []string
+	for _, URL := range req.GetUrls() {
+		originalURLs = append(originalURLs, URL.GetOriginalUrl())
+	}
+	ids, err := s.app.service.ShortenBatch(ctx, userID, originalURLs)
+	if err != nil {
+		return nil, s.serviceError("failed to persist data", err)
+	}
+	var resp pb.ShortenBatchResponse
+	for i, URL := range req.GetUrls() {
+		shortURL, err := s.app.shortURL(ids[i])
+		if err != nil {
+			return nil, s.internalError("failed to process request", err)
+		}
+		resp.Urls = append(resp.Urls, &pb.BatchResult{CorrelationId: URL.GetCorrelationId(), ShortUrl: shortURL})
+	}
+
+	return &resp, nil
+}
+
+// Expand counts the visit as a redirect of the HTTP API does, the visitor is
+// the caller.
+func (s *grpcServer) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	query, err := url.ParseQuery(req.GetQuery())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid query")
+	}
+	URL, err := s.app.service.Unlock(ctx, req.GetId(), req.GetPassword())
+	if err != nil {
+		return nil, s.serviceError("failed to get URL", err)
+	}
+	var ip net.IP
+	if p, ok := peer.FromContext(ctx); ok {
+		if addr, ok := p.Addr.(*net.TCPAddr); ok {
+			ip = addr.IP
+		}
+	}
+	visit := service.NewVisit(req.GetUserAgent(), req.GetAcceptLanguage(), ip, query)
+	destination := s.app.service.Destination(URL, visit)
+	if err := s.app.service.Redirect(ctx, URL, destination); err != nil {
+		return nil, s.serviceError("failed to count visit", err)
+	}
+
+	return &pb.ExpandResponse{OriginalUrl: URL.OriginalURL, Location: destination.Location}, nil
+}
+
+func (s *grpcServer) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userID, err := userIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	opts := store.ListOptions{Limit: int(req.GetLimit()), IncludeDeleted: req.GetIncludeDeleted()}
+	if req.GetCursor() != "" {
+		cursor, err := store.DecodeCursor(req.GetCursor())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		opts.Cursor = &cursor
+	}
+	page, err := s.app.service.ListPageByUser(ctx, userID, opts)
+	if err != nil {
+		return nil, s.serviceError("failed to get URLs by user ID", err)
+	}
+	resp := pb.ListUserURLsResponse{Total: int32(page.Total)}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.Encode()
+	}
+	for _, URL := range page.URLs {
+		shortURL, err := s.app.shortURL(URL.ShortURL)
+		if err != nil {
+			return nil, s.internalError("failed to process request", err)
+		}
+		resp.Urls = append(
+			resp.Urls, &pb.UserURL{
+				ShortUrl: shortURL, OriginalUrl: URL.OriginalURL, CreatedAt: timestamppb.New(URL.CreatedAt),
+				UpdatedAt: timestamppb.New(URL.UpdatedAt), Title: URL.Title, Tags: URL.Tags, Note: URL.Note,
+				IsDeleted: URL.IsDeleted,
+			},
+		)
+	}
+
+	return &resp, nil
+}
+
+func (s *grpcServer) DeleteUserURLs(
+	ctx context.Context, req *pb.DeleteUserURLsRequest,
+) (*pb.DeleteUserURLsResponse, error) {
+	userID, err := userIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.app.service.DeleteForUser(ctx, userID, req.GetIds())
+	if err != nil {
+		return nil, s.serviceError("can not filter urls by user ID", err)
+	}
+	s.app.qrCache.forget(req.GetIds()...)
+
+	return &pb.DeleteUserURLsResponse{JobId: job.ID.String()}, nil
+}
+
+func (s *grpcServer) GetDeletion(ctx context.Context, req *pb.GetDeletionRequest) (*pb.GetDeletionResponse, error) {
+	userID, err := userIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jobID, err := uuid.Parse(req.GetJobId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid job ID")
+	}
+	job, err := s.app.service.GetDeletion(ctx, userID, jobID)
+	if err != nil {
+		return nil, s.serviceError("failed to get deletion job", err)
+	}
+
+	return &pb.GetDeletionResponse{
+		JobId:    job.ID.String(),
+		Status:   string(job.Status),
+		Accepted: job.Accepted,
+		NotOwned: job.NotOwned,
+		Error:    job.Error,
+	}, nil
+}
+
+func (s *grpcServer) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.app.service.Ping(ctx); err != nil {
+		s.app.myLogger.L.Error("failed to connect to database", zap.Error(err))
+		return nil, status.Error(codes.Unavailable, "failed to connect to database")
+	}
+
+	return &pb.PingResponse{}, nil
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/ZhuzhomaAL/go-shortener/internal/pb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newGRPCClient(t *testing.T) (pb.ShortenerClient, *sync.Map) {
+	myLogger, err := logger.Initialize("error")
+	require.NoError(t, err)
+	urlList := &sync.Map{}
+	reader := &store.MemoryReader{URLList: urlList}
+	writer := &store.MemoryWriter{URLList: urlList, FullURLList: &sync.Map{}}
+	a := NewApp(config.AppConfig{FlagShortAddr: "http://localhost:8080"}, myLogger, reader, writer)
+
+	listener := bufconn.Listen(1024 * 1024)
+	s := GRPCServer(a)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(
+			func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			},
+		),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewShortenerClient(conn), urlList
+}
+
+func TestGRPCServer(t *testing.T) {
+	client, urlList := newGRPCClient(t)
+	ctx := context.Background()
+
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://ya.ru"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(resp.GetShortUrl(), "http://localhost:8080/"))
+	assert.False(t, resp.GetConflict())
+	tokens := header.Get(tokenMetadataKey)
+	require.Len(t, tokens, 1, "Токен не выдан")
+	authCtx := metadata.AppendToOutgoingContext(ctx, tokenMetadataKey, tokens[0])
+
+	conflict, err := client.Shorten(authCtx, &pb.ShortenRequest{Url: "https://ya.ru"})
+	require.NoError(t, err)
+	assert.True(t, conflict.GetConflict())
+	assert.Equal(t, resp.GetShortUrl(), conflict.GetShortUrl())
+
+	id := strings.TrimPrefix(resp.GetShortUrl(), "http://localhost:8080/")
+	expanded, err := client.Expand(ctx, &pb.ExpandRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", expanded.GetOriginalUrl())
+
+	batch, err := client.ShortenBatch(
+		authCtx, &pb.ShortenBatchRequest{
+			Urls: []*pb.BatchURL{{CorrelationId: "1", OriginalUrl: "https://practicum.yandex.ru"}},
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, batch.GetUrls(), 1)
+	assert.Equal(t, "1", batch.GetUrls()[0].GetCorrelationId())
+
+	list, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.GetUrls(), 2)
+	assert.Equal(t, int32(2), list.GetTotal())
+	assert.Empty(t, list.GetNextCursor())
+	first, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, first.GetUrls(), 1)
+	require.NotEmpty(t, first.GetNextCursor(), "Нет курсора следующей страницы")
+	second, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{Limit: 1, Cursor: first.GetNextCursor()})
+	require.NoError(t, err)
+	require.Len(t, second.GetUrls(), 1)
+	assert.NotEqual(t, first.GetUrls()[0].GetShortUrl(), second.GetUrls()[0].GetShortUrl())
+	_, err = client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{Cursor: "invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: "LFGwsFFf"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("секрет"), bcrypt.MinCost)
+	require.NoError(t, err)
+	urlList.Store("grpcpass", store.URL{ShortURL: "grpcpass", OriginalURL: "https://secret.ru", PasswordHash: string(hash)})
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: "grpcpass"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "Ссылка раскрыта без пароля")
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: "grpcpass", Password: "неверный"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "Ссылка раскрыта с неверным паролем")
+	expanded, err = client.Expand(ctx, &pb.ExpandRequest{Id: "grpcpass", Password: "секрет"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://secret.ru", expanded.GetOriginalUrl())
+
+	urlList.Store(
+		"grpcrule", store.URL{
+			ShortURL: "grpcrule", OriginalURL: "https://ya.ru/app", UTM: "utm_source=grpc", QueryPolicy: "merge",
+			Rules: []store.Rule{{Devices: []string{"ios"}, Target: "https://apps.apple.com/app"}},
+		},
+	)
+	expanded, err = client.Expand(
+		ctx, &pb.ExpandRequest{
+			Id: "grpcrule", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", Query: "ref=tg",
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru/app", expanded.GetOriginalUrl())
+	assert.Equal(t, "https://apps.apple.com/app?ref=tg&utm_source=grpc", expanded.GetLocation(), "Правила не применены")
+
+	_, err = client.DeleteUserURLs(authCtx, &pb.DeleteUserURLsRequest{Ids: []string{id}})
+	require.NoError(t, err)
+	value, ok := urlList.Load(id)
+	require.True(t, ok)
+	deleted := value.(store.URL)
+	deleted.IsDeleted = true
+	urlList.Store(id, deleted)
+	list, err = client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.GetUrls(), 1, "Удаленный URL показан в списке")
+	list, err = client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{IncludeDeleted: true})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 2)
+	for _, URL := range list.GetUrls() {
+		assert.Equal(t, strings.HasSuffix(URL.GetShortUrl(), "/"+id), URL.GetIsDeleted(), "Неверный признак удаления")
+	}
+
+	_, err = client.Ping(ctx, &pb.PingRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
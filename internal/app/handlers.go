@@ -2,23 +2,44 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
 	"github.com/ZhuzhomaAL/go-shortener/internal/store"
 	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
-	"github.com/dchest/uniuri"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type result struct {
 	Result string `json:"result"`
+	QR     string `json:"qr,omitempty"`
 }
 
 type reqURL struct {
 	ReqURL string `json:"url"`
+	// QR requests the link of the QR code of the short URL in the result.
+	QR bool `json:"qr"`
+	// Password protects the short URL, visitors enter it before the redirect.
+	Password string `json:"password"`
+	// MaxClicks limits the redirects of the short URL, zero is unlimited.
+	MaxClicks int `json:"max_clicks"`
+	// Rules route visits by device, language and country, the first matching
+	// one wins over the URL.
+	Rules []rule `json:"rules"`
+	// UTM are the UTM parameters added to the destination, QueryPolicy passes
+	// the query of the visit to it: drop (default), merge or override.
+	UTM         map[string]string `json:"utm"`
+	QueryPolicy string            `json:"query_policy"`
 }
 
 func (a *app) postHandler(rw http.ResponseWriter, req *http.Request) {
@@ -37,22 +58,16 @@ func (a *app) postHandler(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, "response body is empty, expected not empty", http.StatusBadRequest)
 		return
 	}
-	genShortStr := uniuri.NewLen(8)
 	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
 	if !ok {
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
-	URL := store.URL{
-		OriginalURL: string(request),
-		ShortURL:    genShortStr,
-		UserID:      userID,
-	}
-	err = a.writer.SaveURL(req.Context(), URL)
+	genShortStr, err := a.service.Shorten(req.Context(), userID, string(request))
 	if err != nil {
-		if err, ok := err.(*store.ConflictError); ok {
+		if err, ok := err.(*service.ConflictError); ok {
 			a.myLogger.L.Error("duplicate key value", zap.Error(err))
-			a.makeSinglePlainResponse(rw, err.ShortURL, http.StatusConflict)
+			a.makeSinglePlainResponse(rw, err.ID, http.StatusConflict)
 			return
 		}
 		a.myLogger.L.Error("failed to persist data", zap.Error(err))
@@ -63,7 +78,7 @@ func (a *app) postHandler(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (a *app) makeSinglePlainResponse(rw http.ResponseWriter, genShortStr string, status int) {
-	respString, err := url.JoinPath(a.appConfig.FlagShortAddr, genShortStr)
+	respString, err := a.shortURL(genShortStr)
 	if err != nil {
 		a.myLogger.L.Error("failed to process request", zap.Error(err))
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
@@ -77,37 +92,91 @@ func (a *app) makeSinglePlainResponse(rw http.ResponseWriter, genShortStr string
 	}
 }
 
+// getHandler redirects to the original URL. The preview page is shown instead
+// for ids ending with "+", the preview query parameter and links always
+// previewed by their owners. Links with a password are redirected only after
+// the password is sent in the X-Link-Password header or posted with the form
+// shown to browsers. Redirects of links with a click limit are counted, previews
+// are not, the link is gone once the limit is reached. Links with routing
+// rules lead to the target of the first rule matching the visitor, split links
+// to one of their variants. The UTM parameters of the link and the query of
+// the visit, if the link passes it, are added to the destination.
 func (a *app) getHandler(rw http.ResponseWriter, req *http.Request, id string) {
-	location, err := a.reader.GetURL(req.Context(), id)
-	if err != nil {
-		if err, ok := err.(*store.DeletedURLError); ok {
-			a.myLogger.L.Error("requested URL deleted", zap.Error(err))
-			rw.WriteHeader(http.StatusGone)
-			return
+	id, preview := strings.CutSuffix(id, "+")
+	if value := req.URL.Query().Get("preview"); value != "" {
+		if previewParam, err := strconv.ParseBool(value); err == nil && previewParam {
+			preview = true
 		}
-		http.Error(rw, "location not found", http.StatusBadRequest)
+	}
+	URL, err := a.service.Unlock(req.Context(), id, linkPassword(rw, req))
+	if err != nil {
+		a.writeVisitError(rw, err)
+		return
+	}
+	if URL.PasswordHash != "" {
+		rw.Header().Set("Cache-Control", "no-store")
+	}
+	if len(URL.Rules) > 0 {
+		rw.Header().Add("Vary", "User-Agent, Accept-Language")
+	}
+	if URL.StickyVariants {
+		rw.Header().Add("Vary", "Cookie")
+	}
+	destination := a.service.Destination(URL, a.visit(req, URL))
+	if preview || URL.AlwaysPreview {
+		URL.OriginalURL = destination.Location
+		a.writePreview(rw, URL)
+		return
+	}
+	if err := a.service.Redirect(req.Context(), URL, destination); err != nil {
+		a.writeVisitError(rw, err)
 		return
 	}
-	rw.Header().Set("Location", location)
-	rw.WriteHeader(http.StatusTemporaryRedirect)
-	if _, err := rw.Write([]byte(location)); err != nil {
+	pinVariant(rw, URL, destination.Variant)
+	rw.Header().Set("Location", destination.Location)
+	// The form is posted, 303 makes browsers follow the redirect with GET.
+	status := http.StatusTemporaryRedirect
+	if req.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	rw.WriteHeader(status)
+	if _, err := rw.Write([]byte(destination.Location)); err != nil {
 		log.Println(err)
 		return
 	}
 }
 
-func (a *app) pingDBHandler(rw http.ResponseWriter, req *http.Request) {
-	if reader, ok := a.reader.(store.PingableReader); ok {
-		err := reader.Ping(req.Context())
-		if err != nil {
-			a.myLogger.L.Error("failed to connect to database", zap.Error(err))
-			http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+func (a *app) writeVisitError(rw http.ResponseWriter, err error) {
+	var attemptsErr *service.TooManyAttemptsError
+	switch {
+	case errors.Is(err, service.ErrDeleted):
+		a.myLogger.L.Error("requested URL deleted", zap.Error(err))
+		rw.WriteHeader(http.StatusGone)
+	case errors.Is(err, service.ErrClickLimit):
+		rw.WriteHeader(http.StatusGone)
+	case errors.Is(err, service.ErrPasswordRequired):
+		a.writePasswordForm(rw, http.StatusUnauthorized, "")
+	case errors.Is(err, service.ErrWrongPassword):
+		a.writePasswordForm(rw, http.StatusUnauthorized, "Wrong password, try again.")
+	case errors.As(err, &attemptsErr):
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(attemptsErr.RetryAfter.Seconds()))))
+		a.writePasswordForm(rw, http.StatusTooManyRequests, "Too many attempts, try again later.")
+	default:
+		if !errors.Is(err, service.ErrNotFound) {
+			a.myLogger.L.Error("failed to get URL", zap.Error(err))
 		}
-		rw.WriteHeader(http.StatusOK)
+		http.Error(rw, "location not found", http.StatusBadRequest)
+	}
+}
+
+func (a *app) pingDBHandler(rw http.ResponseWriter, req *http.Request) {
+	err := a.service.Ping(req.Context())
+	if err != nil {
+		a.myLogger.L.Error("failed to connect to database", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
-	a.myLogger.L.Error("failed to connect to database")
-	http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+	rw.WriteHeader(http.StatusOK)
 }
 
 type batchRes struct {
@@ -144,29 +213,29 @@ func (a *app) batchHandler(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
-	var URLs []store.URL
-	for i := range batchURL {
-		batchURL[i].ShortURL = uniuri.NewLen(8)
-		URL := store.URL{
-			OriginalURL: batchURL[i].OriginalURL,
-			ShortURL:    batchURL[i].ShortURL,
-			UserID:      userID,
-		}
-		URLs = append(URLs, URL)
-
+	var originalURLs []string
+	for _, URL := range batchURL {
+		originalURLs = append(originalURLs, URL.OriginalURL)
 	}
-	err := a.writer.SaveBatch(req.Context(), URLs)
+	ids, err := a.service.ShortenBatch(req.Context(), userID, originalURLs)
 	if err != nil {
+		if errors.Is(err, service.ErrEmptyURL) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
 		a.myLogger.L.Error("failed to persist data", zap.Error(err))
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
+	for i := range batchURL {
+		batchURL[i].ShortURL = ids[i]
+	}
 
 	var result []batchRes
 	for _, URL := range batchURL {
 		var resItem batchRes
 		resItem.ID = URL.ID
-		respString, err := url.JoinPath(a.appConfig.FlagShortAddr, URL.ShortURL)
+		respString, err := a.shortURL(URL.ShortURL)
 		if err != nil {
 			a.myLogger.L.Error("failed to process request", zap.Error(err))
 			http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
@@ -203,22 +272,89 @@ func (a *app) JSONHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	genShortStr := uniuri.NewLen(8)
 	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
 	if !ok {
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
-	URL := store.URL{
-		OriginalURL: reqURL.ReqURL,
-		ShortURL:    genShortStr,
-		UserID:      userID,
+	genShortStr, err := a.service.ShortenWithOptions(
+		req.Context(), userID, reqURL.ReqURL, service.ShortenOptions{
+			Password: reqURL.Password, MaxClicks: reqURL.MaxClicks, Rules: storeRules(reqURL.Rules),
+			UTM: reqURL.UTM, QueryPolicy: reqURL.QueryPolicy,
+		},
+	)
+	a.writeShortenResult(rw, genShortStr, err, reqURL.QR)
+}
+
+// reqSplitURL creates an A/B split URL, the first variant is its original URL
+// shown in listings, it must not be shortened yet.
+type reqSplitURL struct {
+	Variants []variant `json:"variants"`
+	// Sticky serves a returning visitor the variant served before.
+	Sticky      bool              `json:"sticky"`
+	QR          bool              `json:"qr"`
+	Password    string            `json:"password"`
+	MaxClicks   int               `json:"max_clicks"`
+	Rules       []rule            `json:"rules"`
+	UTM         map[string]string `json:"utm"`
+	QueryPolicy string            `json:"query_policy"`
+}
+
+func (a *app) splitHandler(rw http.ResponseWriter, req *http.Request) {
+	var reqSplitURL reqSplitURL
+
+	if err := json.NewDecoder(req.Body).Decode(&reqSplitURL); err != nil {
+		if err == io.EOF {
+			http.Error(rw, "request is empty, expected not empty", http.StatusBadRequest)
+			return
+		}
+		a.myLogger.L.Error("failed to decode request", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	var originalURL string
+	if len(reqSplitURL.Variants) > 0 {
+		originalURL = strings.TrimSpace(reqSplitURL.Variants[0].URL)
+	}
+	genShortStr, err := a.service.ShortenWithOptions(
+		req.Context(), userID, originalURL, service.ShortenOptions{
+			Password: reqSplitURL.Password, MaxClicks: reqSplitURL.MaxClicks, Rules: storeRules(reqSplitURL.Rules),
+			Variants: storeVariants(reqSplitURL.Variants), StickyVariants: reqSplitURL.Sticky,
+			UTM: reqSplitURL.UTM, QueryPolicy: reqSplitURL.QueryPolicy,
+		},
+	)
+	if errors.Is(err, service.ErrOptionsConflict) {
+		http.Error(rw, "url of the first variant is already shortened, expected a new one", http.StatusConflict)
+		return
 	}
-	err := a.writer.SaveURL(req.Context(), URL)
+	a.writeShortenResult(rw, genShortStr, err, reqSplitURL.QR)
+}
+
+// writeShortenResult writes the short URL made by ShortenWithOptions or its
+// error, the existing short URL is written on conflict unless options were
+// sent for it.
+func (a *app) writeShortenResult(rw http.ResponseWriter, genShortStr string, err error, withQR bool) {
 	if err != nil {
-		if err, ok := err.(*store.ConflictError); ok {
+		if err, ok := err.(*service.ConflictError); ok {
 			a.myLogger.L.Error("duplicate key value", zap.Error(err))
-			a.makeSingleJSONResponse(rw, err.ShortURL, http.StatusConflict)
+			a.makeShortenResponse(rw, err.ID, http.StatusConflict, withQR)
+			return
+		}
+		if errors.Is(err, service.ErrOptionsConflict) {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, service.ErrEmptyURL) || errors.Is(err, service.ErrInvalidPassword) ||
+			errors.Is(err, service.ErrInvalidMaxClicks) || errors.Is(err, service.ErrInvalidRules) ||
+			errors.Is(err, service.ErrInvalidVariants) || errors.Is(err, service.ErrInvalidUTM) ||
+			errors.Is(err, service.ErrInvalidQueryPolicy) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
 			return
 		}
 		a.myLogger.L.Error("failed to persist data", zap.Error(err))
@@ -226,11 +362,21 @@ func (a *app) JSONHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	a.makeSingleJSONResponse(rw, genShortStr, http.StatusCreated)
+	a.makeShortenResponse(rw, genShortStr, http.StatusCreated, withQR)
 }
 
 func (a *app) makeSingleJSONResponse(rw http.ResponseWriter, genShortStr string, status int) {
-	respString, err := url.JoinPath(a.appConfig.FlagShortAddr, genShortStr)
+	a.makeShortenResponse(rw, genShortStr, status, false)
+}
+
+// makeShortenResponse writes the short URL of the id, withQR adds the link of
+// its QR code.
+func (a *app) makeShortenResponse(rw http.ResponseWriter, genShortStr string, status int, withQR bool) {
+	respString, err := a.shortURL(genShortStr)
+	var qrString string
+	if err == nil && withQR {
+		qrString, err = url.JoinPath(respString, "qr")
+	}
 	if err != nil {
 		a.myLogger.L.Error("failed to process request", zap.Error(err))
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
@@ -239,6 +385,7 @@ func (a *app) makeSingleJSONResponse(rw http.ResponseWriter, genShortStr string,
 	var result result
 
 	result.Result = respString
+	result.QR = qrString
 	resp, err := json.Marshal(result)
 	if err != nil {
 		a.myLogger.L.Error("failed to process request", zap.Error(err))
@@ -255,43 +402,143 @@ func (a *app) makeSingleJSONResponse(rw http.ResponseWriter, genShortStr string,
 }
 
 type usersURL struct {
-	OriginalURL string `json:"original_url"`
-	ShortURL    string `json:"short_url"`
+	OriginalURL   string            `json:"original_url"`
+	ShortURL      string            `json:"short_url"`
+	IsDeleted     bool              `json:"is_deleted,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	Title         string            `json:"title,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Note          string            `json:"note,omitempty"`
+	AlwaysPreview bool              `json:"always_preview,omitempty"`
+	Protected     bool              `json:"protected,omitempty"`
+	MaxClicks     int               `json:"max_clicks,omitempty"`
+	Clicks        int               `json:"clicks,omitempty"`
+	Rules         []rule            `json:"rules,omitempty"`
+	Variants      []variant         `json:"variants,omitempty"`
+	Sticky        bool              `json:"sticky,omitempty"`
+	UTM           map[string]string `json:"utm,omitempty"`
+	QueryPolicy   string            `json:"query_policy,omitempty"`
 }
 
-func (a *app) getUserURLHandler(rw http.ResponseWriter, req *http.Request) {
-	reader, ok := a.reader.(store.UserIDReader)
-	if !ok {
-		a.myLogger.L.Error("reader can not read user ID")
-		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+func (a *app) newUsersURL(URL store.URL) (usersURL, error) {
+	shortURL, err := a.shortURL(URL.ShortURL)
+	if err != nil {
+		return usersURL{}, err
+	}
+
+	return usersURL{
+		OriginalURL:   URL.OriginalURL,
+		ShortURL:      shortURL,
+		IsDeleted:     URL.IsDeleted,
+		CreatedAt:     URL.CreatedAt,
+		UpdatedAt:     URL.UpdatedAt,
+		Title:         URL.Title,
+		Tags:          URL.Tags,
+		Note:          URL.Note,
+		AlwaysPreview: URL.AlwaysPreview,
+		Protected:     URL.PasswordHash != "",
+		MaxClicks:     URL.MaxClicks,
+		Clicks:        URL.Clicks,
+		Rules:         newRules(URL.Rules),
+		Variants:      newVariants(URL.Variants),
+		Sticky:        URL.StickyVariants,
+		UTM:           newUTM(URL.UTM),
+		QueryPolicy:   URL.QueryPolicy,
+	}, nil
+}
+
+// parseListOptions reads the listing query: limit, cursor, order (asc or
+// desc), domain, include_deleted, created_from and created_to in RFC 3339 and
+// q searching in short and original URLs.
+func parseListOptions(query url.Values) (store.ListOptions, error) {
+	var opts store.ListOptions
+	var err error
+	if limit := query.Get("limit"); limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit <= 0 {
+			return opts, errors.New("limit must be a positive integer")
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := store.DecodeCursor(cursor)
+		if err != nil {
+			return opts, err
+		}
+		opts.Cursor = &c
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		opts.Ascending = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+	if includeDeleted := query.Get("include_deleted"); includeDeleted != "" {
+		if opts.IncludeDeleted, err = strconv.ParseBool(includeDeleted); err != nil {
+			return opts, errors.New("include_deleted must be a boolean")
+		}
+	}
+	for name, t := range map[string]*time.Time{"created_from": &opts.CreatedFrom, "created_to": &opts.CreatedTo} {
+		if value := query.Get(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				return opts, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+		}
 	}
+	opts.Domain = query.Get("domain")
+	opts.Search = query.Get("q")
+	opts.Tag = strings.ToLower(strings.TrimSpace(query.Get("tag")))
+
+	return opts, nil
+}
+
+// getUserURLHandler returns a page of the user's URLs, X-Total-Count holds
+// the count of URLs matching the filters and X-Next-Cursor the cursor of the
+// next page unless it is the last one. Without limit and cursor all the URLs
+// are returned as before pagination, deleted ones included unless
+// include_deleted is set.
+func (a *app) getUserURLHandler(rw http.ResponseWriter, req *http.Request) {
 	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
 	if !ok {
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
-	urls, err := reader.GetURLsByUserID(req.Context(), userID.String())
+	query := req.URL.Query()
+	opts, err := parseListOptions(query)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var page store.URLPage
+	if query.Has("limit") || query.Has("cursor") {
+		page, err = a.service.ListPageByUser(req.Context(), userID, opts)
+	} else {
+		if !query.Has("include_deleted") {
+			opts.IncludeDeleted = true
+		}
+		page, err = a.service.ListAllByUser(req.Context(), userID, opts)
+	}
 	if err != nil {
 		a.myLogger.L.Error("failed to get URLs by user ID", zap.Error(err))
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
-	if len(urls) == 0 {
+	rw.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.Next != nil {
+		rw.Header().Set("X-Next-Cursor", page.Next.Encode())
+	}
+	if len(page.URLs) == 0 {
 		rw.WriteHeader(http.StatusNoContent)
 		return
 	}
 	var usersURLs []usersURL
-	for _, URL := range urls {
-		shortURL, err := url.JoinPath(a.appConfig.FlagShortAddr, URL.ShortURL)
+	for _, URL := range page.URLs {
+		userURL, err := a.newUsersURL(URL)
 		if err != nil {
 			a.myLogger.L.Error("failed to process request", zap.Error(err))
 			http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 			return
 		}
-		userURL := usersURL{
-			OriginalURL: URL.OriginalURL,
-			ShortURL:    shortURL,
-		}
 		usersURLs = append(usersURLs, userURL)
 	}
 	resp, err := json.Marshal(usersURLs)
@@ -309,6 +556,191 @@ func (a *app) getUserURLHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+type metadataRequest struct {
+	Title         string   `json:"title"`
+	Tags          []string `json:"tags"`
+	Note          string   `json:"note"`
+	AlwaysPreview *bool    `json:"always_preview"`
+}
+
+// updateMetadataHandler replaces the title, tags, note and the always preview
+// flag of the user's URL, the flag is kept if not sent.
+func (a *app) updateMetadataHandler(rw http.ResponseWriter, req *http.Request) {
+	var meta metadataRequest
+	if err := json.NewDecoder(req.Body).Decode(&meta); err != nil {
+		http.Error(rw, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	URL, err := a.service.UpdateMetadata(
+		req.Context(), userID, chi.URLParam(req, "id"),
+		store.Metadata{Title: meta.Title, Tags: meta.Tags, Note: meta.Note, AlwaysPreview: meta.AlwaysPreview},
+	)
+	a.writeUpdatedURL(rw, URL, err)
+}
+
+// writeUpdatedURL responds with the URL updated by the user or the error of
+// the update.
+func (a *app) writeUpdatedURL(rw http.ResponseWriter, URL store.URL, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidMetadata):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrNotFound):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrDeleted):
+		http.Error(rw, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		a.myLogger.L.Error("failed to update url", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	userURL, err := a.newUsersURL(URL)
+	if err != nil {
+		a.myLogger.L.Error("failed to process request", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(rw, userURL, http.StatusOK)
+}
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func (a *app) addTagsHandler(rw http.ResponseWriter, req *http.Request) {
+	var tags tagsRequest
+	if err := json.NewDecoder(req.Body).Decode(&tags); err != nil {
+		http.Error(rw, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	URL, err := a.service.AddTags(req.Context(), userID, chi.URLParam(req, "id"), tags.Tags)
+	a.writeUpdatedURL(rw, URL, err)
+}
+
+func (a *app) removeTagHandler(rw http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	URL, err := a.service.RemoveTags(
+		req.Context(), userID, chi.URLParam(req, "id"), []string{chi.URLParam(req, "tag")},
+	)
+	a.writeUpdatedURL(rw, URL, err)
+}
+
+type tagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// tagsHandler returns the tags of the user's URLs with counts, most used
+// first.
+func (a *app) tagsHandler(rw http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	counts, err := a.service.TagCounts(req.Context(), userID)
+	if err != nil {
+		a.myLogger.L.Error("failed to count tags", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	resp := make([]tagCount, 0, len(counts))
+	for _, c := range counts {
+		resp = append(resp, tagCount{Tag: c.Tag, Count: c.Count})
+	}
+	a.writeJSON(rw, resp, http.StatusOK)
+}
+
+type destinationRequest struct {
+	OriginalURL string `json:"original_url"`
+}
+
+// updateDestinationHandler changes the original URL of the user's short URL,
+// a conflict responds with the short URL of the new original URL.
+func (a *app) updateDestinationHandler(rw http.ResponseWriter, req *http.Request) {
+	var destination destinationRequest
+	if err := json.NewDecoder(req.Body).Decode(&destination); err != nil {
+		http.Error(rw, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	URL, err := a.service.UpdateDestination(req.Context(), userID, chi.URLParam(req, "id"), destination.OriginalURL)
+	var conflictErr *service.ConflictError
+	switch {
+	case errors.As(err, &conflictErr):
+		a.makeSingleJSONResponse(rw, conflictErr.ID, http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrEmptyURL):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrNotFound):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrDeleted):
+		http.Error(rw, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		a.myLogger.L.Error("failed to update original URL", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	userURL, err := a.newUsersURL(URL)
+	if err != nil {
+		a.myLogger.L.Error("failed to process request", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(rw, userURL, http.StatusOK)
+}
+
+type revision struct {
+	OriginalURL string    `json:"original_url"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+func (a *app) revisionsHandler(rw http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	revisions, err := a.service.ListRevisions(req.Context(), userID, chi.URLParam(req, "id"))
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		a.myLogger.L.Error("failed to get revisions", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	resp := make([]revision, 0, len(revisions))
+	for _, r := range revisions {
+		resp = append(resp, revision{OriginalURL: r.OriginalURL, ReplacedAt: r.ReplacedAt})
+	}
+	a.writeJSON(rw, resp, http.StatusOK)
+}
+
 func (a *app) deleteHandler(rw http.ResponseWriter, req *http.Request) {
 	var result []string
 	if err := json.NewDecoder(req.Body).Decode(&result); err != nil {
@@ -325,25 +757,121 @@ func (a *app) deleteHandler(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
 		return
 	}
-	reader, ok := a.reader.(store.UserIDReader)
+	job, err := a.service.DeleteForUser(req.Context(), userID, result)
+	if errors.Is(err, service.ErrQueueFull) {
+		retryAfter := int(math.Ceil(a.appConfig.FlagDeletionFlush.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		a.myLogger.L.Error("can not filter urls by user ID", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	a.qrCache.forget(result...)
+	a.writeJSON(rw, deletionAccepted{JobID: job.ID}, http.StatusAccepted)
+}
+
+type deletionAccepted struct {
+	JobID uuid.UUID `json:"job_id"`
+}
+
+type deletionJob struct {
+	JobID     uuid.UUID `json:"job_id"`
+	Status    string    `json:"status"`
+	Accepted  []string  `json:"accepted"`
+	NotOwned  []string  `json:"not_owned"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (a *app) getDeletionHandler(rw http.ResponseWriter, req *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(req, "id"))
+	if err != nil {
+		http.Error(rw, "invalid job ID", http.StatusBadRequest)
+		return
+	}
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
 	if !ok {
-		a.myLogger.L.Error("reader can not read user ID")
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
 	}
+	job, err := a.service.GetDeletion(req.Context(), userID, jobID)
+	if err != nil {
+		if errors.Is(err, service.ErrDeletionNotFound) {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		a.myLogger.L.Error("failed to get deletion job", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	a.writeJSON(
+		rw, deletionJob{
+			JobID:     job.ID,
+			Status:    string(job.Status),
+			Accepted:  append([]string{}, job.Accepted...),
+			NotOwned:  append([]string{}, job.NotOwned...),
+			Error:     job.Error,
+			CreatedAt: job.CreatedAt,
+			UpdatedAt: job.UpdatedAt,
+		}, http.StatusOK,
+	)
+}
 
-	var shortUrls []store.URL
-	for _, res := range result {
-		URL := store.URL{
-			ShortURL: res,
+func (a *app) restoreHandler(rw http.ResponseWriter, req *http.Request) {
+	var ids []string
+	if err := json.NewDecoder(req.Body).Decode(&ids); err != nil {
+		if err == io.EOF {
+			http.Error(rw, "request is empty, expected not empty", http.StatusBadRequest)
+			return
 		}
-		shortUrls = append(shortUrls, URL)
+		a.myLogger.L.Error("failed to decode request", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	userID, ok := req.Context().Value(utils.ContextUserID).(uuid.UUID)
+	if !ok {
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	restored, err := a.service.RestoreForUser(req.Context(), userID, ids)
+	if err != nil {
+		a.myLogger.L.Error("failed to restore URLs", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
 	}
-	filteredURLs, err := reader.FilterURLsByUserID(req.Context(), userID.String(), shortUrls)
+	a.qrCache.forget(restored...)
+	a.writeJSON(rw, restored, http.StatusOK)
+}
+
+type stats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+func (a *app) statsHandler(rw http.ResponseWriter, req *http.Request) {
+	s, err := a.service.Stats(req.Context())
 	if err != nil {
-		a.myLogger.L.Error("can not filter urls by user ID")
+		a.myLogger.L.Error("failed to get stats", zap.Error(err))
 		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	resp, err := json.Marshal(stats{URLs: s.URLs, Users: s.Users})
+	if err != nil {
+		a.myLogger.L.Error("failed to process request", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
 	}
-	a.storeChan <- filteredURLs
 	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusAccepted)
+	rw.WriteHeader(http.StatusOK)
+	if _, err := rw.Write(resp); err != nil {
+		a.myLogger.L.Error("failed to retrieve response", zap.Error(err))
+		return
+	}
 }
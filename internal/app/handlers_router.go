@@ -5,12 +5,33 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/lib/pq"
+	"net"
 	"net/http"
 	"time"
 )
 
 func Router(app *app) (chi.Router, error) {
+	var trustedSubnet *net.IPNet
+	if app.appConfig.FlagTrustedSubnet != "" {
+		_, subnet, err := net.ParseCIDR(app.appConfig.FlagTrustedSubnet)
+		if err != nil {
+			return nil, err
+		}
+		trustedSubnet = subnet
+	}
+	trustedProxies, err := utils.ParseCIDRs(app.appConfig.FlagTrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	app.trustedProxies = trustedProxies
+	adminKeys, err := utils.ParseAdminKeys(app.appConfig.FlagAdminKey)
+	if err != nil {
+		return nil, err
+	}
 	r := chi.NewRouter()
+	if app.appConfig.FlagEnableHTTPS && app.appConfig.FlagHSTSMaxAge > 0 {
+		r.Use(utils.HSTSMiddleware(app.appConfig.FlagHSTSMaxAge))
+	}
 	r.Use(utils.GzipMiddleware)
 	r.Use(app.myLogger.RequestLogger)
 	r.Use(utils.AuthMiddleware)
@@ -20,6 +41,7 @@ func Router(app *app) (chi.Router, error) {
 		"/api/shorten", func(r chi.Router) {
 			r.Post("/", app.JSONHandler)
 			r.Post("/batch", app.batchHandler)
+			r.Post("/split", app.splitHandler)
 		},
 	)
 	r.Get(
@@ -28,9 +50,37 @@ func Router(app *app) (chi.Router, error) {
 			app.getHandler(rw, req, id)
 		},
 	)
+	r.Post(
+		"/{id}", func(rw http.ResponseWriter, req *http.Request) {
+			id := chi.URLParam(req, "id")
+			app.getHandler(rw, req, id)
+		},
+	)
+	r.Get("/{id}/qr", app.qrHandler)
 	r.Get("/ping", app.pingDBHandler)
 	r.Get("/api/user/urls", app.getUserURLHandler)
 	r.Delete("/api/user/urls", app.deleteHandler)
+	r.Post("/api/user/urls/restore", app.restoreHandler)
+	r.Patch("/api/user/urls/{id}", app.updateDestinationHandler)
+	r.Put("/api/user/urls/{id}/metadata", app.updateMetadataHandler)
+	r.Get("/api/user/urls/{id}/revisions", app.revisionsHandler)
+	r.Post("/api/user/urls/{id}/tags", app.addTagsHandler)
+	r.Delete("/api/user/urls/{id}/tags/{tag}", app.removeTagHandler)
+	r.Get("/api/user/tags", app.tagsHandler)
+	r.Get("/api/user/deletions/{id}", app.getDeletionHandler)
+	if len(adminKeys) > 0 {
+		r.Route(
+			"/api/admin", func(r chi.Router) {
+				r.Use(utils.AdminMiddleware(adminKeys))
+				r.Get("/urls/{id}", app.adminLookupHandler)
+				r.Delete("/urls/{id}", app.adminDeleteHandler)
+				r.Post("/urls/{id}/restore", app.adminRestoreHandler)
+				r.Post("/users/{userID}/disable", app.adminDisableUserHandler)
+				r.Post("/domains/{domain}/disable", app.adminDisableDomainHandler)
+			},
+		)
+	}
+	r.With(utils.TrustedSubnetMiddleware(trustedSubnet, trustedProxies)).Get("/api/internal/stats", app.statsHandler)
 
 	return r, nil
 }
@@ -1,31 +1,57 @@
 package app
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"github.com/ZhuzhomaAL/go-shortener/cmd/config"
+	"github.com/ZhuzhomaAL/go-shortener/internal/boltdb"
 	"github.com/ZhuzhomaAL/go-shortener/internal/file"
 	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
 	"github.com/ZhuzhomaAL/go-shortener/internal/postgres"
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqldb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqlite"
 	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
 	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"image"
+	"image/png"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 var ts *httptest.Server
 var urlList sync.Map
+var fullURLList sync.Map
 
 func TestMain(m *testing.M) {
-	appConfig := config.ParseFlags()
+	appConfig, err := config.ParseFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The default storage file outlives test runs, start each run from scratch.
+	tmpDir, err := os.MkdirTemp("", "shortener")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("FILE_STORAGE_PATH") == "" {
+		appConfig.FlagStorage = filepath.Join(tmpDir, "short-url-db.json")
+	}
 	var reader store.Reader
 	var writer store.Writer
 
@@ -39,19 +65,49 @@ func TestMain(m *testing.M) {
 		}
 		reader = &store.DBReader{DB: db}
 		writer = &store.DBWriter{DB: db}
+	case appConfig.FlagSQLite != "":
+		db, err := sqlite.GetConnection(appConfig.FlagSQLite)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		err = sqlite.InitializeDB(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reader = &store.DBReader{DB: db, Dialect: sqldb.SQLite{}}
+		writer = &store.DBWriter{DB: db, Dialect: sqldb.SQLite{}}
+	case appConfig.FlagBolt != "":
+		db, err := boltdb.GetConnection(appConfig.FlagBolt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		err = boltdb.InitializeDB(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reader = &store.BoltReader{DB: db}
+		writer = &store.BoltWriter{DB: db}
 	case appConfig.FlagStorage != "":
 		urlList = sync.Map{}
+		fullURLList = sync.Map{}
 		memoryReader := store.MemoryReader{
 			URLList: &urlList,
 		}
 		reader = &store.FileReader{MemoryReader: &memoryReader}
 		memoryWriter := store.MemoryWriter{
-			URLList: &urlList,
+			URLList: &urlList, FullURLList: &fullURLList,
+		}
+		syncMode, err := file.ParseSyncMode(appConfig.FlagFileSync)
+		if err != nil {
+			log.Fatal(err)
 		}
-		fWriter, err := file.NewFileWriter(appConfig.FlagStorage)
+		fWriter, err := file.NewFileWriter(appConfig.FlagStorage, syncMode, appConfig.FlagFileSyncInterval)
 		if err != nil {
 			log.Fatal(err)
 		}
+		defer fWriter.Close()
 		writer = &store.FileWriter{
 			MemoryWriter: &memoryWriter, Writer: fWriter,
 		}
@@ -59,15 +115,10 @@ func TestMain(m *testing.M) {
 		if err != nil {
 			log.Fatal(err)
 		}
-		for {
-			url, err := fReader.ReadFile()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				log.Fatal(fmt.Errorf("failed to read the storage file: %w", err))
-			}
-			urlList.Store(url.ShortURL, url.OriginalURL)
+		defer fReader.Close()
+		err = store.LoadFile(fReader, &memoryWriter)
+		if err != nil {
+			log.Fatal(err)
 		}
 		err = os.MkdirAll("tmp", 0750)
 		if err != nil && !os.IsExist(err) {
@@ -75,11 +126,12 @@ func TestMain(m *testing.M) {
 		}
 	default:
 		urlList = sync.Map{}
+		fullURLList = sync.Map{}
 		reader = &store.MemoryReader{
 			URLList: &urlList,
 		}
 		writer = &store.MemoryWriter{
-			URLList: &urlList,
+			URLList: &urlList, FullURLList: &fullURLList,
 		}
 	}
 	myLogger, err := logger.Initialize(appConfig.FlagLogLevel)
@@ -94,6 +146,7 @@ func TestMain(m *testing.M) {
 	ts = httptest.NewServer(r)
 	defer ts.Close()
 	status := m.Run()
+	os.RemoveAll(tmpDir)
 	os.Exit(status)
 }
 
@@ -206,7 +259,7 @@ func TestGetHandler_PositiveCases(t *testing.T) {
 		tt := tt
 		t.Run(
 			tt.name, func(t *testing.T) {
-				urlList.Store(tt.shortURL, tt.expectedLocation)
+				urlList.Store(tt.shortURL, store.URL{ShortURL: tt.shortURL, OriginalURL: tt.expectedLocation})
 				resp, respBody := testRequest(t, ts, "GET", "/"+tt.shortURL, "")
 				defer resp.Body.Close()
 				assert.Equal(t, tt.expectedStatus, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
@@ -308,3 +361,903 @@ func TestJSONHandler(t *testing.T) {
 		)
 	}
 }
+
+func TestStatsHandler(t *testing.T) {
+	myLogger, err := logger.Initialize("error")
+	require.NoError(t, err)
+	var statsURLList, statsFullURLList sync.Map
+	a := NewApp(
+		config.AppConfig{FlagShortAddr: "http://localhost:8080", FlagTrustedSubnet: "127.0.0.0/8"}, myLogger,
+		&store.MemoryReader{URLList: &statsURLList},
+		&store.MemoryWriter{URLList: &statsURLList, FullURLList: &statsFullURLList},
+	)
+	r, err := Router(a)
+	require.NoError(t, err)
+	trusted := httptest.NewServer(r)
+	defer trusted.Close()
+
+	resp, _ := testRequest(t, trusted, "POST", "/", "https://practicum.yandex.ru")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, respBody := testRequest(t, trusted, "GET", "/api/internal/stats", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+	assert.JSONEq(t, `{"urls": 1, "users": 1}`, respBody)
+
+	resp, _ = testRequest(t, ts, "GET", "/api/internal/stats", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Доступ без доверенной подсети разрешен")
+}
+
+func TestRestoreHandler(t *testing.T) {
+	userID := uuid.New()
+	token, err := utils.GenerateJWT(userID)
+	require.NoError(t, err)
+	urlList.Store("restore1", store.URL{ShortURL: "restore1", OriginalURL: "https://restore.ru", UserID: userID, IsDeleted: true})
+	urlList.Store("restore2", store.URL{ShortURL: "restore2", OriginalURL: "https://foreign.ru", UserID: uuid.New(), IsDeleted: true})
+
+	tests := []struct {
+		name           string
+		token          string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "restore_own_url",
+			token:          token,
+			body:           `["restore1", "restore2"]`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `["restore1"]`,
+		},
+		{
+			name:           "invalid_token",
+			token:          "invalid",
+			body:           `["restore2"]`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				req := resty.New().R()
+				req.Method = http.MethodPost
+				req.URL = ts.URL + "/api/user/urls/restore"
+				req.SetCookie(&http.Cookie{Name: "token", Value: tt.token})
+				req.SetBody(tt.body)
+				resp, err := req.Send()
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				if tt.expectedBody != "" {
+					assert.JSONEq(t, tt.expectedBody, string(resp.Body()))
+				}
+			},
+		)
+	}
+}
+
+func TestDeleteHandler_JobStatus(t *testing.T) {
+	userID := uuid.New()
+	token, err := utils.GenerateJWT(userID)
+	require.NoError(t, err)
+	urlList.Store("deljob01", store.URL{ShortURL: "deljob01", OriginalURL: "https://deljob.ru", UserID: userID})
+
+	client := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token})
+	var accepted struct {
+		JobID string `json:"job_id"`
+	}
+	resp, err := client.R().SetBody(`["deljob01", "foreign1"]`).SetResult(&accepted).Delete(ts.URL + "/api/user/urls")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	require.NotEmpty(t, accepted.JobID, "Не получен идентификатор задачи")
+
+	var job struct {
+		Status   string   `json:"status"`
+		Accepted []string `json:"accepted"`
+		NotOwned []string `json:"not_owned"`
+	}
+	resp, err = client.R().SetResult(&job).Get(ts.URL + "/api/user/deletions/" + accepted.JobID)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, "queued", job.Status)
+	assert.Equal(t, []string{"deljob01"}, job.Accepted)
+	assert.Equal(t, []string{"foreign1"}, job.NotOwned)
+
+	otherToken, err := utils.GenerateJWT(uuid.New())
+	require.NoError(t, err)
+	resp, err = resty.New().SetCookie(&http.Cookie{Name: "token", Value: otherToken}).R().
+		Get(ts.URL + "/api/user/deletions/" + accepted.JobID)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode(), "Доступна чужая задача удаления")
+}
+
+func TestGetUserURLHandler_Pagination(t *testing.T) {
+	userID := uuid.New()
+	token, err := utils.GenerateJWT(userID)
+	require.NoError(t, err)
+	base := time.Now().UTC().Add(-time.Hour)
+	for i, id := range []string{"page0001", "page0002", "page0003", "page0004"} {
+		urlList.Store(
+			id, store.URL{
+				ShortURL: id, OriginalURL: "https://page.ru/" + id, UserID: userID, IsDeleted: i == 3,
+				CreatedAt: base.Add(time.Duration(i) * time.Minute),
+			},
+		)
+	}
+	client := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token})
+
+	var all []usersURL
+	resp, err := client.R().SetResult(&all).Get(ts.URL + "/api/user/urls")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Len(t, all, 4, "Без пагинации должны вернуться все ссылки, включая удалённые")
+	assert.Empty(t, resp.Header().Get("X-Next-Cursor"), "Курсор без пагинации")
+
+	var first []usersURL
+	resp, err = client.R().SetResult(&first).Get(ts.URL + "/api/user/urls?limit=2")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, "3", resp.Header().Get("X-Total-Count"))
+	require.Len(t, first, 2)
+	assert.Equal(t, "https://page.ru/page0003", first[0].OriginalURL, "Сначала должны идти новые ссылки")
+	cursor := resp.Header().Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor, "Не получен курсор следующей страницы")
+
+	var second []usersURL
+	resp, err = client.R().SetResult(&second).Get(ts.URL + "/api/user/urls?limit=2&cursor=" + cursor)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	assert.Equal(t, "https://page.ru/page0001", second[0].OriginalURL)
+	assert.Empty(t, resp.Header().Get("X-Next-Cursor"), "Курсор на последней странице")
+
+	resp, err = client.R().Get(ts.URL + "/api/user/urls?include_deleted=true&q=PAGE0004")
+	require.NoError(t, err)
+	assert.Equal(t, "1", resp.Header().Get("X-Total-Count"))
+	assert.Contains(t, string(resp.Body()), `"is_deleted":true`)
+
+	resp, err = client.R().Get(ts.URL + "/api/user/urls?include_deleted=false")
+	require.NoError(t, err)
+	assert.Equal(t, "3", resp.Header().Get("X-Total-Count"), "Удалённые ссылки не скрыты")
+
+	for _, query := range []string{"limit=0", "cursor=broken", "order=random", "created_from=yesterday"} {
+		resp, err = client.R().Get(ts.URL + "/api/user/urls?" + query)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), query)
+	}
+}
+
+func TestGetUserURLHandler_Unpaginated(t *testing.T) {
+	userID := uuid.New()
+	token, err := utils.GenerateJWT(userID)
+	require.NoError(t, err)
+	count := service.DefaultPageLimit + 5
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("full%04d", i)
+		urlList.Store(id, store.URL{ShortURL: id, OriginalURL: "https://full.ru/" + id, UserID: userID})
+	}
+	client := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token})
+
+	var URLs []usersURL
+	resp, err := client.R().SetResult(&URLs).Get(ts.URL + "/api/user/urls")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Len(t, URLs, count, "Список без пагинации обрезан")
+	assert.Equal(t, strconv.Itoa(count), resp.Header().Get("X-Total-Count"))
+
+	URLs = nil
+	resp, err = client.R().SetResult(&URLs).Get(ts.URL + "/api/user/urls?limit=" + strconv.Itoa(count))
+	require.NoError(t, err)
+	assert.Len(t, URLs, count, "Явный лимит не применён")
+	assert.Empty(t, resp.Header().Get("X-Next-Cursor"), "Курсор на последней странице")
+}
+
+func TestUpdateMetadataHandler(t *testing.T) {
+	userID := uuid.New()
+	token, err := utils.GenerateJWT(userID)
+	require.NoError(t, err)
+	urlList.Store("meta0001", store.URL{ShortURL: "meta0001", OriginalURL: "https://meta.ru", UserID: userID})
+	urlList.Store("meta0002", store.URL{ShortURL: "meta0002", OriginalURL: "https://meta.ru/2", UserID: uuid.New()})
+	urlList.Store(
+		"meta0003", store.URL{ShortURL: "meta0003", OriginalURL: "https://meta.ru/3", UserID: userID, IsDeleted: true},
+	)
+
+	tests := []struct {
+		name            string
+		id              string
+		body            string
+		expectedStatus  int
+		expectedTags    []string
+		expectedPreview bool
+	}{
+		{
+			name:            "update_own_url",
+			id:              "meta0001",
+			body:            `{"title": "Мета", "tags": ["News", "go"], "note": "заметка", "always_preview": true}`,
+			expectedStatus:  http.StatusOK,
+			expectedTags:    []string{"go", "news"},
+			expectedPreview: true,
+		},
+		{
+			name:            "keep_preview",
+			id:              "meta0001",
+			body:            `{"title": "Мета", "tags": ["go"]}`,
+			expectedStatus:  http.StatusOK,
+			expectedTags:    []string{"go"},
+			expectedPreview: true,
+		},
+		{
+			name:           "reset_preview",
+			id:             "meta0001",
+			body:           `{"title": "Мета", "tags": ["go"], "always_preview": false}`,
+			expectedStatus: http.StatusOK,
+			expectedTags:   []string{"go"},
+		},
+		{name: "foreign_url", id: "meta0002", body: `{"title": "Мета"}`, expectedStatus: http.StatusNotFound},
+		{name: "deleted_url", id: "meta0003", body: `{"title": "Мета"}`, expectedStatus: http.StatusGone},
+		{name: "invalid_tag", id: "meta0001", body: `{"tags": [""]}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid_body", id: "meta0001", body: `[`, expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var updated usersURL
+				resp, err := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token}).R().
+					SetBody(tt.body).SetResult(&updated).Put(ts.URL + "/api/user/urls/" + tt.id + "/metadata")
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				if tt.expectedStatus == http.StatusOK {
+					assert.Equal(t, "Мета", updated.Title)
+					assert.Equal(t, tt.expectedTags, updated.Tags)
+					assert.Equal(t, tt.expectedPreview, updated.AlwaysPreview, "Флаг предпросмотра не совпадает")
+					assert.False(t, updated.UpdatedAt.IsZero(), "Не задано время изменения")
+				}
+			},
+		)
+	}
+}
+
+func TestUpdateDestinationHandler(t *testing.T) {
+	userID := uuid.New()
+	token, err := utils.GenerateJWT(userID)
+	require.NoError(t, err)
+	for _, u := range []store.URL{
+		{ShortURL: "dest0001", OriginalURL: "https://dest.ru", UserID: userID},
+		{ShortURL: "dest0002", OriginalURL: "https://dest.ru/2", UserID: uuid.New()},
+		{ShortURL: "dest0003", OriginalURL: "https://dest.ru/3", UserID: userID, IsDeleted: true},
+	} {
+		urlList.Store(u.ShortURL, u)
+		fullURLList.Store(u.OriginalURL, u.ShortURL)
+	}
+
+	tests := []struct {
+		name           string
+		id             string
+		body           string
+		expectedStatus int
+	}{
+		{name: "update_own_url", id: "dest0001", body: `{"original_url": "https://dest.ru/new"}`, expectedStatus: http.StatusOK},
+		{name: "conflict", id: "dest0001", body: `{"original_url": "https://dest.ru/2"}`, expectedStatus: http.StatusConflict},
+		{name: "foreign_url", id: "dest0002", body: `{"original_url": "https://dest.ru/4"}`, expectedStatus: http.StatusNotFound},
+		{name: "deleted_url", id: "dest0003", body: `{"original_url": "https://dest.ru/4"}`, expectedStatus: http.StatusGone},
+		{name: "empty_url", id: "dest0001", body: `{"original_url": ""}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid_body", id: "dest0001", body: `[`, expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var updated usersURL
+				resp, err := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token}).R().
+					SetBody(tt.body).SetResult(&updated).Patch(ts.URL + "/api/user/urls/" + tt.id)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				if tt.expectedStatus == http.StatusOK {
+					assert.Equal(t, "https://dest.ru/new", updated.OriginalURL)
+				}
+			},
+		)
+	}
+
+	var revisions []revision
+	resp, err := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token}).R().
+		SetResult(&revisions).Get(ts.URL + "/api/user/urls/dest0001/revisions")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	require.Len(t, revisions, 1, "История изменений не совпадает с ожидаемой")
+	assert.Equal(t, "https://dest.ru", revisions[0].OriginalURL)
+
+	resp, err = resty.New().SetCookie(&http.Cookie{Name: "token", Value: token}).R().
+		Get(ts.URL + "/api/user/urls/dest0002/revisions")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode(), "Доступна история чужой ссылки")
+}
+
+func TestTagsHandlers(t *testing.T) {
+	userID := uuid.New()
+	token, err := utils.GenerateJWT(userID)
+	require.NoError(t, err)
+	urlList.Store("tags0001", store.URL{ShortURL: "tags0001", OriginalURL: "https://tags.ru", UserID: userID})
+	urlList.Store(
+		"tags0002", store.URL{ShortURL: "tags0002", OriginalURL: "https://tags.ru/2", UserID: userID, Tags: []string{"go"}},
+	)
+	urlList.Store("tags0003", store.URL{ShortURL: "tags0003", OriginalURL: "https://tags.ru/3", UserID: uuid.New()})
+	client := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token})
+
+	tests := []struct {
+		name           string
+		id             string
+		body           string
+		expectedStatus int
+		expectedTags   []string
+	}{
+		{
+			name:           "add_tags",
+			id:             "tags0001",
+			body:           `{"tags": ["Go", "news"]}`,
+			expectedStatus: http.StatusOK,
+			expectedTags:   []string{"go", "news"},
+		},
+		{name: "foreign_url", id: "tags0003", body: `{"tags": ["go"]}`, expectedStatus: http.StatusNotFound},
+		{name: "empty_tags", id: "tags0001", body: `{"tags": []}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid_body", id: "tags0001", body: `[`, expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var updated usersURL
+				resp, err := client.R().SetBody(tt.body).SetResult(&updated).Post(ts.URL + "/api/user/urls/" + tt.id + "/tags")
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				if tt.expectedStatus == http.StatusOK {
+					assert.Equal(t, tt.expectedTags, updated.Tags)
+				}
+			},
+		)
+	}
+
+	var counts []tagCount
+	resp, err := client.R().SetResult(&counts).Get(ts.URL + "/api/user/tags")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, []tagCount{{Tag: "go", Count: 2}, {Tag: "news", Count: 1}}, counts)
+
+	var urls []usersURL
+	resp, err = client.R().SetResult(&urls).Get(ts.URL + "/api/user/urls?tag=news")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	require.Len(t, urls, 1, "Фильтр по тегу не применён")
+	assert.Contains(t, urls[0].ShortURL, "tags0001")
+
+	var updated usersURL
+	resp, err = client.R().SetResult(&updated).Delete(ts.URL + "/api/user/urls/tags0001/tags/news")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, []string{"go"}, updated.Tags)
+	resp, err = client.R().Get(ts.URL + "/api/user/urls?tag=news")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode(), "Ссылка найдена по снятому тегу")
+}
+
+func TestQRHandler(t *testing.T) {
+	urlList.Store("qrcode01", store.URL{ShortURL: "qrcode01", OriginalURL: "https://qr.ru", UserID: uuid.New()})
+	urlList.Store(
+		"qrcode02", store.URL{ShortURL: "qrcode02", OriginalURL: "https://qr.ru/2", UserID: uuid.New(), IsDeleted: true},
+	)
+
+	tests := []struct {
+		name                string
+		path                string
+		expectedStatus      int
+		expectedContentType string
+		expectedSize        int
+	}{
+		{
+			name: "png", path: "/qrcode01/qr", expectedStatus: http.StatusOK, expectedContentType: "image/png",
+			expectedSize: 256,
+		},
+		{
+			name: "png_size", path: "/qrcode01/qr?size=300", expectedStatus: http.StatusOK,
+			expectedContentType: "image/png", expectedSize: 300,
+		},
+		{
+			name:                "svg",
+			path:                "/qrcode01/qr?format=svg&size=512&level=H",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "image/svg+xml",
+		},
+		{name: "invalid_format", path: "/qrcode01/qr?format=gif", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_size", path: "/qrcode01/qr?size=10", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_level", path: "/qrcode01/qr?level=X", expectedStatus: http.StatusBadRequest},
+		{name: "not_found", path: "/qrcode00/qr", expectedStatus: http.StatusNotFound},
+		{name: "deleted", path: "/qrcode02/qr", expectedStatus: http.StatusGone},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				resp, err := resty.New().R().Get(ts.URL + tt.path)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				if tt.expectedStatus != http.StatusOK {
+					return
+				}
+				assert.Equal(t, tt.expectedContentType, resp.Header().Get("Content-Type"))
+				if tt.expectedSize > 0 {
+					img, err := png.Decode(bytes.NewReader(resp.Body()))
+					require.NoError(t, err)
+					assert.Equal(
+						t, image.Rect(0, 0, tt.expectedSize, tt.expectedSize), img.Bounds(),
+						"Размер изображения не совпадает с запрошенным",
+					)
+				}
+				cached, err := resty.New().R().Get(ts.URL + tt.path)
+				require.NoError(t, err)
+				assert.Equal(t, resp.Body(), cached.Body(), "Повторный запрос вернул другое изображение")
+				assert.Equal(t, "no-cache", resp.Header().Get("Cache-Control"), "Изображение кешируется без проверки")
+				etag := resp.Header().Get("ETag")
+				require.NotEmpty(t, etag, "Нет ETag у изображения")
+				revalidated, err := resty.New().R().SetHeader("If-None-Match", etag).Get(ts.URL + tt.path)
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusNotModified, revalidated.StatusCode(), "Неизменное изображение отдано заново")
+			},
+		)
+	}
+
+	var res result
+	resp, err := resty.New().R().SetBody(`{"url": "https://qr.ru/new", "qr": true}`).SetResult(&res).
+		Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, res.Result+"/qr", res.QR, "Нет ссылки на QR-код")
+}
+
+func TestQRCache_Forget(t *testing.T) {
+	c := newQRCache(10)
+	pngOpts := qrOptions{format: "png", size: defaultQRSize, level: defaultQRLevel}
+	svgOpts := qrOptions{format: "svg", size: defaultQRSize, level: defaultQRLevel}
+	c.put(qrKey{id: "qrcode01", opts: pngOpts}, []byte("png"))
+	c.put(qrKey{id: "qrcode01", opts: svgOpts}, []byte("svg"))
+	c.put(qrKey{id: "qrcode02", opts: pngOpts}, []byte("png"))
+
+	c.forget("qrcode01")
+	for key, expected := range map[qrKey]bool{
+		{id: "qrcode01", opts: pngOpts}: false,
+		{id: "qrcode01", opts: svgOpts}: false,
+		{id: "qrcode02", opts: pngOpts}: true,
+	} {
+		_, ok := c.get(key)
+		assert.Equal(t, expected, ok, "Неверное наличие %s %s в кеше", key.id, key.opts.format)
+	}
+	assert.Equal(t, 1, c.order.Len(), "Удалённые записи остались в порядке вытеснения")
+
+	c.reset()
+	_, ok := c.get(qrKey{id: "qrcode02", opts: pngOpts})
+	assert.False(t, ok, "Кеш не очищен")
+}
+
+func TestGetHandler_Preview(t *testing.T) {
+	createdAt := time.Date(2024, time.March, 8, 10, 0, 0, 0, time.UTC)
+	urlList.Store(
+		"prev0001", store.URL{
+			ShortURL: "prev0001", OriginalURL: "https://preview.ru/?a=1&b=2", Title: "<Предпросмотр>",
+			CreatedAt: createdAt,
+		},
+	)
+	urlList.Store(
+		"prev0002", store.URL{ShortURL: "prev0002", OriginalURL: "https://preview.ru/always", AlwaysPreview: true},
+	)
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedStatus   int
+		expectedContains []string
+	}{
+		{name: "redirect", path: "/prev0001", expectedStatus: http.StatusTemporaryRedirect},
+		{
+			name:           "plus_suffix",
+			path:           "/prev0001+",
+			expectedStatus: http.StatusOK,
+			expectedContains: []string{
+				"https://preview.ru/?a=1&amp;b=2", "&lt;Предпросмотр&gt;", "8 March 2024", `datetime="2024-03-08T10:00:00Z"`,
+			},
+		},
+		{
+			name:             "preview_param",
+			path:             "/prev0001?preview=1",
+			expectedStatus:   http.StatusOK,
+			expectedContains: []string{"https://preview.ru/?a=1&amp;b=2"},
+		},
+		{
+			name:             "always_preview",
+			path:             "/prev0002",
+			expectedStatus:   http.StatusOK,
+			expectedContains: []string{"https://preview.ru/always", "Link preview"},
+		},
+		{name: "not_found", path: "/prev0000+", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				resp, err := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(ts.URL + tt.path)
+				if tt.expectedStatus == http.StatusTemporaryRedirect {
+					require.Error(t, err)
+				}
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				if tt.expectedStatus != http.StatusOK {
+					return
+				}
+				assert.Equal(t, "text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+				for _, expected := range tt.expectedContains {
+					assert.Contains(t, resp.String(), expected, "Страница предпросмотра не содержит ожидаемого")
+				}
+			},
+		)
+	}
+}
+
+func TestGetHandler_Password(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("секрет"), bcrypt.MinCost)
+	require.NoError(t, err)
+	for _, id := range []string{"pass0001", "pass0002"} {
+		urlList.Store(
+			id, store.URL{ShortURL: id, OriginalURL: "https://secret.ru/" + id, PasswordHash: string(hash)},
+		)
+	}
+
+	tests := []struct {
+		name             string
+		path             string
+		header           string
+		form             string
+		expectedStatus   int
+		expectedLocation string
+		expectedContains string
+	}{
+		{name: "form", path: "/pass0001", expectedStatus: http.StatusUnauthorized, expectedContains: `name="password"`},
+		{
+			name: "wrong_header", path: "/pass0001", header: "неверный", expectedStatus: http.StatusUnauthorized,
+			expectedContains: "Wrong password",
+		},
+		{
+			name: "header", path: "/pass0001", header: "секрет", expectedStatus: http.StatusTemporaryRedirect,
+			expectedLocation: "https://secret.ru/pass0001",
+		},
+		{
+			name: "form_posted", path: "/pass0001", form: "секрет", expectedStatus: http.StatusSeeOther,
+			expectedLocation: "https://secret.ru/pass0001",
+		},
+		{
+			name: "preview_not_shown", path: "/pass0001+", expectedStatus: http.StatusUnauthorized,
+			expectedContains: `name="password"`,
+		},
+		{
+			name: "preview_posted", path: "/pass0001+", form: "секрет", expectedStatus: http.StatusOK,
+			expectedContains: "https://secret.ru/pass0001",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				req := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R()
+				if tt.header != "" {
+					req.SetHeader("X-Link-Password", tt.header)
+				}
+				method := http.MethodGet
+				if tt.form != "" {
+					method = http.MethodPost
+					req.SetFormData(map[string]string{"password": tt.form})
+				}
+				resp, _ := req.Execute(method, ts.URL+tt.path)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+				assert.Equal(t, tt.expectedLocation, resp.Header().Get("Location"))
+				assert.Contains(t, resp.String(), tt.expectedContains)
+				assert.NotContains(t, resp.Header().Get("Location")+resp.String(), string(hash), "Раскрыт хеш пароля")
+				if tt.expectedStatus == http.StatusUnauthorized {
+					assert.NotContains(t, resp.String(), "https://secret.ru", "Раскрыт адрес без пароля")
+				}
+			},
+		)
+	}
+
+	var resp *resty.Response
+	for i := 0; i <= 5; i++ {
+		resp, err = resty.New().R().SetHeader("X-Link-Password", "неверный").Get(ts.URL + "/pass0002")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode(), "Попытки не ограничены")
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	var res result
+	resp, err = resty.New().R().SetBody(`{"url": "https://secret.ru/new", "password": "секрет"}`).SetResult(&res).
+		Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	resp, err = resty.New().R().Get(ts.URL + res.Result[strings.LastIndex(res.Result, "/"):])
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "Ссылка не защищена паролем")
+	resp, err = resty.New().R().SetBody(`{"url": "https://secret.ru/new", "password": "другой"}`).
+		Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Contains(t, resp.String(), service.ErrOptionsConflict.Error(), "Пароль существующей ссылки проигнорирован")
+
+	body := `{"url": "https://secret.ru/long", "password": "` + strings.Repeat("a", 73) + `"}`
+	resp, err = resty.New().R().SetBody(body).Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Принят слишком длинный пароль")
+}
+
+func TestGetHandler_MaxClicks(t *testing.T) {
+	var res result
+	resp, err := resty.New().R().SetBody(`{"url": "https://clicks.ru/once", "max_clicks": 2}`).SetResult(&res).
+		Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	path := res.Result[strings.LastIndex(res.Result, "/"):]
+
+	for _, expectedStatus := range []int{
+		http.StatusOK, http.StatusTemporaryRedirect, http.StatusOK, http.StatusTemporaryRedirect, http.StatusGone,
+	} {
+		// Previews show the destination without using up clicks.
+		target := ts.URL + path
+		if expectedStatus == http.StatusOK {
+			target += "+"
+		}
+		resp, _ = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(target)
+		assert.Equal(t, expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	}
+	resp, err = resty.New().R().Get(ts.URL + path + "/qr")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGone, resp.StatusCode(), "QR-код отдан для исчерпанной ссылки")
+
+	resp, err = resty.New().R().SetBody(`{"url": "https://clicks.ru/preview", "max_clicks": 1}`).SetResult(&res).
+		Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	path = res.Result[strings.LastIndex(res.Result, "/"):]
+	resp, _ = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(ts.URL + path + "?preview=1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "Превью не показано")
+	resp, _ = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(ts.URL + path)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode(), "Превью израсходовало переход")
+
+	resp, err = resty.New().R().SetBody(`{"url": "https://clicks.ru/negative", "max_clicks": -1}`).
+		Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Принят отрицательный лимит")
+}
+
+type fakeGeoIP map[string]string
+
+func (g fakeGeoIP) Country(ip net.IP) (string, error) {
+	return g[ip.String()], nil
+}
+
+func TestGetHandler_Rules(t *testing.T) {
+	myLogger, err := logger.Initialize("error")
+	require.NoError(t, err)
+	var rulesURLList, rulesFullURLList sync.Map
+	a := NewApp(
+		config.AppConfig{FlagShortAddr: "http://localhost:8080", FlagTrustedProxies: "127.0.0.0/8"}, myLogger,
+		&store.MemoryReader{URLList: &rulesURLList},
+		&store.MemoryWriter{URLList: &rulesURLList, FullURLList: &rulesFullURLList},
+	)
+	a.SetGeoIP(fakeGeoIP{"203.0.113.7": "RU"})
+	r, err := Router(a)
+	require.NoError(t, err)
+	routed := httptest.NewServer(r)
+	defer routed.Close()
+
+	var res result
+	resp, err := resty.New().R().SetBody(
+		`{"url": "https://rules.ru", "rules": [
+			{"devices": ["android"], "countries": ["ru"], "target": "https://rustore.ru/app"},
+			{"devices": ["ios"], "target": "https://apps.apple.com/app"},
+			{"devices": ["android"], "target": "https://play.google.com/app"},
+			{"languages": ["de"], "target": "https://rules.de"}
+		]}`,
+	).SetResult(&res).Post(routed.URL + "/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	path := res.Result[strings.LastIndex(res.Result, "/"):]
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		location string
+	}{
+		{
+			name:     "ios",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"},
+			location: "https://apps.apple.com/app",
+		},
+		{
+			name:     "android",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 14; Pixel 8)"},
+			location: "https://play.google.com/app",
+		},
+		{
+			name: "android_country",
+			headers: map[string]string{
+				"User-Agent": "Mozilla/5.0 (Linux; Android 14; Pixel 8)", "X-Real-IP": "203.0.113.7",
+			},
+			location: "https://rustore.ru/app",
+		},
+		{
+			name:     "language",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)", "Accept-Language": "de-AT, en;q=0.5"},
+			location: "https://rules.de",
+		},
+		{
+			name:     "default",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)", "Accept-Language": "en"},
+			location: "https://rules.ru",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				resp, _ := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().SetHeaders(tt.headers).
+					Get(routed.URL + path)
+				assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				assert.Equal(t, tt.location, resp.Header().Get("Location"), "Переход не по правилу")
+				assert.Contains(t, resp.Header().Get("Vary"), "User-Agent", "Нет заголовка Vary")
+			},
+		)
+	}
+
+	resp, err = resty.New().R().SetBody(`{"url": "https://rules.ru/bad", "rules": [{"devices": ["tv"], "target": "https://tv.ru"}]}`).
+		Post(routed.URL + "/api/shorten")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), "Приняты неверные правила")
+}
+
+func TestSplitHandler(t *testing.T) {
+	token, err := utils.GenerateJWT(uuid.New())
+	require.NoError(t, err)
+	client := resty.New().SetCookie(&http.Cookie{Name: "token", Value: token})
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "single_variant", body: `{"variants": [{"url": "https://split.ru/a", "weight": 1}]}`, expectedStatus: http.StatusBadRequest},
+		{
+			name:           "zero_weight",
+			body:           `{"variants": [{"url": "https://split.ru/a", "weight": 1}, {"url": "https://split.ru/b"}]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{name: "no_variants", body: `{"variants": []}`, expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				resp, err := client.R().SetBody(tt.body).Post(ts.URL + "/api/shorten/split")
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+			},
+		)
+	}
+
+	resp, err := client.R().SetBody(`{"url": "https://split.ru/plain"}`).Post(ts.URL + "/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	resp, err = client.R().SetBody(
+		`{"variants": [{"url": "https://split.ru/plain", "weight": 1}, {"url": "https://split.ru/b", "weight": 1}]}`,
+	).Post(ts.URL + "/api/shorten/split")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	assert.Contains(t, resp.String(), "first variant is already shortened", "Варианты отброшены молча")
+
+	var res result
+	resp, err = client.R().SetBody(
+		`{"variants": [{"url": "https://split.ru/a", "weight": 1}, {"url": "https://split.ru/b", "weight": 1}], "sticky": true}`,
+	).SetResult(&res).Post(ts.URL + "/api/shorten/split")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	path := res.Result[strings.LastIndex(res.Result, "/"):]
+
+	resp, err = resty.New().R().Get(ts.URL + path + "+")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	for _, cookie := range resp.Cookies() {
+		assert.NotEqual(t, "variant_"+path[1:], cookie.Name, "Вариант закреплён при превью")
+	}
+
+	visitor := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	var served string
+	for i := 0; i < 5; i++ {
+		resp, _ = visitor.R().Get(ts.URL + path)
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+		location := resp.Header().Get("Location")
+		assert.Contains(t, []string{"https://split.ru/a", "https://split.ru/b"}, location)
+		if served == "" {
+			served = location
+		}
+		assert.Equal(t, served, location, "Посетителю показан другой вариант")
+	}
+
+	var URLs []usersURL
+	resp, err = client.R().SetResult(&URLs).Get(ts.URL + "/api/user/urls")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+	require.Len(t, URLs, 2)
+	split := URLs[0]
+	if split.OriginalURL != "https://split.ru/a" {
+		split = URLs[1]
+	}
+	assert.True(t, split.Sticky)
+	counts := map[string]int{}
+	for _, v := range split.Variants {
+		counts[v.URL] = v.Served
+	}
+	assert.Equal(t, 5, counts[served], "Показы варианта не посчитаны")
+	assert.Equal(t, 5, counts["https://split.ru/a"]+counts["https://split.ru/b"], "Превью посчитано как показ")
+}
+
+func TestGetHandler_Query(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		query          string
+		expectedStatus int
+		location       string
+	}{
+		{
+			name:           "drop",
+			body:           `{"url": "https://query.ru/drop?q=1"}`,
+			query:          "?utm_source=x",
+			expectedStatus: http.StatusTemporaryRedirect,
+			location:       "https://query.ru/drop?q=1",
+		},
+		{
+			name:           "utm_merge",
+			body:           `{"url": "https://query.ru/merge?q=1", "utm": {"source": "news", "campaign": "весна"}, "query_policy": "merge"}`,
+			query:          "?utm_source=x&ref=tg&preview=0",
+			expectedStatus: http.StatusTemporaryRedirect,
+			location:       "https://query.ru/merge?q=1&ref=tg&utm_campaign=%D0%B2%D0%B5%D1%81%D0%BD%D0%B0&utm_source=news",
+		},
+		{
+			name:           "override",
+			body:           `{"url": "https://query.ru/override?q=1", "query_policy": "override"}`,
+			query:          "?q=2",
+			expectedStatus: http.StatusTemporaryRedirect,
+			location:       "https://query.ru/override?q=2",
+		},
+		{
+			name:           "unknown_policy",
+			body:           `{"url": "https://query.ru/unknown", "query_policy": "append"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown_utm",
+			body:           `{"url": "https://query.ru/utm", "utm": {"id": "1"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var res result
+				resp, err := resty.New().R().SetBody(tt.body).SetResult(&res).Post(ts.URL + "/api/shorten")
+				require.NoError(t, err)
+				if tt.expectedStatus == http.StatusBadRequest {
+					assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+					return
+				}
+				require.Equal(t, http.StatusCreated, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				path := res.Result[strings.LastIndex(res.Result, "/"):]
+				resp, _ = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(ts.URL + path + tt.query)
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode(), "Код ответа не совпадает с ожидаемым")
+				assert.Equal(t, tt.location, resp.Header().Get("Location"), "Адрес перехода не совпадает")
+			},
+		)
+	}
+}
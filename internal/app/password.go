@@ -0,0 +1,73 @@
+package app
+
+import (
+	"bytes"
+	"go.uber.org/zap"
+	"html/template"
+	"net/http"
+)
+
+// passwordHeader carries the password of a protected link for API callers.
+const passwordHeader = "X-Link-Password"
+
+// maxPasswordFormSize bounds the body of the submitted password form.
+const maxPasswordFormSize = 4096
+
+var passwordTemplate = template.Must(
+	template.New("password").Parse(
+		`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>Password required</title>
+</head>
+<body>
+<main>
+<h1>Password required</h1>
+<p>This short link is protected by a password.</p>
+{{- if .}}
+<p role="alert">{{.}}</p>
+{{- end}}
+<form method="post">
+<label for="password">Password</label>
+<input id="password" name="password" type="password" required autofocus autocomplete="off">
+<button type="submit">Continue</button>
+</form>
+</main>
+</body>
+</html>
+`,
+	),
+)
+
+// linkPassword returns the password sent in the header or the submitted form.
+func linkPassword(rw http.ResponseWriter, req *http.Request) string {
+	if password := req.Header.Get(passwordHeader); password != "" {
+		return password
+	}
+	if req.Method != http.MethodPost {
+		return ""
+	}
+	req.Body = http.MaxBytesReader(rw, req.Body, maxPasswordFormSize)
+	return req.PostFormValue("password")
+}
+
+// writePasswordForm renders the form asking for the password of the link, the
+// form is posted to the requested URL keeping the preview options.
+func (a *app) writePasswordForm(rw http.ResponseWriter, status int, message string) {
+	var buf bytes.Buffer
+	if err := passwordTemplate.Execute(&buf, message); err != nil {
+		a.myLogger.L.Error("failed to render password form", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+	rw.WriteHeader(status)
+	if _, err := rw.Write(buf.Bytes()); err != nil {
+		a.myLogger.L.Error("failed to retrieve response", zap.Error(err))
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"bytes"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"go.uber.org/zap"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+var previewTemplate = template.Must(
+	template.New("preview").Parse(
+		`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</title>
+</head>
+<body>
+<main>
+<h1>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</h1>
+<p>This short link leads to:</p>
+<p><code>{{.OriginalURL}}</code></p>
+{{- if not .CreatedAt.IsZero}}
+<p>Created <time datetime="{{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.CreatedAt.Format "2 January 2006"}}</time></p>
+{{- end}}
+<p><a href="{{.OriginalURL}}" rel="noopener noreferrer nofollow">Continue to the destination</a></p>
+</main>
+</body>
+</html>
+`,
+	),
+)
+
+type preview struct {
+	OriginalURL string
+	Title       string
+	CreatedAt   time.Time
+}
+
+// writePreview renders the page showing where the short URL leads instead of
+// redirecting. The template escapes the owner provided values and replaces
+// unsafe destinations in the link.
+func (a *app) writePreview(rw http.ResponseWriter, URL store.URL) {
+	var buf bytes.Buffer
+	err := previewTemplate.Execute(
+		&buf, preview{OriginalURL: URL.OriginalURL, Title: URL.Title, CreatedAt: URL.CreatedAt.UTC()},
+	)
+	if err != nil {
+		a.myLogger.L.Error("failed to render preview", zap.Error(err))
+		http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+	rw.WriteHeader(http.StatusOK)
+	if _, err := rw.Write(buf.Bytes()); err != nil {
+		a.myLogger.L.Error("failed to retrieve response", zap.Error(err))
+	}
+}
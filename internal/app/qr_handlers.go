@@ -0,0 +1,225 @@
+package app
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/qr"
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultQRSize  = 256
+	minQRSize      = 64
+	maxQRSize      = 2048
+	defaultQRLevel = qr.M
+	qrCacheSize    = 1024
+)
+
+type qrOptions struct {
+	format string
+	size   int
+	level  qr.Level
+}
+
+func parseQROptions(query url.Values) (qrOptions, error) {
+	opts := qrOptions{format: "png", size: defaultQRSize, level: defaultQRLevel}
+	switch format := query.Get("format"); format {
+	case "":
+	case "png", "svg":
+		opts.format = format
+	default:
+		return opts, errors.New("format must be png or svg")
+	}
+	if size := query.Get("size"); size != "" {
+		var err error
+		if opts.size, err = strconv.Atoi(size); err != nil || opts.size < minQRSize || opts.size > maxQRSize {
+			return opts, fmt.Errorf("size must be an integer from %d to %d", minQRSize, maxQRSize)
+		}
+	}
+	if level := query.Get("level"); level != "" {
+		var err error
+		if opts.level, err = qr.ParseLevel(level); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+type qrKey struct {
+	id   string
+	opts qrOptions
+}
+
+type qrEntry struct {
+	key   qrKey
+	image []byte
+}
+
+// qrCache keeps the most recently requested QR codes. A short URL always
+// encodes the same address, entries are evicted by newer ones and dropped when
+// the URL is deleted or restored.
+type qrCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[qrKey]*list.Element
+	order    *list.List
+}
+
+func newQRCache(capacity int) *qrCache {
+	return &qrCache{capacity: capacity, entries: make(map[qrKey]*list.Element), order: list.New()}
+}
+
+func (c *qrCache) get(key qrKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*qrEntry).image, true
+}
+
+func (c *qrCache) put(key qrKey, image []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&qrEntry{key: key, image: image})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*qrEntry).key)
+	}
+}
+
+// forget drops the entries of the ids.
+func (c *qrCache) forget(ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+	drop := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		drop[id] = struct{}{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if _, ok := drop[key.id]; ok {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// reset drops all entries.
+func (c *qrCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[qrKey]*list.Element)
+	c.order.Init()
+}
+
+// qrETag is the strong entity tag of the image.
+func qrETag(image []byte) string {
+	sum := sha256.Sum256(image)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchETag reports whether the If-None-Match header lists etag.
+func matchETag(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// renderQR encodes the short URL of the id.
+func (a *app) renderQR(id string, opts qrOptions) ([]byte, error) {
+	shortURL, err := a.shortURL(id)
+	if err != nil {
+		return nil, err
+	}
+	code, err := qr.Encode(shortURL, opts.level)
+	if err != nil {
+		return nil, err
+	}
+	if opts.format == "svg" {
+		return code.SVG(opts.size), nil
+	}
+	return code.PNG(opts.size)
+}
+
+// qrHandler renders the QR code of the short URL as PNG or SVG, size is the
+// image width in pixels and level the error correction level. Clients have to
+// revalidate the image so a deleted URL stops serving it, the unchanged image
+// is answered with 304 by its ETag.
+func (a *app) qrHandler(rw http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+	opts, err := parseQROptions(req.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := a.service.Lookup(req.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotFound):
+			http.Error(rw, err.Error(), http.StatusNotFound)
+		case errors.Is(err, service.ErrDeleted), errors.Is(err, service.ErrClickLimit):
+			http.Error(rw, err.Error(), http.StatusGone)
+		default:
+			a.myLogger.L.Error("failed to get URL", zap.Error(err))
+			http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+		}
+		return
+	}
+	key := qrKey{id: id, opts: opts}
+	image, ok := a.qrCache.get(key)
+	if !ok {
+		image, err = a.renderQR(id, opts)
+		if err != nil {
+			a.myLogger.L.Error("failed to render QR code", zap.Error(err))
+			http.Error(rw, "internal server error occurred", http.StatusInternalServerError)
+			return
+		}
+		a.qrCache.put(key, image)
+	}
+
+	contentType := "image/png"
+	if opts.format == "svg" {
+		contentType = "image/svg+xml"
+	}
+	etag := qrETag(image)
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("ETag", etag)
+	if matchETag(req.Header.Get("If-None-Match"), etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.Header().Set("Content-Type", contentType)
+	rw.WriteHeader(http.StatusOK)
+	if _, err := rw.Write(image); err != nil {
+		a.myLogger.L.Error("failed to retrieve response", zap.Error(err))
+	}
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"github.com/ZhuzhomaAL/go-shortener/internal/service"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// rule routes the visits matching all its conditions to Target, a condition
+// without values matches any visit.
+type rule struct {
+	Devices   []string `json:"devices,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	Target    string   `json:"target"`
+}
+
+func storeRules(rules []rule) []store.Rule {
+	var storeRules []store.Rule
+	for _, r := range rules {
+		storeRules = append(
+			storeRules, store.Rule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target},
+		)
+	}
+	return storeRules
+}
+
+func newRules(storeRules []store.Rule) []rule {
+	var rules []rule
+	for _, r := range storeRules {
+		rules = append(rules, rule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target})
+	}
+	return rules
+}
+
+// variant is a weighted target of a split URL, Served counts the visits it got.
+type variant struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Served int    `json:"served,omitempty"`
+}
+
+func storeVariants(variants []variant) []store.Variant {
+	var storeVariants []store.Variant
+	for _, v := range variants {
+		storeVariants = append(storeVariants, store.Variant{Target: v.URL, Weight: v.Weight})
+	}
+	return storeVariants
+}
+
+func newVariants(storeVariants []store.Variant) []variant {
+	var variants []variant
+	for _, v := range storeVariants {
+		variants = append(variants, variant{URL: v.Target, Weight: v.Weight, Served: v.Served})
+	}
+	return variants
+}
+
+func newUTM(encoded string) map[string]string {
+	query, err := url.ParseQuery(encoded)
+	if err != nil || len(query) == 0 {
+		return nil
+	}
+	utm := make(map[string]string, len(query))
+	for key := range query {
+		utm[key] = query.Get(key)
+	}
+	return utm
+}
+
+// visit describes the visit of the URL for the service, the preview
+// parameter controls the visit and is not passed to the destination.
+func (a *app) visit(req *http.Request, URL store.URL) service.Visit {
+	query := req.URL.Query()
+	query.Del("preview")
+	visit := service.NewVisit(
+		req.UserAgent(), req.Header.Get("Accept-Language"), utils.ClientIP(req, a.trustedProxies), query,
+	)
+	if cookie, err := req.Cookie(variantCookieName(URL)); err == nil {
+		if index, err := strconv.Atoi(cookie.Value); err == nil {
+			visit.Variant = &index
+		}
+	}
+
+	return visit
+}
+
+// variantCookieMaxAge is how long a visitor of a sticky split URL keeps its
+// variant.
+const variantCookieMaxAge = 30 * 24 * time.Hour
+
+func variantCookieName(URL store.URL) string {
+	return "variant_" + URL.ShortURL
+}
+
+// pinVariant remembers the variant of the sticky split URL the visitor is
+// redirected to.
+func pinVariant(rw http.ResponseWriter, URL store.URL, index int) {
+	if !URL.StickyVariants || index < 0 {
+		return
+	}
+	http.SetCookie(
+		rw, &http.Cookie{
+			Name: variantCookieName(URL), Value: strconv.Itoa(index), Path: "/",
+			MaxAge: int(variantCookieMaxAge.Seconds()), HttpOnly: true, SameSite: http.SameSiteLaxMode,
+		},
+	)
+}
@@ -0,0 +1,35 @@
+package boltdb
+
+import (
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	URLBucket     = []byte("short_url")
+	FullURLBucket = []byte("full_url")
+	UserBucket    = []byte("user_url")
+)
+
+func GetConnection(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt storage %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+func InitializeDB(db *bbolt.DB) error {
+	return db.Update(
+		func(tx *bbolt.Tx) error {
+			for _, bucket := range [][]byte{URLBucket, FullURLBucket, UserBucket} {
+				if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+					return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+				}
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,109 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate key pair which can be replaced on disk while
+// the server is running. Only new TLS handshakes use the reloaded pair, so
+// established connections are not affected.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return last, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+
+	return last, nil
+}
+
+func (r *Reloader) changed() bool {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return !modTime.Equal(r.modTime)
+}
+
+// Watch reloads the pair when the files change, checking them every interval,
+// or when a value is sent to reload, e.g. on SIGHUP. A failed reload keeps the
+// previous pair.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, reload <-chan os.Signal, myLogger logger.MyLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+		case <-reload:
+		}
+		if err := r.Reload(); err != nil {
+			myLogger.L.Error("failed to reload certificate", zap.Error(err))
+			continue
+		}
+		myLogger.L.Info("certificate reloaded", zap.String("cert", r.certFile))
+	}
+}
@@ -0,0 +1,84 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func writeKeyPair(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	start := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, "first", start)
+
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, r))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	myLogger, err := logger.Initialize("error")
+	require.NoError(t, err)
+	reload := make(chan os.Signal, 1)
+	go r.Watch(ctx, 10*time.Millisecond, reload, myLogger)
+
+	writeKeyPair(t, certFile, keyFile, "second", start.Add(time.Minute))
+	assert.Eventually(t, func() bool { return commonName(t, r) == "second" }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	reload <- syscall.SIGHUP
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "second", commonName(t, r), "Сломанная пара заменила рабочую")
+
+	writeKeyPair(t, certFile, keyFile, "third", start.Add(2*time.Minute))
+	reload <- syscall.SIGHUP
+	assert.Eventually(t, func() bool { return commonName(t, r) == "third" }, time.Second, 10*time.Millisecond)
+}
+
+func TestNewReloader_MissingFiles(t *testing.T) {
+	_, err := NewReloader(filepath.Join(t.TempDir(), "server.crt"), filepath.Join(t.TempDir(), "server.key"))
+	assert.Error(t, err)
+}
@@ -2,40 +2,189 @@ package file
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"os"
+	"sync"
+	"time"
 )
 
 type URL struct {
-	ID          uuid.UUID `json:"id"`
-	ShortURL    string    `json:"short_url"`
+	ID            uuid.UUID  `json:"id"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	UserID        uuid.UUID  `json:"user_id"`
+	IsDeleted     bool       `json:"is_deleted,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+	Title         string     `json:"title,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	Note          string     `json:"note,omitempty"`
+	Revisions     []Revision `json:"revisions,omitempty"`
+	AlwaysPreview bool       `json:"always_preview,omitempty"`
+	PasswordHash  string     `json:"password_hash,omitempty"`
+	MaxClicks     int        `json:"max_clicks,omitempty"`
+	Clicks        int        `json:"clicks,omitempty"`
+	Rules         []Rule     `json:"rules,omitempty"`
+	Variants      []Variant  `json:"variants,omitempty"`
+	Sticky        bool       `json:"sticky_variants,omitempty"`
+	UTM           string     `json:"utm,omitempty"`
+	QueryPolicy   string     `json:"query_policy,omitempty"`
+	IsPurged      bool       `json:"is_purged,omitempty"`
+}
+
+type Rule struct {
+	Devices   []string `json:"devices,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	Target    string   `json:"target"`
+}
+
+type Variant struct {
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+	Served int    `json:"served,omitempty"`
+}
+
+type Revision struct {
 	OriginalURL string    `json:"original_url"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+type SyncMode string
+
+const (
+	SyncAlways   SyncMode = "always"
+	SyncPeriodic SyncMode = "periodic"
+	SyncNone     SyncMode = "none"
+)
+
+var ErrLocked = errors.New("storage file is locked by another process")
+
+func ParseSyncMode(mode string) (SyncMode, error) {
+	switch SyncMode(mode) {
+	case SyncAlways, SyncPeriodic, SyncNone:
+		return SyncMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown sync mode %q, expected one of: always, periodic, none", mode)
 }
 
 type Writer struct {
-	file    *os.File
-	encoder *json.Encoder
+	mu       sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	syncMode SyncMode
+	dirty    bool
+	syncErr  error
+	done     chan struct{}
+	wg       sync.WaitGroup
+	closed   bool
 }
 
-func NewFileWriter(fileName string) (*Writer, error) {
+func NewFileWriter(fileName string, syncMode SyncMode, syncInterval time.Duration) (*Writer, error) {
+	if _, err := ParseSyncMode(string(syncMode)); err != nil {
+		return nil, err
+	}
+	if syncMode == SyncPeriodic && syncInterval <= 0 {
+		return nil, fmt.Errorf("sync interval must be positive, got %v", syncInterval)
+	}
 	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, err
 	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
 
-	return &Writer{
-		file:    file,
-		encoder: json.NewEncoder(file),
-	}, nil
+	w := &Writer{
+		file:     file,
+		encoder:  json.NewEncoder(file),
+		syncMode: syncMode,
+		done:     make(chan struct{}),
+	}
+	if syncMode == SyncPeriodic {
+		w.wg.Add(1)
+		go w.syncLoop(syncInterval)
+	}
+
+	return w, nil
 }
 
 func (w *Writer) WriteFile(URL *URL) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.syncErr != nil {
+		return fmt.Errorf("previous sync failed: %w", w.syncErr)
+	}
+	if err := w.encoder.Encode(&URL); err != nil {
+		return err
+	}
+	switch w.syncMode {
+	case SyncAlways:
+		return w.file.Sync()
+	case SyncPeriodic:
+		w.dirty = true
+	}
 
-	return w.encoder.Encode(&URL)
+	return nil
+}
+
+// syncLoop flushes all records written since the previous tick with a single
+// fsync, so concurrent writers share the cost of one disk flush.
+func (w *Writer) syncLoop(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			w.sync()
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// sync retries a failed fsync until it succeeds, writes are rejected
+// meanwhile.
+func (w *Writer) sync() {
+	if !w.dirty {
+		return
+	}
+	if w.syncErr = w.file.Sync(); w.syncErr == nil {
+		w.dirty = false
+	}
 }
 
 func (w *Writer) Close() error {
-	return w.file.Close()
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	w.mu.Unlock()
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sync()
+	err := unlockFile(w.file)
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = w.syncErr
+	}
+	return err
 }
 
 type Reader struct {
@@ -0,0 +1,115 @@
+package file
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriter_ConcurrentWrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		syncMode SyncMode
+	}{
+		{name: "always", syncMode: SyncAlways},
+		{name: "periodic", syncMode: SyncPeriodic},
+		{name: "none", syncMode: SyncNone},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				fileName := filepath.Join(t.TempDir(), "storage.json")
+				w, err := NewFileWriter(fileName, tt.syncMode, 10*time.Millisecond)
+				require.NoError(t, err)
+
+				var wg sync.WaitGroup
+				for i := 0; i < 50; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						err := w.WriteFile(&URL{ID: uuid.New(), ShortURL: "short", OriginalURL: "https://ya.ru"})
+						assert.NoError(t, err)
+					}()
+				}
+				wg.Wait()
+				require.NoError(t, w.Close())
+
+				r, err := NewFileReader(fileName)
+				require.NoError(t, err)
+				defer r.Close()
+				var count int
+				for {
+					_, err := r.ReadFile()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err, "Строки файла перемешались")
+					count++
+				}
+				assert.Equal(t, 50, count)
+			},
+		)
+	}
+}
+
+func TestNewFileWriter_Locked(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.json")
+	w, err := NewFileWriter(fileName, SyncNone, 0)
+	require.NoError(t, err)
+
+	_, err = NewFileWriter(fileName, SyncNone, 0)
+	require.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, w.Close())
+	w, err = NewFileWriter(fileName, SyncNone, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func TestWriter_SyncErrorReset(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.json")
+	w, err := NewFileWriter(fileName, SyncPeriodic, time.Hour)
+	require.NoError(t, err)
+	defer w.Close()
+
+	w.mu.Lock()
+	w.dirty, w.syncErr = true, errors.New("input/output error")
+	w.mu.Unlock()
+	assert.Error(t, w.WriteFile(&URL{ID: uuid.New(), ShortURL: "short", OriginalURL: "https://ya.ru"}))
+
+	w.mu.Lock()
+	w.sync()
+	w.mu.Unlock()
+	assert.NoError(
+		t, w.WriteFile(&URL{ID: uuid.New(), ShortURL: "short", OriginalURL: "https://ya.ru"}),
+		"Ошибка синхронизации не сброшена после успешной синхронизации",
+	)
+}
+
+func TestWriter_ClosePeriodic(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.json")
+	w, err := NewFileWriter(fileName, SyncPeriodic, time.Hour)
+	require.NoError(t, err)
+	URL := &URL{ID: uuid.New(), ShortURL: "short", OriginalURL: "https://ya.ru"}
+	require.NoError(t, w.WriteFile(URL))
+	require.True(t, w.dirty)
+
+	require.NoError(t, w.Close())
+	assert.False(t, w.dirty, "Запись не синхронизирована при закрытии")
+	r, err := NewFileReader(fileName)
+	require.NoError(t, err)
+	defer r.Close()
+	read, err := r.ReadFile()
+	require.NoError(t, err)
+	assert.Equal(t, URL.ShortURL, read.ShortURL)
+	w, err = NewFileWriter(fileName, SyncPeriodic, time.Hour)
+	require.NoError(t, err, "Блокировка не снята при закрытии")
+	require.NoError(t, w.Close())
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package file
+
+import "os"
+
+// Advisory locking is only implemented on unix platforms.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
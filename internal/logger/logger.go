@@ -1,10 +1,13 @@
 package logger
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 type MyLogger struct {
@@ -52,6 +55,20 @@ func (l *MyLogger) RequestLogger(h http.Handler) http.Handler {
 	)
 }
 
+func (l *MyLogger) UnaryLogger(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	l.L.Info(
+		"got incoming gRPC request",
+		zap.String("method", info.FullMethod),
+		zap.Duration("latency", time.Since(start)),
+		zap.String("code", status.Code(err).String()),
+	)
+	return resp, err
+}
+
 type (
 	responseData struct {
 		status int
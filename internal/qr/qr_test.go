@@ -0,0 +1,169 @@
+package qr
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// Data codewords of "HELLO WORLD" at 1-M and their error correction.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	ecc := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	assert.Equal(t, ecc, rsRemainder(data, rsDivisor(len(ecc))))
+}
+
+func TestFormatAndVersionInfo(t *testing.T) {
+	tests := []struct {
+		level    Level
+		mask     int
+		expected int
+	}{
+		{level: L, mask: 0, expected: 0b111011111000100},
+		{level: M, mask: 0, expected: 0b101010000010010},
+		{level: Q, mask: 0, expected: 0b011010101011111},
+		{level: H, mask: 0, expected: 0b001011010001001},
+		{level: L, mask: 4, expected: 0b110011000101111},
+		{level: H, mask: 7, expected: 0b000100000111011},
+	}
+	for _, tt := range tests {
+		assert.Equal(
+			t, tt.expected, formatInfo(tt.level, tt.mask), "Неверная информация о формате %v%d", tt.level, tt.mask,
+		)
+	}
+	assert.Equal(t, 0b000111110010010100, versionInfo(7))
+	assert.Equal(t, 0b101000110001101001, versionInfo(40))
+}
+
+func TestAlignmentPositions(t *testing.T) {
+	assert.Empty(t, alignmentPositions(1))
+	assert.Equal(t, []int{6, 18}, alignmentPositions(2))
+	assert.Equal(t, []int{6, 22, 38}, alignmentPositions(7))
+	assert.Equal(t, []int{6, 34, 60, 86, 112, 138}, alignmentPositions(32))
+	assert.Equal(t, []int{6, 30, 58, 86, 114, 142, 170}, alignmentPositions(40))
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name            string
+		length          int
+		level           Level
+		expectedVersion int
+		expectedErr     error
+	}{
+		{name: "1-L", length: 17, level: L, expectedVersion: 1},
+		{name: "2-L", length: 18, level: L, expectedVersion: 2},
+		{name: "1-M", length: 14, level: M, expectedVersion: 1},
+		{name: "1-H", length: 7, level: H, expectedVersion: 1},
+		{name: "10-M", length: 213, level: M, expectedVersion: 10},
+		{name: "11-M", length: 214, level: M, expectedVersion: 11},
+		{name: "40-L", length: 2953, level: L, expectedVersion: 40},
+		{name: "too_long", length: 2954, level: L, expectedErr: ErrTooLong},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c, err := Encode(strings.Repeat("a", tt.length), tt.level)
+				if tt.expectedErr != nil {
+					assert.ErrorIs(t, err, tt.expectedErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedVersion, c.Version, "Версия кода не совпадает с ожидаемой")
+				assert.Equal(t, tt.expectedVersion*4+17, c.Size)
+				assert.True(t, c.Black(8, c.Size-8), "Нет тёмного модуля")
+				for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+					assert.True(t, c.Black(corner[0], corner[1]) && c.Black(corner[0]+3, corner[1]+3))
+					assert.False(t, c.Black(corner[0]+1, corner[1]+1), "Нет поискового узора")
+				}
+			},
+		)
+	}
+}
+
+// TestEncode_ReadBack unmasks the code with the mask from its format bits
+// and reads the codewords back in the placement order.
+func TestEncode_ReadBack(t *testing.T) {
+	for _, level := range []Level{L, M, Q, H} {
+		text := "http://localhost:8080/" + strings.Repeat("x", 60)
+		c, err := Encode(text, level)
+		require.NoError(t, err)
+
+		var format int
+		for i := 0; i < 8; i++ {
+			if c.Black(c.Size-1-i, 8) {
+				format |= 1 << i
+			}
+		}
+		for i := 8; i < 15; i++ {
+			if c.Black(8, c.Size-15+i) {
+				format |= 1 << i
+			}
+		}
+		mask := -1
+		for m := 0; m < 8; m++ {
+			if formatInfo(level, m) == format {
+				mask = m
+			}
+		}
+		require.NotEqual(t, -1, mask, "Информация о формате не найдена")
+
+		c.applyMask(mask)
+		var bits bitBuffer
+		for right := c.Size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := 0; vert < c.Size; vert++ {
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				for x := right; x > right-2; x-- {
+					if !c.function[y][x] {
+						bits = append(bits, c.modules[y][x])
+					}
+				}
+			}
+		}
+		expected := addECCAndInterleave(encodeData([]byte(text), c.Version, level), c.Version, level)
+		read := make([]byte, len(expected))
+		for i := range read {
+			for _, bit := range bits[i*8 : i*8+8] {
+				read[i] <<= 1
+				if bit {
+					read[i] |= 1
+				}
+			}
+		}
+		assert.Equal(t, expected, read, "Прочитанные кодовые слова не совпадают для уровня %v", level)
+	}
+}
+
+func TestCode_Render(t *testing.T) {
+	c, err := Encode("http://localhost:8080/EwHXdJfB", M)
+	require.NoError(t, err)
+
+	for _, size := range []int{64, 100, 256} {
+		data, err := c.PNG(size)
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, size, size), img.Bounds(), "Размер изображения не совпадает с запрошенным")
+		scale := size / (c.Size + 2*QuietZone)
+		offset := (size-(c.Size+2*QuietZone)*scale)/2 + QuietZone*scale
+		r, _, _, _ := img.At(offset, offset).RGBA()
+		assert.Zero(t, r, "Поисковый узор смещен")
+		r, _, _, _ = img.At(offset-1, offset-1).RGBA()
+		assert.NotZero(t, r, "Нет светлой границы")
+	}
+
+	svg := string(c.SVG(256))
+	assert.True(t, strings.HasPrefix(svg, "<svg"))
+	assert.Contains(t, svg, `width="256"`)
+}
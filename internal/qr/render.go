@@ -0,0 +1,76 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// QuietZone is the light border around the code in modules.
+const QuietZone = 4
+
+// scale returns the module width in pixels that fits the code with the quiet
+// zone into size pixels, at least one pixel.
+func (c *Code) scale(size int) int {
+	scale := size / (c.Size + 2*QuietZone)
+	if scale < 1 {
+		return 1
+	}
+	return scale
+}
+
+// PNG renders the code into a square image of size pixels, modules are whole
+// pixels to stay sharp and the rest of the image widens the quiet zone. Codes
+// wider than size get one pixel modules and a larger image.
+func (c *Code) PNG(size int) ([]byte, error) {
+	scale := c.scale(size)
+	codeWidth := (c.Size + 2*QuietZone) * scale
+	width := size
+	if codeWidth > width {
+		width = codeWidth
+	}
+	offset := (width-codeWidth)/2 + QuietZone*scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Black(x, y) {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG renders the code as a vector image of size pixels.
+func (c *Code) SVG(size int) []byte {
+	width := c.Size + 2*QuietZone
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+QuietZone, y+QuietZone)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(
+		&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" `+
+			`shape-rendering="crispEdges">`, size, size, width, width,
+	)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`, path.String())
+	return buf.Bytes()
+}
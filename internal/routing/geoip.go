@@ -0,0 +1,54 @@
+package routing
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+)
+
+// GeoIP resolves the ISO 3166-1 country code of an address, an empty code is
+// returned for unknown ones.
+type GeoIP interface {
+	Country(ip net.IP) (string, error)
+}
+
+// MaxMindDB reads countries from an offline MaxMind DB file such as
+// GeoLite2-Country or GeoLite2-City.
+type MaxMindDB struct {
+	reader *maxminddb.Reader
+}
+
+func OpenMaxMindDB(path string) (*MaxMindDB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MaxMindDB{reader: reader}, nil
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Country falls back to the registered country of the network when the
+// location is unknown.
+func (db *MaxMindDB) Country(ip net.IP) (string, error) {
+	var record countryRecord
+	if err := db.reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode, nil
+	}
+
+	return record.RegisteredCountry.ISOCode, nil
+}
+
+func (db *MaxMindDB) Close() error {
+	return db.reader.Close()
+}
@@ -0,0 +1,121 @@
+package routing
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Policies passing the query of a visit to the destination.
+const (
+	// QueryDrop ignores the query of the visit, it is the default.
+	QueryDrop = "drop"
+	// QueryMerge adds the parameters the destination does not have.
+	QueryMerge = "merge"
+	// QueryOverride replaces the parameters of the destination.
+	QueryOverride = "override"
+)
+
+// UTMParams are the parameters set by BuildUTM.
+var UTMParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// maxUTMLen bounds the length of a UTM value.
+const maxUTMLen = 255
+
+// NormalizeQueryPolicy returns the stored policy, empty for QueryDrop.
+func NormalizeQueryPolicy(policy string) (string, error) {
+	switch policy = strings.ToLower(strings.TrimSpace(policy)); policy {
+	case "", QueryDrop:
+		return "", nil
+	case QueryMerge, QueryOverride:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown query policy %q, expected one of: drop, merge, override", policy)
+}
+
+// BuildUTM encodes the UTM parameters keyed by their names with or without the
+// utm_ prefix, empty values are skipped.
+func BuildUTM(params map[string]string) (string, error) {
+	query := make(url.Values)
+	for name, value := range params {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(name))
+		if !strings.HasPrefix(key, "utm_") {
+			key = "utm_" + key
+		}
+		if !isUTMParam(key) {
+			return "", fmt.Errorf("unknown UTM parameter %q, expected one of: %s", name, strings.Join(UTMParams, ", "))
+		}
+		if len(value) > maxUTMLen {
+			return "", fmt.Errorf("UTM parameter %s is longer than %d bytes", key, maxUTMLen)
+		}
+		query.Set(key, value)
+	}
+
+	return query.Encode(), nil
+}
+
+func isUTMParam(key string) bool {
+	for _, param := range UTMParams {
+		if key == param {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildLocation adds the encoded UTM parameters to the target replacing its
+// own ones, then the visit query by the policy: merged parameters never replace
+// the ones of the target or the UTM ones, overriding ones replace both. The
+// parameters of the target not replaced are kept as they are written.
+func BuildLocation(target, utm string, visit url.Values, policy string) (string, error) {
+	if utm == "" && (len(visit) == 0 || policy != QueryMerge && policy != QueryOverride) {
+		return target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	added, err := url.ParseQuery(utm)
+	if err != nil {
+		return "", err
+	}
+	replaced := make(map[string]bool)
+	for key := range added {
+		replaced[key] = true
+	}
+	own := make(map[string]bool)
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, _, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		own[key] = true
+		if policy == QueryOverride && len(visit[key]) > 0 {
+			replaced[key] = true
+		}
+		if !replaced[key] {
+			kept = append(kept, pair)
+		}
+	}
+	for key, values := range visit {
+		if policy == QueryOverride || policy == QueryMerge && !own[key] && !added.Has(key) {
+			added[key] = values
+		}
+	}
+	if encoded := added.Encode(); encoded != "" {
+		kept = append(kept, encoded)
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
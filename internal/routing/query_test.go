@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"testing"
+)
+
+func TestBuildLocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		utm    string
+		visit  string
+		policy string
+		want   string
+	}{
+		{
+			name:   "drop",
+			target: "https://ya.ru/search?q=a,b",
+			visit:  "utm_source=x",
+			want:   "https://ya.ru/search?q=a,b",
+		},
+		{
+			name:   "utm_replaces_own",
+			target: "https://ya.ru/?q=a,b&utm_source=old#top",
+			utm:    "utm_medium=email&utm_source=news",
+			visit:  "ref=1",
+			want:   "https://ya.ru/?q=a,b&utm_medium=email&utm_source=news#top",
+		},
+		{
+			name:   "merge",
+			target: "https://ya.ru/?q=a&ref=own",
+			utm:    "utm_source=news",
+			visit:  "ref=visit&utm_source=visit&gclid=1",
+			policy: QueryMerge,
+			want:   "https://ya.ru/?q=a&ref=own&gclid=1&utm_source=news",
+		},
+		{
+			name:   "override",
+			target: "https://ya.ru/?q=a&ref=own",
+			utm:    "utm_source=news",
+			visit:  "ref=visit&utm_source=visit",
+			policy: QueryOverride,
+			want:   "https://ya.ru/?q=a&ref=visit&utm_source=visit",
+		},
+		{
+			name:   "encoding",
+			target: "https://ya.ru/path",
+			visit:  "q=весна 2024&a=b&c",
+			policy: QueryMerge,
+			want:   "https://ya.ru/path?a=b&c=&q=%D0%B2%D0%B5%D1%81%D0%BD%D0%B0+2024",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				visit, err := url.ParseQuery(tt.visit)
+				require.NoError(t, err)
+				location, err := BuildLocation(tt.target, tt.utm, visit, tt.policy)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, location, "Адрес перехода собран неверно")
+			},
+		)
+	}
+}
+
+func TestBuildUTM(t *testing.T) {
+	utm, err := BuildUTM(map[string]string{"source": "news letter", "utm_campaign": "весна", "term": " "})
+	require.NoError(t, err)
+	assert.Equal(t, "utm_campaign=%D0%B2%D0%B5%D1%81%D0%BD%D0%B0&utm_source=news+letter", utm)
+
+	_, err = BuildUTM(map[string]string{"utm_id": "1"})
+	assert.Error(t, err, "Принят неизвестный параметр")
+}
@@ -0,0 +1,225 @@
+package routing
+
+import (
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Devices matched by rules, DeviceMobile matches iOS and Android devices as
+// well.
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+)
+
+// Visit is what rules are matched against.
+type Visit struct {
+	Device string
+	// Language is the most preferred language of the visitor, lower cased.
+	Language string
+	// Country is the ISO 3166-1 code of the visitor, empty if unknown.
+	Country string
+}
+
+// ParseDevice guesses the device from the User-Agent header, everything not
+// recognized as mobile is a desktop.
+func ParseDevice(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "Windows Phone"):
+		return DeviceMobile
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"),
+		strings.Contains(userAgent, "iPod"):
+		return DeviceIOS
+	case strings.Contains(userAgent, "Android"):
+		return DeviceAndroid
+	case strings.Contains(userAgent, "Mobile"), strings.Contains(userAgent, "Opera Mini"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// PreferredLanguage returns the language of the Accept-Language header with
+// the highest quality, the first one of equal ones.
+func PreferredLanguage(acceptLanguage string) string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+	var languages []language
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			languages = append(languages, language{tag: tag, quality: quality})
+		}
+	}
+	sort.SliceStable(
+		languages, func(i, j int) bool {
+			return languages[i].quality > languages[j].quality
+		},
+	)
+	if len(languages) == 0 {
+		return ""
+	}
+	return languages[0].tag
+}
+
+// Match reports whether the visit matches all conditions of the rule. A
+// language matches its regional variants, "en" matches "en-gb".
+func Match(rule store.Rule, visit Visit) bool {
+	return matchAny(rule.Devices, visit.Device, matchDevice) &&
+		matchAny(rule.Languages, visit.Language, matchLanguage) &&
+		matchAny(rule.Countries, visit.Country, func(country, visited string) bool { return country == visited })
+}
+
+func matchAny(values []string, visited string, match func(value, visited string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if match(value, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchDevice(device, visited string) bool {
+	if device == DeviceMobile {
+		return visited == DeviceMobile || visited == DeviceIOS || visited == DeviceAndroid
+	}
+	return device == visited
+}
+
+func matchLanguage(language, visited string) bool {
+	return language == visited || strings.HasPrefix(visited, language+"-")
+}
+
+// Target returns the target of the first rule matching the visit, false if
+// none does.
+func Target(rules []store.Rule, visit Visit) (string, bool) {
+	for _, rule := range rules {
+		if Match(rule, visit) {
+			return rule.Target, true
+		}
+	}
+	return "", false
+}
+
+// ChooseVariant picks the index of a variant with the probability of its
+// weight, intn returns a random number in [0, n) as rand.Intn does. It returns
+// -1 if there are no variants.
+func ChooseVariant(variants []store.Variant, intn func(n int) int) int {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return -1
+	}
+	n := intn(total)
+	for i, v := range variants {
+		if n < v.Weight {
+			return i
+		}
+		n -= v.Weight
+	}
+	return -1
+}
+
+// UsesCountries reports whether any of the rules needs the country of the
+// visitor.
+func UsesCountries(rules []store.Rule) bool {
+	for _, rule := range rules {
+		if len(rule.Countries) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxWeight bounds the weight of a variant.
+const MaxWeight = 10000
+
+// NormalizeVariant checks that the target is an absolute URL and the weight is
+// between 1 and MaxWeight.
+func NormalizeVariant(variant store.Variant) (store.Variant, error) {
+	normalized := store.Variant{Target: strings.TrimSpace(variant.Target), Weight: variant.Weight}
+	if target, err := url.Parse(normalized.Target); err != nil || !target.IsAbs() {
+		return variant, fmt.Errorf("target %q is not an absolute URL", normalized.Target)
+	}
+	if variant.Weight < 1 || variant.Weight > MaxWeight {
+		return variant, fmt.Errorf("weight %d of %q is not between 1 and %d", variant.Weight, normalized.Target, MaxWeight)
+	}
+
+	return normalized, nil
+}
+
+// NormalizeRule lower cases devices and languages and upper cases countries,
+// the target must be an absolute URL. Commas are not allowed in values, they
+// separate them in storage.
+func NormalizeRule(rule store.Rule) (store.Rule, error) {
+	normalized := store.Rule{Target: strings.TrimSpace(rule.Target)}
+	if target, err := url.Parse(normalized.Target); err != nil || !target.IsAbs() {
+		return rule, fmt.Errorf("target %q is not an absolute URL", normalized.Target)
+	}
+	for _, device := range rule.Devices {
+		device = strings.ToLower(strings.TrimSpace(device))
+		switch device {
+		case DeviceIOS, DeviceAndroid, DeviceMobile, DeviceDesktop:
+		default:
+			return rule, fmt.Errorf(
+				"unknown device %q, expected one of: ios, android, mobile, desktop", device,
+			)
+		}
+		normalized.Devices = append(normalized.Devices, device)
+	}
+	for _, language := range rule.Languages {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if !validCode(language, 1, 35, true) {
+			return rule, fmt.Errorf("invalid language %q", language)
+		}
+		normalized.Languages = append(normalized.Languages, language)
+	}
+	for _, country := range rule.Countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !validCode(country, 2, 2, false) {
+			return rule, fmt.Errorf("invalid country %q, expected an ISO 3166-1 code", country)
+		}
+		normalized.Countries = append(normalized.Countries, country)
+	}
+
+	return normalized, nil
+}
+
+func validCode(code string, minLen, maxLen int, hyphens bool) bool {
+	if len(code) < minLen || len(code) > maxLen || strings.HasPrefix(code, "-") || strings.HasSuffix(code, "-") {
+		return false
+	}
+	for _, r := range code {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && hyphens:
+		case r == '-' && hyphens:
+		default:
+			return false
+		}
+	}
+	return true
+}
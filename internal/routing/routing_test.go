@@ -0,0 +1,106 @@
+package routing
+
+import (
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{
+			name:      "iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148",
+			want:      DeviceIOS,
+		},
+		{
+			name:      "android",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36",
+			want:      DeviceAndroid,
+		},
+		{
+			name:      "windows_phone",
+			userAgent: "Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Microsoft; Lumia 950) Mobile Safari/537.36",
+			want:      DeviceMobile,
+		},
+		{
+			name:      "desktop",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36",
+			want:      DeviceDesktop,
+		},
+		{name: "empty", userAgent: "", want: DeviceDesktop},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, ParseDevice(tt.userAgent), "Устройство определено неверно")
+			},
+		)
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           string
+	}{
+		{name: "single", acceptLanguage: "ru-RU", want: "ru-ru"},
+		{name: "quality", acceptLanguage: "en;q=0.8, de-CH;q=0.9, *;q=0.5", want: "de-ch"},
+		{name: "first_of_equal", acceptLanguage: "fr, en", want: "fr"},
+		{name: "zero_quality", acceptLanguage: "en;q=0, ru;q=0.1", want: "ru"},
+		{name: "wildcard", acceptLanguage: "*", want: ""},
+		{name: "empty", acceptLanguage: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, PreferredLanguage(tt.acceptLanguage), "Язык определён неверно")
+			},
+		)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	rules := []store.Rule{
+		{Devices: []string{DeviceIOS}, Target: "https://apps.apple.com/app"},
+		{Devices: []string{DeviceAndroid}, Countries: []string{"RU"}, Target: "https://rustore.ru/app"},
+		{Devices: []string{DeviceAndroid}, Target: "https://play.google.com/app"},
+		{Devices: []string{DeviceMobile}, Languages: []string{"de"}, Target: "https://m.example.de"},
+	}
+	tests := []struct {
+		name  string
+		visit Visit
+		want  string
+	}{
+		{name: "ios", visit: Visit{Device: DeviceIOS, Language: "de"}, want: "https://apps.apple.com/app"},
+		{name: "android_country", visit: Visit{Device: DeviceAndroid, Country: "RU"}, want: "https://rustore.ru/app"},
+		{name: "android", visit: Visit{Device: DeviceAndroid, Country: "DE"}, want: "https://play.google.com/app"},
+		{name: "mobile_region", visit: Visit{Device: DeviceMobile, Language: "de-at"}, want: "https://m.example.de"},
+		{name: "mobile_other_language", visit: Visit{Device: DeviceMobile, Language: "dea"}, want: ""},
+		{name: "desktop", visit: Visit{Device: DeviceDesktop, Language: "de"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				target, ok := Target(rules, tt.visit)
+				assert.Equal(t, tt.want, target, "Цель перехода выбрана неверно")
+				assert.Equal(t, tt.want != "", ok)
+			},
+		)
+	}
+}
+
+func TestChooseVariant(t *testing.T) {
+	variants := []store.Variant{{Target: "https://a.ru", Weight: 3}, {Target: "https://b.ru", Weight: 1}}
+	served := make([]int, len(variants))
+	for n := 0; n < 4; n++ {
+		served[ChooseVariant(variants, func(int) int { return n })]++
+	}
+	assert.Equal(t, []int{3, 1}, served, "Варианты выбраны не по весам")
+	assert.Equal(t, -1, ChooseVariant(nil, func(int) int { return 0 }), "Выбран вариант из пустого списка")
+}
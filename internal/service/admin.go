@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/audit"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// SetAuditLog replaces the recorder of administrative actions, they are
+// written to stderr by default.
+func (s *Service) SetAuditLog(auditLog audit.Recorder) {
+	s.auditLog = auditLog
+}
+
+// record writes the audit entry of the action, the actions changing URLs are
+// recorded before they are applied so none of them goes unrecorded.
+func (s *Service) record(ctx context.Context, actor, action, target string, URLs []store.URL) error {
+	entry := audit.Entry{Actor: actor, Action: action, Target: target}
+	for _, u := range URLs {
+		entry.ShortURLs = append(entry.ShortURLs, u.ShortURL)
+	}
+	if err := s.auditLog.Record(ctx, entry); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) lookup(ctx context.Context, id string) (store.URL, error) {
+	reader, ok := s.reader.(store.URLInfoReader)
+	if !ok {
+		return store.URL{}, ErrNotSupported
+	}
+	URL, err := reader.GetURLInfo(ctx, id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return store.URL{}, ErrNotFound
+		}
+		return store.URL{}, fmt.Errorf("failed to get url: %w", err)
+	}
+
+	return URL, nil
+}
+
+func (s *Service) AdminLookup(ctx context.Context, actor, id string) (store.URL, error) {
+	URL, err := s.lookup(ctx, id)
+	if err != nil {
+		return store.URL{}, err
+	}
+
+	return URL, s.record(ctx, actor, "lookup", id, nil)
+}
+
+// AdminDelete deletes the URL at once regardless of its owner.
+func (s *Service) AdminDelete(ctx context.Context, actor, id string) error {
+	writer, ok := s.writer.(store.DeleteURLs)
+	if !ok {
+		return ErrNotSupported
+	}
+	URL, err := s.lookup(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.record(ctx, actor, "delete", id, []store.URL{URL}); err != nil {
+		return err
+	}
+	if err := writer.DeleteURLs(ctx, []store.URL{URL}); err != nil {
+		return fmt.Errorf("failed to delete url: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) AdminRestore(ctx context.Context, actor, id string) error {
+	writer, ok := s.writer.(store.Restorer)
+	if !ok {
+		return ErrNotSupported
+	}
+	URL, err := s.lookup(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.record(ctx, actor, "restore", id, []store.URL{URL}); err != nil {
+		return err
+	}
+	if err := writer.RestoreURLs(ctx, []store.URL{URL}); err != nil {
+		return fmt.Errorf("failed to restore url: %w", err)
+	}
+
+	return nil
+}
+
+// AdminDisableUser deletes all URLs of the user and returns how many of them
+// were not deleted before.
+func (s *Service) AdminDisableUser(ctx context.Context, actor string, userID uuid.UUID) (int, error) {
+	reader, err := s.userIDReader()
+	if err != nil {
+		return 0, err
+	}
+	urls, err := reader.GetURLsByUserID(ctx, userID.String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get urls by user ID: %w", err)
+	}
+	var active []store.URL
+	for _, u := range urls {
+		if !u.IsDeleted {
+			active = append(active, u)
+		}
+	}
+
+	return len(active), s.disable(ctx, actor, "disable_user", userID.String(), active)
+}
+
+// AdminDisableDomain deletes all URLs pointing at the domain or its
+// subdomains and returns how many of them were not deleted before.
+func (s *Service) AdminDisableDomain(ctx context.Context, actor, domain string) (int, error) {
+	iterable, ok := s.reader.(store.Iterable)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	var active []store.URL
+	err := iterable.ForEachURL(
+		ctx, func(URL store.URL) error {
+			if !URL.IsDeleted && utils.MatchDomain(utils.URLHost(URL.OriginalURL), domain) {
+				active = append(active, URL)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to iterate urls: %w", err)
+	}
+
+	return len(active), s.disable(ctx, actor, "disable_domain", domain, active)
+}
+
+func (s *Service) disable(ctx context.Context, actor, action, target string, URLs []store.URL) error {
+	writer, ok := s.writer.(store.DeleteURLs)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := s.record(ctx, actor, action, target, URLs); err != nil {
+		return err
+	}
+	if len(URLs) > 0 {
+		if err := writer.DeleteURLs(ctx, URLs); err != nil {
+			return fmt.Errorf("failed to delete urls: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDeletionNotFound = errors.New("deletion job not found")
+	ErrQueueFull        = errors.New("deletion queue is full")
+)
+
+type DeletionStatus string
+
+const (
+	DeletionQueued  DeletionStatus = "queued"
+	DeletionFailed  DeletionStatus = "failed"
+	DeletionApplied DeletionStatus = "applied"
+	// DeletionPartiallyOwned means the owned ids are deleted and the rest of
+	// the requested ones, listed in NotOwned, are left as is.
+	DeletionPartiallyOwned DeletionStatus = "partially_owned"
+	// DeletionDeadLettered means all retries failed and the URLs were handed
+	// to the DeadLetterSink.
+	DeletionDeadLettered DeletionStatus = "dead_lettered"
+)
+
+const (
+	// deletionJobTTL is how long finished jobs can be polled.
+	deletionJobTTL = 24 * time.Hour
+	// maxDeletionJobs is the number of tracked jobs, the oldest finished ones
+	// are forgotten before their TTL passes to stay below it.
+	maxDeletionJobs = 10000
+)
+
+// DeletionJob tracks a DeleteForUser request. A failed job is retried until
+// it is applied or dead lettered, Error holds the last failure.
+type DeletionJob struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Status    DeletionStatus
+	Accepted  []string
+	NotOwned  []string
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type deletionBatch struct {
+	jobID  uuid.UUID
+	userID uuid.UUID
+	URLs   []store.URL
+}
+
+type deletionJobs struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*DeletionJob
+	// order lists the ids of the jobs oldest first.
+	order []uuid.UUID
+	max   int
+}
+
+func newDeletionJobs() *deletionJobs {
+	return &deletionJobs{jobs: make(map[uuid.UUID]*DeletionJob), max: maxDeletionJobs}
+}
+
+func newDeletionJob(userID uuid.UUID, ids []string, owned []store.URL) *DeletionJob {
+	now := time.Now().UTC()
+	job := &DeletionJob{ID: uuid.New(), UserID: userID, Status: DeletionQueued, CreatedAt: now, UpdatedAt: now}
+	accepted := make(map[string]bool, len(owned))
+	for _, u := range owned {
+		accepted[u.ShortURL] = true
+		job.Accepted = append(job.Accepted, u.ShortURL)
+	}
+	for _, id := range ids {
+		if !accepted[id] {
+			job.NotOwned = append(job.NotOwned, id)
+		}
+	}
+	if len(owned) == 0 {
+		job.complete()
+	}
+
+	return job
+}
+
+func (j *DeletionJob) finished() bool {
+	return j.Status != DeletionQueued && j.Status != DeletionFailed
+}
+
+func (j *DeletionJob) complete() {
+	j.Status = DeletionApplied
+	if len(j.NotOwned) > 0 {
+		j.Status = DeletionPartiallyOwned
+	}
+	j.Error = ""
+}
+
+// add tracks the job, false is returned if all tracked jobs are unfinished.
+func (dj *deletionJobs) add(job *DeletionJob) bool {
+	dj.mu.Lock()
+	defer dj.mu.Unlock()
+	dj.prune(time.Now().UTC())
+	if len(dj.jobs) >= dj.max {
+		return false
+	}
+	dj.jobs[job.ID] = job
+	dj.order = append(dj.order, job.ID)
+
+	return true
+}
+
+// prune forgets the jobs finished longer than deletionJobTTL ago from the
+// oldest one on, and the oldest finished jobs when there are max jobs.
+func (dj *deletionJobs) prune(now time.Time) {
+	i := 0
+	for ; i < len(dj.order); i++ {
+		job, ok := dj.jobs[dj.order[i]]
+		if !ok {
+			continue
+		}
+		if !job.finished() || now.Sub(job.UpdatedAt) <= deletionJobTTL {
+			break
+		}
+		delete(dj.jobs, job.ID)
+	}
+	dj.order = dj.order[i:]
+	if len(dj.jobs) < dj.max {
+		return
+	}
+
+	// Free a tenth of the jobs at once so that the scan is rare.
+	excess := len(dj.jobs) - dj.max + dj.max/10 + 1
+	order := make([]uuid.UUID, 0, len(dj.jobs))
+	for _, id := range dj.order {
+		job, ok := dj.jobs[id]
+		if !ok {
+			continue
+		}
+		if excess > 0 && job.finished() {
+			delete(dj.jobs, id)
+			excess--
+			continue
+		}
+		order = append(order, id)
+	}
+	dj.order = order
+}
+
+func (dj *deletionJobs) remove(id uuid.UUID) {
+	dj.mu.Lock()
+	defer dj.mu.Unlock()
+	delete(dj.jobs, id)
+}
+
+func (dj *deletionJobs) get(id uuid.UUID) (DeletionJob, bool) {
+	dj.mu.Lock()
+	defer dj.mu.Unlock()
+	dj.prune(time.Now().UTC())
+	job, ok := dj.jobs[id]
+	if !ok {
+		return DeletionJob{}, false
+	}
+
+	return *job, true
+}
+
+// finish records the result of a flush of the batches. Accepted ids missing in deleted,
+// unless it is nil, are moved to NotOwned. Failed jobs become dead lettered if
+// deadLettered is set.
+func (dj *deletionJobs) finish(batches []deletionBatch, deleted map[string]bool, err error, deadLettered bool) {
+	dj.mu.Lock()
+	defer dj.mu.Unlock()
+	now := time.Now().UTC()
+	for _, b := range batches {
+		job, ok := dj.jobs[b.jobID]
+		if !ok {
+			continue
+		}
+		job.UpdatedAt = now
+		if err != nil {
+			job.Status = DeletionFailed
+			if deadLettered {
+				job.Status = DeletionDeadLettered
+			}
+			job.Error = err.Error()
+			continue
+		}
+		if deleted != nil {
+			accepted := job.Accepted[:0:0]
+			for _, id := range job.Accepted {
+				if deleted[id] {
+					accepted = append(accepted, id)
+				} else {
+					job.NotOwned = append(job.NotOwned, id)
+				}
+			}
+			job.Accepted = accepted
+		}
+		job.complete()
+	}
+}
+
+// GetDeletion returns the deletion job of the user.
+func (s *Service) GetDeletion(ctx context.Context, userID, jobID uuid.UUID) (DeletionJob, error) {
+	job, ok := s.deletions.get(jobID)
+	if !ok || job.UserID != userID {
+		return DeletionJob{}, ErrDeletionNotFound
+	}
+
+	return job, nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidMetadata = errors.New("invalid link metadata")
+
+const (
+	maxTitleLen = 255
+	maxNoteLen  = 1000
+	maxTags     = 20
+	maxTagLen   = 50
+)
+
+// normalizeMetadata trims the fields, tags are lower cased, deduplicated and
+// sorted. Commas are not allowed in tags, they separate tags in exports.
+func normalizeMetadata(meta store.Metadata) (store.Metadata, error) {
+	meta.Title = strings.TrimSpace(meta.Title)
+	meta.Note = strings.TrimSpace(meta.Note)
+	if utf8.RuneCountInString(meta.Title) > maxTitleLen {
+		return meta, fmt.Errorf("%w: title is longer than %d characters", ErrInvalidMetadata, maxTitleLen)
+	}
+	if utf8.RuneCountInString(meta.Note) > maxNoteLen {
+		return meta, fmt.Errorf("%w: note is longer than %d characters", ErrInvalidMetadata, maxNoteLen)
+	}
+	tags, err := normalizeTags(meta.Tags)
+	if err != nil {
+		return meta, err
+	}
+	if len(tags) > maxTags {
+		return meta, fmt.Errorf("%w: more than %d tags", ErrInvalidMetadata, maxTags)
+	}
+	meta.Tags = tags
+
+	return meta, nil
+}
+
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		switch {
+		case tag == "":
+			return nil, fmt.Errorf("%w: tag is empty", ErrInvalidMetadata)
+		case utf8.RuneCountInString(tag) > maxTagLen:
+			return nil, fmt.Errorf("%w: tag is longer than %d characters", ErrInvalidMetadata, maxTagLen)
+		case strings.Contains(tag, ","):
+			return nil, fmt.Errorf("%w: tag %q contains a comma", ErrInvalidMetadata, tag)
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+
+	return normalized, nil
+}
+
+// UpdateMetadata replaces the title, tags and note of the user's URL.
+func (s *Service) UpdateMetadata(ctx context.Context, userID uuid.UUID, id string, meta store.Metadata) (store.URL, error) {
+	writer, ok := s.writer.(store.MetadataUpdater)
+	if !ok {
+		return store.URL{}, ErrNotSupported
+	}
+	meta, err := normalizeMetadata(meta)
+	if err != nil {
+		return store.URL{}, err
+	}
+	URL, err := writer.UpdateMetadata(ctx, userID.String(), id, meta)
+	if err != nil {
+		var deletedErr *store.DeletedURLError
+		switch {
+		case errors.As(err, &deletedErr):
+			return store.URL{}, ErrDeleted
+		case errors.Is(err, store.ErrNotFound):
+			return store.URL{}, ErrNotFound
+		}
+		return store.URL{}, fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return URL, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"golang.org/x/crypto/bcrypt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidPassword  = errors.New("invalid link password")
+	ErrPasswordRequired = errors.New("short url is protected by a password")
+	ErrWrongPassword    = errors.New("wrong password")
+)
+
+// TooManyAttemptsError is returned when the password of a short URL was
+// entered too many times, RetryAfter is when the next attempt is allowed.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (te *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many password attempts, retry after %s", te.RetryAfter.Round(time.Second))
+}
+
+const (
+	// maxPasswordLen is the longest password bcrypt hashes whole.
+	maxPasswordLen        = 72
+	maxPasswordAttempts   = 5
+	passwordAttemptWindow = time.Minute
+	// maxTrackedSlugs bounds the attempts kept in memory, expired ones are
+	// dropped when it is reached.
+	maxTrackedSlugs = 10000
+)
+
+func hashPassword(password string) (string, error) {
+	if len(password) > maxPasswordLen {
+		return "", fmt.Errorf("%w: password is longer than %d bytes", ErrInvalidPassword, maxPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+type attempts struct {
+	count int
+	since time.Time
+}
+
+// attemptLimiter allows maxAttempts password attempts per short URL in a
+// window starting with the first one. Attempts are counted before the password
+// is checked, so concurrent guesses cannot exceed the limit.
+type attemptLimiter struct {
+	mu          sync.Mutex
+	maxAttempts int
+	window      time.Duration
+	now         func() time.Time
+	attempts    map[string]*attempts
+}
+
+func newAttemptLimiter(maxAttempts int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		maxAttempts: maxAttempts, window: window, now: time.Now, attempts: make(map[string]*attempts),
+	}
+}
+
+// allow counts an attempt for the id, it returns false with the time left
+// until the window ends when the attempts are used up.
+func (l *attemptLimiter) allow(id string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	a, ok := l.attempts[id]
+	if !ok || !now.Before(a.since.Add(l.window)) {
+		if !ok && len(l.attempts) >= maxTrackedSlugs {
+			l.dropExpired(now)
+		}
+		a = &attempts{since: now}
+		l.attempts[id] = a
+	}
+	if a.count >= l.maxAttempts {
+		return a.since.Add(l.window).Sub(now), false
+	}
+	a.count++
+
+	return 0, true
+}
+
+func (l *attemptLimiter) reset(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, id)
+}
+
+func (l *attemptLimiter) dropExpired(now time.Time) {
+	for id, a := range l.attempts {
+		if !now.Before(a.since.Add(l.window)) {
+			delete(l.attempts, id)
+		}
+	}
+}
+
+// Unlock returns the URL of the short URL unless it is deleted. Links with a
+// password are returned only for the right password, ErrPasswordRequired is
+// returned when it is empty. Attempts are limited per short URL.
+func (s *Service) Unlock(ctx context.Context, id, password string) (store.URL, error) {
+	URL, err := s.Lookup(ctx, id)
+	if err != nil {
+		return store.URL{}, err
+	}
+	if URL.PasswordHash == "" {
+		return URL, nil
+	}
+	if password == "" {
+		return store.URL{}, ErrPasswordRequired
+	}
+	if retryAfter, ok := s.passwordAttempts.allow(id); !ok {
+		return store.URL{}, &TooManyAttemptsError{RetryAfter: retryAfter}
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(URL.PasswordHash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return store.URL{}, ErrWrongPassword
+		}
+		return store.URL{}, fmt.Errorf("failed to verify password: %w", err)
+	}
+	s.passwordAttempts.reset(id)
+
+	return URL, nil
+}
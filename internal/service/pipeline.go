@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"io"
+	"sync"
+	"time"
+)
+
+// DeletionConfig tunes the asynchronous deletion of user URLs, zero fields
+// take the defaults.
+type DeletionConfig struct {
+	// Workers is the number of goroutines flushing queued deletions.
+	Workers int
+	// QueueSize is the number of DeleteForUser requests waiting for workers.
+	QueueSize int
+	// MaxBatch is the number of URLs a worker flushes at once.
+	MaxBatch int
+	// FlushInterval is how long a worker waits for MaxBatch URLs.
+	FlushInterval time.Duration
+	// MaxRetries is the number of failed flushes before the URLs are dead
+	// lettered.
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (cfg DeletionConfig) withDefaults() DeletionConfig {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = 1000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 10 * time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = time.Second
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+
+	return cfg
+}
+
+// DeadLetterSink receives URLs the workers failed to delete after all
+// retries.
+type DeadLetterSink interface {
+	DeadLetter(ctx context.Context, jobID uuid.UUID, URLs []store.URL, cause error) error
+}
+
+type logDeadLetter struct {
+	myLogger logger.MyLogger
+}
+
+func (l *logDeadLetter) DeadLetter(ctx context.Context, jobID uuid.UUID, URLs []store.URL, cause error) error {
+	var shortURLs []string
+	for _, u := range URLs {
+		shortURLs = append(shortURLs, u.ShortURL)
+	}
+	l.myLogger.L.Error(
+		"dead lettered URLs deletion", zap.Stringer("job_id", jobID), zap.Strings("short_urls", shortURLs),
+		zap.Error(cause),
+	)
+
+	return nil
+}
+
+type deadLetterEntry struct {
+	Time      time.Time `json:"time"`
+	JobID     uuid.UUID `json:"job_id"`
+	ShortURLs []string  `json:"short_urls"`
+	Error     string    `json:"error"`
+}
+
+// DeadLetterLog writes dead lettered deletions to w as JSON lines.
+type DeadLetterLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewDeadLetterLog(w io.Writer) *DeadLetterLog {
+	return &DeadLetterLog{w: w}
+}
+
+func (l *DeadLetterLog) DeadLetter(ctx context.Context, jobID uuid.UUID, URLs []store.URL, cause error) error {
+	entry := deadLetterEntry{Time: time.Now().UTC(), JobID: jobID, Error: cause.Error()}
+	for _, u := range URLs {
+		entry.ShortURLs = append(entry.ShortURLs, u.ShortURL)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(data, '\n'))
+
+	return err
+}
+
+// deletionWorker collects queued deletions until MaxBatch URLs are pending or
+// FlushInterval passes and flushes them. The queue is not read while a flush
+// is retried, so DeleteForUser fails with ErrQueueFull instead of piling up
+// URLs. When ctx is done the worker flushes the queued deletions once more and
+// stops.
+func (s *Service) deletionWorker(ctx context.Context) {
+	ticker := time.NewTicker(s.deletionConfig.FlushInterval)
+	defer ticker.Stop()
+
+	var batches []deletionBatch
+	var size int
+	// Deletions are flushed on shutdown too, so ctx does not cancel them.
+	storeCtx := context.Background()
+	flush := func() {
+		if len(batches) > 0 {
+			s.flushWithRetry(storeCtx, ctx.Done(), batches)
+		}
+		batches, size = nil, 0
+	}
+
+	for {
+		select {
+		case batch := <-s.storeChan:
+			batches = append(batches, batch)
+			size += len(batch.URLs)
+			if size >= s.deletionConfig.MaxBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case batch := <-s.storeChan:
+					batches = append(batches, batch)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushWithRetry retries only the batches of the users whose deletion failed.
+// Once stop is closed failed batches are dead lettered without retries.
+func (s *Service) flushWithRetry(ctx context.Context, stop <-chan struct{}, batches []deletionBatch) {
+	backoff := s.deletionConfig.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		last := attempt >= s.deletionConfig.MaxRetries || isClosed(stop)
+		failed, err := s.flushDeletions(ctx, batches, last)
+		batches = failed
+		if err == nil {
+			s.myLogger.L.Info("successfully deleted URLs")
+			return
+		}
+		s.myLogger.L.Error("failed to delete URLs", zap.Int("attempt", attempt), zap.Error(err))
+		if !last {
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				// the batches are flushed once more and dead lettered if they fail
+			}
+			backoff *= 2
+			if backoff > s.deletionConfig.MaxBackoff {
+				backoff = s.deletionConfig.MaxBackoff
+			}
+			continue
+		}
+		for _, b := range batches {
+			if err := s.deadLetter.DeadLetter(ctx, b.jobID, b.URLs, err); err != nil {
+				s.myLogger.L.Error("failed to dead letter URLs", zap.Stringer("job_id", b.jobID), zap.Error(err))
+			}
+		}
+		return
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// flushDeletions returns the batches failed to delete. With a store.OwnerDeleter
+// URLs are deleted with one owner scoped call per user, so a URL that changed
+// hands after DeleteForUser is left as is.
+func (s *Service) flushDeletions(ctx context.Context, batches []deletionBatch, last bool) ([]deletionBatch, error) {
+	if writer, ok := s.writer.(store.OwnerDeleter); ok {
+		return s.flushOwned(ctx, writer, batches, last)
+	}
+	writer, ok := s.writer.(store.DeleteURLs)
+	if !ok {
+		return batches, ErrNotSupported
+	}
+	var err error
+	for _, chunk := range chunkURLs(batchURLs(batches), s.deletionConfig.MaxBatch) {
+		if err = writer.DeleteURLs(ctx, chunk); err != nil {
+			break
+		}
+	}
+	s.deletions.finish(batches, nil, err, last)
+	if err != nil {
+		return batches, err
+	}
+
+	return nil, nil
+}
+
+func (s *Service) flushOwned(
+	ctx context.Context, writer store.OwnerDeleter, batches []deletionBatch, last bool,
+) ([]deletionBatch, error) {
+	var users []uuid.UUID
+	byUser := make(map[uuid.UUID][]deletionBatch)
+	for _, b := range batches {
+		if _, ok := byUser[b.userID]; !ok {
+			users = append(users, b.userID)
+		}
+		byUser[b.userID] = append(byUser[b.userID], b)
+	}
+	var failed []deletionBatch
+	var errs []error
+	for _, userID := range users {
+		userBatches := byUser[userID]
+		var deleted []store.URL
+		var err error
+		for _, chunk := range chunkURLs(batchURLs(userBatches), s.deletionConfig.MaxBatch) {
+			var chunkDeleted []store.URL
+			chunkDeleted, err = writer.DeleteUserURLs(ctx, userID.String(), chunk)
+			deleted = append(deleted, chunkDeleted...)
+			if err != nil {
+				break
+			}
+		}
+		deletedIDs := make(map[string]bool, len(deleted))
+		for _, u := range deleted {
+			deletedIDs[u.ShortURL] = true
+		}
+		s.deletions.finish(userBatches, deletedIDs, err, last)
+		if err != nil {
+			failed = append(failed, userBatches...)
+			errs = append(errs, err)
+		}
+	}
+
+	return failed, errors.Join(errs...)
+}
+
+func batchURLs(batches []deletionBatch) []store.URL {
+	var URLs []store.URL
+	for _, b := range batches {
+		URLs = append(URLs, b.URLs...)
+	}
+	return URLs
+}
+
+// chunkURLs splits URLs so that a store call deletes at most size of them.
+func chunkURLs(URLs []store.URL, size int) [][]store.URL {
+	var chunks [][]store.URL
+	for len(URLs) > size {
+		chunks = append(chunks, URLs[:size])
+		URLs = URLs[size:]
+	}
+	if len(URLs) > 0 {
+		chunks = append(chunks, URLs)
+	}
+	return chunks
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+)
+
+// UpdateDestination points the user's short URL to originalURL, the previous
+// one is kept in the revisions. Redirects are resolved from the store on every
+// request, the new destination applies at once.
+func (s *Service) UpdateDestination(ctx context.Context, userID uuid.UUID, id, originalURL string) (store.URL, error) {
+	if originalURL == "" {
+		return store.URL{}, ErrEmptyURL
+	}
+	writer, ok := s.writer.(store.DestinationUpdater)
+	if !ok {
+		return store.URL{}, ErrNotSupported
+	}
+	URL, err := writer.UpdateOriginalURL(ctx, userID.String(), id, originalURL)
+	if err != nil {
+		var conflictErr *store.ConflictError
+		var deletedErr *store.DeletedURLError
+		switch {
+		case errors.As(err, &conflictErr):
+			return store.URL{}, &ConflictError{ID: conflictErr.ShortURL}
+		case errors.As(err, &deletedErr):
+			return store.URL{}, ErrDeleted
+		case errors.Is(err, store.ErrNotFound):
+			return store.URL{}, ErrNotFound
+		}
+		return store.URL{}, fmt.Errorf("failed to update original url: %w", err)
+	}
+
+	return URL, nil
+}
+
+// ListRevisions returns the previous original URLs of the user's short URL,
+// oldest first.
+func (s *Service) ListRevisions(ctx context.Context, userID uuid.UUID, id string) ([]store.Revision, error) {
+	infoReader, ok := s.reader.(store.URLInfoReader)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	revisionReader, ok := s.reader.(store.RevisionReader)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	URL, err := infoReader.GetURLInfo(ctx, id)
+	if errors.Is(err, store.ErrNotFound) || err == nil && URL.UserID != userID {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url: %w", err)
+	}
+	revisions, err := revisionReader.GetRevisions(ctx, id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get revisions: %w", err)
+	}
+
+	return revisions, nil
+}
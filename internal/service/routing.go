@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"github.com/ZhuzhomaAL/go-shortener/internal/routing"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"go.uber.org/zap"
+	"math/rand"
+	"net"
+	"net/url"
+)
+
+// Visit is what the destination of a short URL depends on.
+type Visit struct {
+	Device   string
+	Language string
+	// IP is the address of the visitor, its country is looked up only for
+	// rules having country conditions.
+	IP net.IP
+	// Query is passed to the destination by the query policy of the URL.
+	Query url.Values
+	// Variant is the variant of a sticky split URL served to the visitor
+	// before, nil if there is none.
+	Variant *int
+}
+
+// NewVisit takes the device and the language of the visitor from the
+// User-Agent and Accept-Language headers.
+func NewVisit(userAgent, acceptLanguage string, ip net.IP, query url.Values) Visit {
+	return Visit{
+		Device: routing.ParseDevice(userAgent), Language: routing.PreferredLanguage(acceptLanguage), IP: ip,
+		Query: query,
+	}
+}
+
+// Destination is where a visit of a short URL leads.
+type Destination struct {
+	Location string
+	// Variant is the index of the variant of a split URL leading there, -1 if
+	// none does.
+	Variant int
+}
+
+// SetGeoIP sets the database resolving the countries of visitors for routing
+// rules, country conditions match no visitor without it.
+func (s *Service) SetGeoIP(geoIP routing.GeoIP) {
+	s.geoIP = geoIP
+}
+
+// Destination returns where the visit of the URL leads: the target of the
+// first matching rule, a variant of a split URL or the original URL, with the
+// UTM parameters of the URL and the query of the visit passed by its policy.
+// The visit is not counted, see Redirect.
+func (s *Service) Destination(URL store.URL, visit Visit) Destination {
+	target, index := s.target(URL, visit)
+	location, err := routing.BuildLocation(target, URL.UTM, visit.Query, URL.QueryPolicy)
+	if err != nil {
+		s.myLogger.L.Error("failed to build location", zap.Error(err), zap.String("short_url", URL.ShortURL))
+		location = target
+	}
+
+	return Destination{Location: location, Variant: index}
+}
+
+func (s *Service) target(URL store.URL, visit Visit) (string, int) {
+	if len(URL.Rules) > 0 {
+		ruleVisit := routing.Visit{Device: visit.Device, Language: visit.Language}
+		if s.geoIP != nil && visit.IP != nil && routing.UsesCountries(URL.Rules) {
+			country, err := s.geoIP.Country(visit.IP)
+			if err != nil {
+				s.myLogger.L.Error("failed to look up country", zap.Error(err))
+			}
+			ruleVisit.Country = country
+		}
+		if target, ok := routing.Target(URL.Rules, ruleVisit); ok {
+			return target, -1
+		}
+	}
+	if len(URL.Variants) == 0 {
+		return URL.OriginalURL, -1
+	}
+
+	index := -1
+	if URL.StickyVariants && visit.Variant != nil && *visit.Variant >= 0 && *visit.Variant < len(URL.Variants) {
+		index = *visit.Variant
+	}
+	if index < 0 {
+		index = routing.ChooseVariant(URL.Variants, rand.Intn)
+	}
+	// Imported variants may have no weights.
+	if index < 0 {
+		return URL.OriginalURL, -1
+	}
+
+	return URL.Variants[index].Target, index
+}
+
+// Redirect counts the redirect of a visit of the URL to the destination: the
+// click of the URL with a click limit and the variant served. ErrClickLimit
+// is returned when the limit was reached by other visits meanwhile.
+func (s *Service) Redirect(ctx context.Context, URL store.URL, destination Destination) error {
+	if err := s.CountClick(ctx, URL); err != nil {
+		return err
+	}
+	if destination.Variant >= 0 {
+		if err := s.CountVariant(ctx, URL, destination.Variant); err != nil {
+			s.myLogger.L.Error("failed to count variant", zap.Error(err), zap.String("short_url", URL.ShortURL))
+		}
+	}
+
+	return nil
+}
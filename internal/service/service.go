@@ -0,0 +1,524 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/audit"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/ZhuzhomaAL/go-shortener/internal/routing"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/dchest/uniuri"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEmptyURL           = errors.New("url is empty, expected not empty")
+	ErrNotFound           = errors.New("short url not found")
+	ErrDeleted            = errors.New("short url deleted")
+	ErrNotSupported       = errors.New("operation is not supported by the storage")
+	ErrClickLimit         = errors.New("short url reached its click limit")
+	ErrInvalidMaxClicks   = errors.New("max clicks must not be negative")
+	ErrInvalidRules       = errors.New("invalid routing rules")
+	ErrInvalidVariants    = errors.New("invalid split variants")
+	ErrInvalidUTM         = errors.New("invalid utm parameters")
+	ErrInvalidQueryPolicy = errors.New("invalid query policy")
+	ErrOptionsConflict    = errors.New("url is already shortened, its options can not be changed")
+)
+
+// ConflictError is returned when the URL is already shortened, ID is the
+// short URL id saved before.
+type ConflictError struct {
+	ID string
+}
+
+func (ce *ConflictError) Error() string {
+	return fmt.Sprintf("url already shortened as %s", ce.ID)
+}
+
+type Service struct {
+	ctx              context.Context
+	reader           store.Reader
+	writer           store.Writer
+	myLogger         logger.MyLogger
+	auditLog         audit.Recorder
+	deletionConfig   DeletionConfig
+	deadLetter       DeadLetterSink
+	storeChan        chan deletionBatch
+	workers          sync.WaitGroup
+	deletions        *deletionJobs
+	passwordAttempts *attemptLimiter
+	geoIP            routing.GeoIP
+}
+
+type Option func(s *Service)
+
+func WithDeletionConfig(cfg DeletionConfig) Option {
+	return func(s *Service) {
+		s.deletionConfig = cfg
+	}
+}
+
+func WithDeadLetter(sink DeadLetterSink) Option {
+	return func(s *Service) {
+		s.deadLetter = sink
+	}
+}
+
+// WithContext stops the deletion workers when ctx is done, queued deletions
+// are flushed before, see Wait.
+func WithContext(ctx context.Context) Option {
+	return func(s *Service) {
+		s.ctx = ctx
+	}
+}
+
+func New(reader store.Reader, writer store.Writer, myLogger logger.MyLogger, opts ...Option) *Service {
+	s := &Service{
+		ctx: context.Background(), reader: reader, writer: writer, myLogger: myLogger, auditLog: audit.NewLog(os.Stderr),
+		deletions: newDeletionJobs(), passwordAttempts: newAttemptLimiter(maxPasswordAttempts, passwordAttemptWindow),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.deletionConfig = s.deletionConfig.withDefaults()
+	if s.deadLetter == nil {
+		s.deadLetter = &logDeadLetter{myLogger: myLogger}
+	}
+	s.storeChan = make(chan deletionBatch, s.deletionConfig.QueueSize)
+	for i := 0; i < s.deletionConfig.Workers; i++ {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			s.deletionWorker(s.ctx)
+		}()
+	}
+
+	return s
+}
+
+// Wait waits until the deletion workers flush the queued deletions and stop
+// after the context of WithContext is done.
+func (s *Service) Wait() {
+	s.workers.Wait()
+}
+
+func newURL(userID uuid.UUID, originalURL string) store.URL {
+	return store.URL{
+		OriginalURL: originalURL,
+		ShortURL:    uniuri.NewLen(8),
+		UserID:      userID,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// ShortenOptions are the optional settings of a short URL made by
+// ShortenWithOptions.
+type ShortenOptions struct {
+	// Password protects the short URL, visitors are redirected only after
+	// entering it.
+	Password string
+	// MaxClicks limits the redirects of the short URL, zero is unlimited.
+	MaxClicks int
+	// Rules route visits to other targets than the original URL, the first
+	// matching one wins.
+	Rules []store.Rule
+	// Variants split the visits between weighted targets, StickyVariants
+	// serves a returning visitor the same one.
+	Variants       []store.Variant
+	StickyVariants bool
+	// UTM are the UTM parameters added to the destination keyed by their
+	// names, with or without the utm_ prefix.
+	UTM map[string]string
+	// QueryPolicy passes the query of the visit to the destination: drop,
+	// merge or override.
+	QueryPolicy string
+}
+
+// isSet reports whether the options set anything on the short URL.
+func (o ShortenOptions) isSet() bool {
+	return o.Password != "" || o.MaxClicks != 0 || len(o.Rules) > 0 || len(o.Variants) > 0 || len(o.UTM) > 0 ||
+		o.QueryPolicy != ""
+}
+
+const (
+	// maxRules bounds the routing rules of a short URL.
+	maxRules    = 20
+	maxVariants = 10
+)
+
+func (s *Service) Shorten(ctx context.Context, userID uuid.UUID, originalURL string) (string, error) {
+	return s.ShortenWithOptions(ctx, userID, originalURL, ShortenOptions{})
+}
+
+// ShortenWithOptions is Shorten applying opts to the new short URL. The URL
+// already shortened gets ErrOptionsConflict instead of ConflictError if opts
+// set anything, they are not applied to the existing short URL.
+func (s *Service) ShortenWithOptions(
+	ctx context.Context, userID uuid.UUID, originalURL string, opts ShortenOptions,
+) (string, error) {
+	if originalURL == "" {
+		return "", ErrEmptyURL
+	}
+	if opts.MaxClicks < 0 {
+		return "", ErrInvalidMaxClicks
+	}
+	rules, err := normalizeRules(opts.Rules)
+	if err != nil {
+		return "", err
+	}
+	variants, err := normalizeVariants(opts.Variants)
+	if err != nil {
+		return "", err
+	}
+	utm, err := routing.BuildUTM(opts.UTM)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidUTM, err)
+	}
+	queryPolicy, err := routing.NormalizeQueryPolicy(opts.QueryPolicy)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidQueryPolicy, err)
+	}
+	URL := newURL(userID, originalURL)
+	URL.MaxClicks = opts.MaxClicks
+	URL.Rules = rules
+	URL.Variants = variants
+	URL.StickyVariants = opts.StickyVariants && len(variants) > 0
+	URL.UTM = utm
+	URL.QueryPolicy = queryPolicy
+	if opts.Password != "" {
+		hash, err := hashPassword(opts.Password)
+		if err != nil {
+			return "", err
+		}
+		URL.PasswordHash = hash
+	}
+	err = s.writer.SaveURL(ctx, URL)
+	if err != nil {
+		var conflictErr *store.ConflictError
+		if errors.As(err, &conflictErr) {
+			if opts.isSet() {
+				return "", ErrOptionsConflict
+			}
+			return "", &ConflictError{ID: conflictErr.ShortURL}
+		}
+		return "", fmt.Errorf("failed to save url: %w", err)
+	}
+
+	return URL.ShortURL, nil
+}
+
+func normalizeRules(rules []store.Rule) ([]store.Rule, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("%w: more than %d rules", ErrInvalidRules, maxRules)
+	}
+	var normalized []store.Rule
+	for i, rule := range rules {
+		rule, err := routing.NormalizeRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rule %d: %v", ErrInvalidRules, i+1, err)
+		}
+		normalized = append(normalized, rule)
+	}
+
+	return normalized, nil
+}
+
+// normalizeVariants requires at least two variants when there are any.
+func normalizeVariants(variants []store.Variant) ([]store.Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < 2 || len(variants) > maxVariants {
+		return nil, fmt.Errorf("%w: expected from 2 to %d variants", ErrInvalidVariants, maxVariants)
+	}
+	normalized := make([]store.Variant, 0, len(variants))
+	for i, variant := range variants {
+		variant, err := routing.NormalizeVariant(variant)
+		if err != nil {
+			return nil, fmt.Errorf("%w: variant %d: %v", ErrInvalidVariants, i+1, err)
+		}
+		normalized = append(normalized, variant)
+	}
+
+	return normalized, nil
+}
+
+// ShortenBatch saves all URLs or none of them and returns ids in the order of
+// originalURLs.
+func (s *Service) ShortenBatch(ctx context.Context, userID uuid.UUID, originalURLs []string) ([]string, error) {
+	if len(originalURLs) == 0 {
+		return nil, ErrEmptyURL
+	}
+	var URLs []store.URL
+	var ids []string
+	for _, originalURL := range originalURLs {
+		if originalURL == "" {
+			return nil, ErrEmptyURL
+		}
+		URL := newURL(userID, originalURL)
+		URLs = append(URLs, URL)
+		ids = append(ids, URL.ShortURL)
+	}
+	err := s.writer.SaveBatch(ctx, URLs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save urls: %w", err)
+	}
+
+	return ids, nil
+}
+
+// Lookup returns the URL of the short URL unless it is deleted or reached its
+// click limit.
+func (s *Service) Lookup(ctx context.Context, id string) (store.URL, error) {
+	URL, err := s.lookup(ctx, id)
+	if err != nil {
+		return store.URL{}, err
+	}
+	switch {
+	case URL.IsDeleted:
+		return store.URL{}, ErrDeleted
+	case URL.ClicksExhausted():
+		return store.URL{}, ErrClickLimit
+	}
+
+	return URL, nil
+}
+
+// CountClick counts a visit of the URL with a click limit, ErrClickLimit is
+// returned when the limit was reached by other visits since it was looked up.
+// Visits of unlimited URLs are not counted.
+func (s *Service) CountClick(ctx context.Context, URL store.URL) error {
+	if URL.MaxClicks == 0 {
+		return nil
+	}
+	counter, ok := s.writer.(store.ClickCounter)
+	if !ok {
+		return ErrNotSupported
+	}
+	if _, err := counter.CountClick(ctx, URL.ShortURL); err != nil {
+		var deletedErr *store.DeletedURLError
+		switch {
+		case errors.Is(err, store.ErrClickLimit):
+			return ErrClickLimit
+		case errors.As(err, &deletedErr):
+			return ErrDeleted
+		case errors.Is(err, store.ErrNotFound):
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to count click: %w", err)
+	}
+
+	return nil
+}
+
+// CountVariant records that the visit of the URL was served the variant at
+// the index.
+func (s *Service) CountVariant(ctx context.Context, URL store.URL, variant int) error {
+	counter, ok := s.writer.(store.VariantCounter)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := counter.CountVariant(ctx, URL.ShortURL, variant); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to count variant: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) userIDReader() (store.UserIDReader, error) {
+	reader, ok := s.reader.(store.UserIDReader)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return reader, nil
+}
+
+func (s *Service) ListByUser(ctx context.Context, userID uuid.UUID) ([]store.URL, error) {
+	reader, err := s.userIDReader()
+	if err != nil {
+		return nil, err
+	}
+	urls, err := reader.GetURLsByUserID(ctx, userID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get urls by user ID: %w", err)
+	}
+
+	return urls, nil
+}
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// ListPageByUser returns a page of the user's URLs, the limit defaults to
+// DefaultPageLimit and is capped at MaxPageLimit.
+func (s *Service) ListPageByUser(ctx context.Context, userID uuid.UUID, opts store.ListOptions) (store.URLPage, error) {
+	switch {
+	case opts.Limit <= 0:
+		opts.Limit = DefaultPageLimit
+	case opts.Limit > MaxPageLimit:
+		opts.Limit = MaxPageLimit
+	}
+
+	return s.listByUser(ctx, userID, opts)
+}
+
+// ListAllByUser returns all the user's URLs matching the filters of opts on a
+// single page, the limit and the cursor are ignored.
+func (s *Service) ListAllByUser(ctx context.Context, userID uuid.UUID, opts store.ListOptions) (store.URLPage, error) {
+	opts.Limit = 0
+	opts.Cursor = nil
+
+	return s.listByUser(ctx, userID, opts)
+}
+
+func (s *Service) listByUser(ctx context.Context, userID uuid.UUID, opts store.ListOptions) (store.URLPage, error) {
+	lister, ok := s.reader.(store.URLLister)
+	if !ok {
+		return store.URLPage{}, ErrNotSupported
+	}
+	page, err := lister.ListURLsByUserID(ctx, userID.String(), opts)
+	if err != nil {
+		return store.URLPage{}, fmt.Errorf("failed to list urls by user ID: %w", err)
+	}
+
+	return page, nil
+}
+
+// DeleteForUser queues deletion of the ids owned by the user, others are
+// ignored. URLs are deleted asynchronously in batches, the returned job tracks
+// the progress. ErrQueueFull is returned instead of waiting for a free slot.
+func (s *Service) DeleteForUser(ctx context.Context, userID uuid.UUID, ids []string) (DeletionJob, error) {
+	reader, err := s.userIDReader()
+	if err != nil {
+		return DeletionJob{}, err
+	}
+	if _, ok := s.writer.(store.DeleteURLs); !ok {
+		return DeletionJob{}, ErrNotSupported
+	}
+	var shortUrls []store.URL
+	for _, id := range ids {
+		URL := store.URL{
+			ShortURL: id,
+		}
+		shortUrls = append(shortUrls, URL)
+	}
+	filteredURLs, err := reader.FilterURLsByUserID(ctx, userID.String(), shortUrls)
+	if err != nil {
+		return DeletionJob{}, fmt.Errorf("failed to filter urls by user ID: %w", err)
+	}
+	job := newDeletionJob(userID, ids, filteredURLs)
+	if !s.deletions.add(job) {
+		return DeletionJob{}, ErrQueueFull
+	}
+	if len(filteredURLs) > 0 {
+		select {
+		case s.storeChan <- deletionBatch{jobID: job.ID, userID: userID, URLs: filteredURLs}:
+		default:
+			s.deletions.remove(job.ID)
+			return DeletionJob{}, ErrQueueFull
+		}
+	}
+
+	return *job, nil
+}
+
+// RestoreForUser restores the deleted ids owned by the user and returns the
+// restored ones, others are ignored.
+func (s *Service) RestoreForUser(ctx context.Context, userID uuid.UUID, ids []string) ([]string, error) {
+	reader, ok := s.reader.(store.DeletedFilter)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	writer, ok := s.writer.(store.Restorer)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	var shortUrls []store.URL
+	for _, id := range ids {
+		shortUrls = append(shortUrls, store.URL{ShortURL: id})
+	}
+	filteredURLs, err := reader.FilterDeletedURLsByUserID(ctx, userID.String(), shortUrls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter urls by user ID: %w", err)
+	}
+	restored := make([]string, 0, len(filteredURLs))
+	if len(filteredURLs) == 0 {
+		return restored, nil
+	}
+	if err := writer.RestoreURLs(ctx, filteredURLs); err != nil {
+		return nil, fmt.Errorf("failed to restore urls: %w", err)
+	}
+	for _, u := range filteredURLs {
+		restored = append(restored, u.ShortURL)
+	}
+
+	return restored, nil
+}
+
+// PurgeExpired hard deletes URLs deleted longer than retention ago.
+func (s *Service) PurgeExpired(ctx context.Context, retention time.Duration) (int, error) {
+	writer, ok := s.writer.(store.RetentionPurger)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	purged, err := writer.PurgeDeletedBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted urls: %w", err)
+	}
+
+	return purged, nil
+}
+
+// RunRetention purges expired URLs every interval until ctx is done.
+func (s *Service) RunRetention(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeExpired(ctx, retention)
+			if err != nil {
+				s.myLogger.L.Error("failed to purge deleted URLs", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				s.myLogger.L.Info("purged deleted URLs", zap.Int("count", purged))
+			}
+		}
+	}
+}
+
+func (s *Service) Ping(ctx context.Context) error {
+	reader, ok := s.reader.(store.PingableReader)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return reader.Ping(ctx)
+}
+
+func (s *Service) Stats(ctx context.Context) (store.Stats, error) {
+	reader, ok := s.reader.(store.StatsReader)
+	if !ok {
+		return store.Stats{}, ErrNotSupported
+	}
+	stats, err := reader.GetStats(ctx)
+	if err != nil {
+		return store.Stats{}, fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	return stats, nil
+}
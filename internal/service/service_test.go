@@ -0,0 +1,760 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/audit"
+	"github.com/ZhuzhomaAL/go-shortener/internal/logger"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeReader struct {
+	userURLs  []store.URL
+	owned     map[string]bool
+	filterErr error
+}
+
+func (f *fakeReader) GetURL(ctx context.Context, shortURL string) (string, error) {
+	return "", store.ErrNotFound
+}
+
+func (f *fakeReader) GetURLsByUserID(ctx context.Context, userID string) ([]store.URL, error) {
+	return f.userURLs, nil
+}
+
+func (f *fakeReader) FilterURLsByUserID(ctx context.Context, userID string, URLs []store.URL) ([]store.URL, error) {
+	if f.filterErr != nil {
+		return nil, f.filterErr
+	}
+	var res []store.URL
+	for _, u := range URLs {
+		if f.owned[u.ShortURL] {
+			res = append(res, u)
+		}
+	}
+	return res, nil
+}
+
+type fakeWriter struct {
+	saved       []store.URL
+	saveErr     error
+	deleted     []store.URL
+	deleteErr   error
+	deleteCalls int
+}
+
+func (f *fakeWriter) SaveURL(ctx context.Context, URL store.URL) error {
+	if f.saveErr != nil {
+		return f.saveErr
+	}
+	f.saved = append(f.saved, URL)
+	return nil
+}
+
+func (f *fakeWriter) SaveBatch(ctx context.Context, batchURL []store.URL) error {
+	if f.saveErr != nil {
+		return f.saveErr
+	}
+	f.saved = append(f.saved, batchURL...)
+	return nil
+}
+
+func (f *fakeWriter) DeleteURLs(ctx context.Context, URLs []store.URL) error {
+	f.deleteCalls++
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	f.deleted = append(f.deleted, URLs...)
+	return nil
+}
+
+func newTestService(reader store.Reader, writer store.Writer) *Service {
+	return &Service{
+		reader: reader, writer: writer, myLogger: logger.MyLogger{L: zap.NewNop()}, storeChan: make(chan deletionBatch, 1),
+		deletions: newDeletionJobs(), deletionConfig: DeletionConfig{}.withDefaults(),
+		passwordAttempts: newAttemptLimiter(maxPasswordAttempts, passwordAttemptWindow),
+	}
+}
+
+func TestService_Shorten(t *testing.T) {
+	userID := uuid.New()
+	tests := []struct {
+		name      string
+		url       string
+		saveErr   error
+		wantErr   error
+		wantSaved bool
+	}{
+		{name: "success", url: "https://ya.ru", wantSaved: true},
+		{name: "empty_url", url: "", wantErr: ErrEmptyURL},
+		{name: "conflict", url: "https://ya.ru", saveErr: &store.ConflictError{ShortURL: "existing"}},
+		{name: "storage_error", url: "https://ya.ru", saveErr: errors.New("connection refused")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				writer := &fakeWriter{saveErr: tt.saveErr}
+				s := newTestService(&fakeReader{}, writer)
+				id, err := s.Shorten(context.Background(), userID, tt.url)
+
+				var conflictErr *ConflictError
+				switch {
+				case tt.wantSaved:
+					require.NoError(t, err)
+					require.Len(t, writer.saved, 1)
+					saved := writer.saved[0]
+					assert.False(t, saved.CreatedAt.IsZero(), "Не задано время создания")
+					saved.CreatedAt = time.Time{}
+					assert.Equal(t, store.URL{OriginalURL: tt.url, ShortURL: id, UserID: userID}, saved)
+					assert.Len(t, id, 8)
+				case tt.wantErr != nil:
+					assert.ErrorIs(t, err, tt.wantErr)
+				case errors.As(tt.saveErr, new(*store.ConflictError)):
+					require.ErrorAs(t, err, &conflictErr)
+					assert.Equal(t, "existing", conflictErr.ID)
+				default:
+					require.ErrorIs(t, err, tt.saveErr)
+					assert.False(t, errors.As(err, &conflictErr))
+				}
+			},
+		)
+	}
+}
+
+func TestService_ShortenBatch(t *testing.T) {
+	writer := &fakeWriter{}
+	s := newTestService(&fakeReader{}, writer)
+	ctx := context.Background()
+
+	ids, err := s.ShortenBatch(ctx, uuid.New(), []string{"https://ya.ru", "https://practicum.yandex.ru"})
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+	for i, URL := range writer.saved {
+		assert.Equal(t, ids[i], URL.ShortURL)
+	}
+
+	_, err = s.ShortenBatch(ctx, uuid.New(), nil)
+	assert.ErrorIs(t, err, ErrEmptyURL)
+	_, err = s.ShortenBatch(ctx, uuid.New(), []string{"https://ya.ru", ""})
+	assert.ErrorIs(t, err, ErrEmptyURL)
+	assert.Len(t, writer.saved, 2, "Батч с пустым URL сохранён")
+}
+
+func TestService_Lookup(t *testing.T) {
+	var urlList sync.Map
+	for _, u := range []store.URL{
+		{ShortURL: "u9pEX2P5", OriginalURL: "https://www.google.com/"},
+		{ShortURL: "deleted1", OriginalURL: "https://ya.ru", IsDeleted: true},
+		{ShortURL: "limited1", OriginalURL: "https://ya.ru/limited", MaxClicks: 2, Clicks: 2},
+	} {
+		urlList.Store(u.ShortURL, u)
+	}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, &fakeWriter{})
+
+	tests := []struct {
+		name    string
+		id      string
+		want    string
+		wantErr error
+	}{
+		{name: "success", id: "u9pEX2P5", want: "https://www.google.com/"},
+		{name: "not_found", id: "LFGwsFFf", wantErr: ErrNotFound},
+		{name: "deleted", id: "deleted1", wantErr: ErrDeleted},
+		{name: "click_limit", id: "limited1", wantErr: ErrClickLimit},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				URL, err := s.Lookup(context.Background(), tt.id)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, URL.OriginalURL)
+			},
+		)
+	}
+}
+
+func TestService_DeleteForUser(t *testing.T) {
+	reader := &fakeReader{owned: map[string]bool{"own00001": true}}
+	s := newTestService(reader, &fakeWriter{})
+	ctx := context.Background()
+
+	job, err := s.DeleteForUser(ctx, uuid.New(), []string{"own00001", "foreign1"})
+	require.NoError(t, err)
+	assert.Equal(t, DeletionQueued, job.Status)
+	assert.Equal(t, []string{"foreign1"}, job.NotOwned)
+	batch := <-s.storeChan
+	assert.Equal(t, job.ID, batch.jobID)
+	assert.Equal(t, []store.URL{{ShortURL: "own00001"}}, batch.URLs)
+
+	reader.filterErr = errors.New("connection refused")
+	_, err = s.DeleteForUser(ctx, uuid.New(), []string{"own00001"})
+	assert.Error(t, err)
+}
+
+func TestService_DeletionJob(t *testing.T) {
+	reader := &fakeReader{owned: map[string]bool{"own00001": true, "own00002": true}}
+	writer := &fakeWriter{deleteErr: errors.New("connection refused")}
+	s := newTestService(reader, writer)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	job, err := s.DeleteForUser(ctx, userID, []string{"own00001", "own00002"})
+	require.NoError(t, err)
+	batches := []deletionBatch{<-s.storeChan}
+
+	_, err = s.flushDeletions(ctx, batches, false)
+	assert.Error(t, err)
+	job, err = s.GetDeletion(ctx, userID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeletionFailed, job.Status)
+	assert.Equal(t, "connection refused", job.Error)
+
+	writer.deleteErr = nil
+	_, err = s.flushDeletions(ctx, batches, false)
+	require.NoError(t, err)
+	job, err = s.GetDeletion(ctx, userID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeletionApplied, job.Status)
+	assert.Empty(t, job.Error)
+
+	_, err = s.GetDeletion(ctx, uuid.New(), job.ID)
+	assert.ErrorIs(t, err, ErrDeletionNotFound, "Доступен чужой запрос на удаление")
+
+	job, err = s.DeleteForUser(ctx, userID, []string{"foreign1"})
+	require.NoError(t, err)
+	assert.Equal(t, DeletionPartiallyOwned, job.Status, "Запрос без своих URL не завершен")
+}
+
+func TestService_FlushDeletions_MaxBatch(t *testing.T) {
+	reader := &fakeReader{owned: map[string]bool{"own00001": true, "own00002": true, "own00003": true}}
+	writer := &fakeWriter{}
+	s := newTestService(reader, writer)
+	s.deletionConfig = DeletionConfig{MaxBatch: 2}.withDefaults()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	job, err := s.DeleteForUser(ctx, userID, []string{"own00001", "own00002", "own00003"})
+	require.NoError(t, err)
+	_, err = s.flushDeletions(ctx, []deletionBatch{<-s.storeChan}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, writer.deleteCalls, "Задача больше MaxBatch не разделена")
+	assert.Len(t, writer.deleted, 3)
+	job, err = s.GetDeletion(ctx, userID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeletionApplied, job.Status)
+}
+
+func TestService_DeletionWorker_Stop(t *testing.T) {
+	reader := &fakeReader{owned: map[string]bool{"own00001": true}}
+	writer := &fakeWriter{}
+	s := newTestService(reader, writer)
+	s.deletionConfig = DeletionConfig{FlushInterval: time.Hour}.withDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.deletionWorker(ctx)
+		close(stopped)
+	}()
+	userID := uuid.New()
+
+	job, err := s.DeleteForUser(context.Background(), userID, []string{"own00001"})
+	require.NoError(t, err)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Обработчик удалений не остановлен")
+	}
+	job, err = s.GetDeletion(context.Background(), userID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeletionApplied, job.Status, "Удаление из очереди не выполнено при остановке")
+	assert.Equal(t, []store.URL{{ShortURL: "own00001"}}, writer.deleted)
+}
+
+type readOnlyStore struct {
+	store.Reader
+}
+
+func TestService_NotSupported(t *testing.T) {
+	s := newTestService(readOnlyStore{&fakeReader{}}, &fakeWriter{})
+	ctx := context.Background()
+
+	_, err := s.ListByUser(ctx, uuid.New())
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = s.DeleteForUser(ctx, uuid.New(), []string{"own00001"})
+	assert.ErrorIs(t, err, ErrNotSupported)
+	assert.ErrorIs(t, s.Ping(ctx), ErrNotSupported)
+	_, err = s.Stats(ctx)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = s.RestoreForUser(ctx, uuid.New(), []string{"own00001"})
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestService_RestoreForUser(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	writer := &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, writer)
+	ctx := context.Background()
+	owner := uuid.New()
+	own := store.URL{ShortURL: "own00001", OriginalURL: "https://ya.ru", UserID: owner}
+	foreign := store.URL{ShortURL: "foreign1", OriginalURL: "https://google.com", UserID: uuid.New()}
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{own, foreign}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{own, foreign}))
+
+	restored, err := s.RestoreForUser(ctx, owner, []string{own.ShortURL, foreign.ShortURL, "missing1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{own.ShortURL}, restored)
+	_, err = s.Lookup(ctx, own.ShortURL)
+	assert.NoError(t, err)
+	_, err = s.Lookup(ctx, foreign.ShortURL)
+	assert.ErrorIs(t, err, ErrDeleted, "Восстановлен чужой URL")
+
+	purged, err := s.PurgeExpired(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged, "URL удален до истечения срока хранения")
+	purged, err = s.PurgeExpired(ctx, -time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, err = s.Lookup(ctx, foreign.ShortURL)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestService_DeleteForUser_QueueFull(t *testing.T) {
+	reader := &fakeReader{owned: map[string]bool{"own00001": true}}
+	s := newTestService(reader, &fakeWriter{})
+	ctx := context.Background()
+	userID := uuid.New()
+
+	_, err := s.DeleteForUser(ctx, userID, []string{"own00001"})
+	require.NoError(t, err)
+	_, err = s.DeleteForUser(ctx, userID, []string{"own00001"})
+	assert.ErrorIs(t, err, ErrQueueFull, "Запрос не отклонен при заполненной очереди")
+	assert.Len(t, s.deletions.jobs, 1, "Отклоненная задача сохранена")
+}
+
+func TestService_FlushWithRetry_DeadLetter(t *testing.T) {
+	reader := &fakeReader{owned: map[string]bool{"own00001": true}}
+	writer := &fakeWriter{deleteErr: errors.New("connection refused")}
+	s := newTestService(reader, writer)
+	s.deletionConfig = DeletionConfig{MaxRetries: 3, InitialBackoff: time.Millisecond}.withDefaults()
+	var deadLetters bytes.Buffer
+	s.deadLetter = NewDeadLetterLog(&deadLetters)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	job, err := s.DeleteForUser(ctx, userID, []string{"own00001"})
+	require.NoError(t, err)
+	s.flushWithRetry(ctx, nil, []deletionBatch{<-s.storeChan})
+
+	job, err = s.GetDeletion(ctx, userID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeletionDeadLettered, job.Status)
+	var entry deadLetterEntry
+	require.NoError(t, json.Unmarshal(deadLetters.Bytes(), &entry))
+	assert.Equal(t, job.ID, entry.JobID)
+	assert.Equal(t, []string{"own00001"}, entry.ShortURLs)
+	assert.Equal(t, "connection refused", entry.Error)
+}
+
+type countingOwnerDeleter struct {
+	*store.MemoryWriter
+	calls map[string]int
+}
+
+func (c *countingOwnerDeleter) DeleteUserURLs(ctx context.Context, userID string, URLs []store.URL) ([]store.URL, error) {
+	c.calls[userID]++
+	return c.MemoryWriter.DeleteUserURLs(ctx, userID, URLs)
+}
+
+func TestService_FlushDeletions_OwnerScoped(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	writer := &countingOwnerDeleter{
+		MemoryWriter: &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList}, calls: make(map[string]int),
+	}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, writer)
+	s.storeChan = make(chan deletionBatch, 3)
+	ctx := context.Background()
+	first, second := uuid.New(), uuid.New()
+	require.NoError(
+		t, writer.SaveBatch(
+			ctx, []store.URL{
+				{ShortURL: "first001", OriginalURL: "https://first1.ru", UserID: first},
+				{ShortURL: "first002", OriginalURL: "https://first2.ru", UserID: first},
+				{ShortURL: "second01", OriginalURL: "https://second.ru", UserID: second},
+			},
+		),
+	)
+
+	firstJob, err := s.DeleteForUser(ctx, first, []string{"first001"})
+	require.NoError(t, err)
+	transferredJob, err := s.DeleteForUser(ctx, first, []string{"first002"})
+	require.NoError(t, err)
+	_, err = s.DeleteForUser(ctx, second, []string{"second01"})
+	require.NoError(t, err)
+	// first002 changes hands while its deletion is queued.
+	urlList.Store("first002", store.URL{ShortURL: "first002", OriginalURL: "https://first2.ru", UserID: second})
+
+	batches := []deletionBatch{<-s.storeChan, <-s.storeChan, <-s.storeChan}
+	failed, err := s.flushDeletions(ctx, batches, false)
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	assert.Equal(t, map[string]int{first.String(): 1, second.String(): 1}, writer.calls, "Ожидался один вызов на пользователя")
+
+	_, err = s.Lookup(ctx, "first002")
+	assert.NoError(t, err, "Удален URL, сменивший владельца")
+	_, err = s.Lookup(ctx, "first001")
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	job, err := s.GetDeletion(ctx, first, firstJob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeletionApplied, job.Status)
+	job, err = s.GetDeletion(ctx, first, transferredJob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DeletionPartiallyOwned, job.Status)
+	assert.Equal(t, []string{"first002"}, job.NotOwned)
+}
+
+func TestNormalizeMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    store.Metadata
+		want    store.Metadata
+		wantErr bool
+	}{
+		{
+			name: "normalized",
+			meta: store.Metadata{Title: " Заголовок ", Tags: []string{"Go", "news", " go "}, Note: "заметка\n"},
+			want: store.Metadata{Title: "Заголовок", Tags: []string{"go", "news"}, Note: "заметка"},
+		},
+		{name: "empty", meta: store.Metadata{}, want: store.Metadata{Tags: []string{}}},
+		{name: "long_title", meta: store.Metadata{Title: strings.Repeat("я", maxTitleLen+1)}, wantErr: true},
+		{name: "empty_tag", meta: store.Metadata{Tags: []string{" "}}, wantErr: true},
+		{name: "comma_in_tag", meta: store.Metadata{Tags: []string{"a,b"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := normalizeMetadata(tt.meta)
+				if tt.wantErr {
+					assert.ErrorIs(t, err, ErrInvalidMetadata)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestService_Unlock(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	writer := &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, writer)
+	now := time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC)
+	s.passwordAttempts.now = func() time.Time { return now }
+	ctx := context.Background()
+	userID := uuid.New()
+
+	open, err := s.Shorten(ctx, userID, "https://ya.ru")
+	require.NoError(t, err)
+	protected, err := s.ShortenWithOptions(ctx, userID, "https://practicum.yandex.ru", ShortenOptions{Password: "секрет"})
+	require.NoError(t, err)
+	_, err = s.ShortenWithOptions(ctx, userID, "https://google.com", ShortenOptions{Password: strings.Repeat("a", 73)})
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+	_, err = s.ShortenWithOptions(ctx, userID, "https://ya.ru", ShortenOptions{Password: "секрет"})
+	assert.ErrorIs(t, err, ErrOptionsConflict, "Пароль не применён к существующей ссылке молча")
+	_, err = s.ShortenWithOptions(ctx, userID, "https://ya.ru", ShortenOptions{MaxClicks: 1})
+	assert.ErrorIs(t, err, ErrOptionsConflict)
+	_, err = s.ShortenWithOptions(ctx, userID, "https://ya.ru", ShortenOptions{})
+	var conflictErr *ConflictError
+	assert.ErrorAs(t, err, &conflictErr)
+
+	URL, err := s.Unlock(ctx, open, "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", URL.OriginalURL)
+	info, err := s.Lookup(ctx, protected)
+	require.NoError(t, err)
+	assert.NotContains(t, info.PasswordHash, "секрет", "Пароль сохранён в открытом виде")
+	_, err = s.Unlock(ctx, protected, "")
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+	URL, err = s.Unlock(ctx, protected, "секрет")
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru", URL.OriginalURL)
+
+	for i := 0; i < maxPasswordAttempts; i++ {
+		_, err = s.Unlock(ctx, protected, "неверный")
+		assert.ErrorIs(t, err, ErrWrongPassword)
+	}
+	_, err = s.Unlock(ctx, protected, "секрет")
+	var attemptsErr *TooManyAttemptsError
+	require.ErrorAs(t, err, &attemptsErr, "Попытки не ограничены")
+	assert.Equal(t, passwordAttemptWindow, attemptsErr.RetryAfter)
+	_, err = s.Unlock(ctx, open, "")
+	assert.NoError(t, err, "Ограничены попытки другой ссылки")
+
+	now = now.Add(passwordAttemptWindow)
+	_, err = s.Unlock(ctx, protected, "секрет")
+	assert.NoError(t, err, "Попытки не восстановлены после окна")
+}
+
+func TestService_CountClick(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	writer := &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, writer)
+	ctx := context.Background()
+
+	_, err := s.ShortenWithOptions(ctx, uuid.New(), "https://ya.ru", ShortenOptions{MaxClicks: -1})
+	assert.ErrorIs(t, err, ErrInvalidMaxClicks)
+	id, err := s.ShortenWithOptions(ctx, uuid.New(), "https://ya.ru", ShortenOptions{MaxClicks: 2})
+	require.NoError(t, err)
+	URL, err := s.Lookup(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, s.CountClick(ctx, URL))
+	require.NoError(t, s.CountClick(ctx, URL))
+	assert.ErrorIs(t, s.CountClick(ctx, URL), ErrClickLimit, "Превышен лимит переходов")
+	_, err = s.Lookup(ctx, id)
+	assert.ErrorIs(t, err, ErrClickLimit)
+
+	unlimited := store.URL{ShortURL: "unlimit1"}
+	assert.NoError(t, newTestService(&fakeReader{}, &fakeWriter{}).CountClick(ctx, unlimited))
+}
+
+type failingRecorder struct{}
+
+func (failingRecorder) Record(ctx context.Context, entry audit.Entry) error {
+	return errors.New("disk full")
+}
+
+func TestService_AdminDelete_AuditFirst(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	s := newTestService(
+		&store.MemoryReader{URLList: &urlList}, &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList},
+	)
+	s.SetAuditLog(failingRecorder{})
+	ctx := context.Background()
+	urlList.Store("audit001", store.URL{ShortURL: "audit001", OriginalURL: "https://ya.ru"})
+
+	assert.Error(t, s.AdminDelete(ctx, "api-key", "audit001"), "Удаление без записи в журнал")
+	URL, err := s.Lookup(ctx, "audit001")
+	require.NoError(t, err)
+	assert.False(t, URL.IsDeleted, "Ссылка удалена без записи в журнал")
+}
+
+func TestService_ShortenRules(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	writer := &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, writer)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		rules []store.Rule
+	}{
+		{name: "unknown_device", rules: []store.Rule{{Devices: []string{"tv"}, Target: "https://tv.ru"}}},
+		{name: "relative_target", rules: []store.Rule{{Devices: []string{"ios"}, Target: "/app"}}},
+		{name: "long_country", rules: []store.Rule{{Countries: []string{"RUS"}, Target: "https://ya.ru"}}},
+		{name: "comma_in_language", rules: []store.Rule{{Languages: []string{"en,ru"}, Target: "https://ya.ru"}}},
+		{name: "too_many_rules", rules: make([]store.Rule, maxRules+1)},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := s.ShortenWithOptions(ctx, uuid.New(), "https://ya.ru", ShortenOptions{Rules: tt.rules})
+				assert.ErrorIs(t, err, ErrInvalidRules, "Приняты неверные правила")
+			},
+		)
+	}
+
+	id, err := s.ShortenWithOptions(
+		ctx, uuid.New(), "https://ya.ru", ShortenOptions{
+			Rules: []store.Rule{{Devices: []string{" iOS"}, Languages: []string{"EN-us"}, Countries: []string{"ru"},
+				Target: "https://apps.apple.com"}},
+		},
+	)
+	require.NoError(t, err)
+	URL, err := s.Lookup(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(
+		t, []store.Rule{{Devices: []string{"ios"}, Languages: []string{"en-us"}, Countries: []string{"RU"},
+			Target: "https://apps.apple.com"}}, URL.Rules, "Правила не нормализованы",
+	)
+	_, err = s.ShortenWithOptions(
+		ctx, uuid.New(), "https://ya.ru", ShortenOptions{Rules: []store.Rule{{Target: "https://ya.ru/other"}}},
+	)
+	assert.ErrorIs(t, err, ErrOptionsConflict, "Правила существующей ссылки проигнорированы")
+}
+
+func TestService_Variants(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	writer := &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, writer)
+	ctx := context.Background()
+
+	for _, variants := range [][]store.Variant{
+		{{Target: "https://a.ru", Weight: 1}},
+		{{Target: "https://a.ru", Weight: 1}, {Target: "b.ru", Weight: 1}},
+		{{Target: "https://a.ru", Weight: 1}, {Target: "https://b.ru", Weight: -1}},
+		make([]store.Variant, maxVariants+1),
+	} {
+		_, err := s.ShortenWithOptions(ctx, uuid.New(), "https://a.ru", ShortenOptions{Variants: variants})
+		assert.ErrorIs(t, err, ErrInvalidVariants, "Приняты неверные варианты")
+	}
+
+	id, err := s.ShortenWithOptions(
+		ctx, uuid.New(), "https://a.ru", ShortenOptions{
+			Variants:       []store.Variant{{Target: " https://a.ru", Weight: 2}, {Target: "https://b.ru", Weight: 1}},
+			StickyVariants: true,
+		},
+	)
+	require.NoError(t, err)
+	URL, err := s.Lookup(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, s.CountVariant(ctx, URL, 1))
+	URL, err = s.Lookup(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(
+		t, []store.Variant{{Target: "https://a.ru", Weight: 2}, {Target: "https://b.ru", Weight: 1, Served: 1}},
+		URL.Variants, "Показ варианта не посчитан",
+	)
+	assert.True(t, URL.StickyVariants)
+	assert.ErrorIs(t, s.CountVariant(ctx, store.URL{ShortURL: "missing1"}, 0), ErrNotFound)
+	_, err = s.ShortenWithOptions(
+		ctx, uuid.New(), "https://a.ru", ShortenOptions{
+			Variants: []store.Variant{{Target: "https://a.ru", Weight: 1}, {Target: "https://c.ru", Weight: 1}},
+		},
+	)
+	assert.ErrorIs(t, err, ErrOptionsConflict, "Варианты существующей ссылки проигнорированы")
+}
+
+func TestService_ShortenQuery(t *testing.T) {
+	var urlList, fullURLList sync.Map
+	writer := &store.MemoryWriter{URLList: &urlList, FullURLList: &fullURLList}
+	s := newTestService(&store.MemoryReader{URLList: &urlList}, writer)
+	ctx := context.Background()
+
+	_, err := s.ShortenWithOptions(ctx, uuid.New(), "https://ya.ru", ShortenOptions{QueryPolicy: "append"})
+	assert.ErrorIs(t, err, ErrInvalidQueryPolicy)
+	_, err = s.ShortenWithOptions(
+		ctx, uuid.New(), "https://ya.ru", ShortenOptions{UTM: map[string]string{"source": strings.Repeat("a", 256)}},
+	)
+	assert.ErrorIs(t, err, ErrInvalidUTM)
+
+	id, err := s.ShortenWithOptions(
+		ctx, uuid.New(), "https://ya.ru", ShortenOptions{
+			UTM: map[string]string{"utm_source": "news", "Medium": "email"}, QueryPolicy: " Merge",
+		},
+	)
+	require.NoError(t, err)
+	URL, err := s.Lookup(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "utm_medium=email&utm_source=news", URL.UTM, "UTM-параметры не сохранены")
+	assert.Equal(t, "merge", URL.QueryPolicy, "Политика параметров не нормализована")
+	_, err = s.ShortenWithOptions(ctx, uuid.New(), "https://ya.ru", ShortenOptions{UTM: map[string]string{"source": "x"}})
+	assert.ErrorIs(t, err, ErrOptionsConflict, "UTM-параметры существующей ссылки проигнорированы")
+	_, err = s.ShortenWithOptions(ctx, uuid.New(), "https://ya.ru", ShortenOptions{QueryPolicy: "override"})
+	assert.ErrorIs(t, err, ErrOptionsConflict, "Политика существующей ссылки проигнорирована")
+}
+
+type fakeGeoIP map[string]string
+
+func (g fakeGeoIP) Country(ip net.IP) (string, error) {
+	return g[ip.String()], nil
+}
+
+func TestService_Destination(t *testing.T) {
+	s := newTestService(&fakeReader{}, &fakeWriter{})
+	s.SetGeoIP(fakeGeoIP{"10.0.0.1": "RU"})
+	URL := store.URL{
+		ShortURL: "dest0001", OriginalURL: "https://ya.ru", UTM: "utm_source=news", QueryPolicy: "merge",
+		Rules: []store.Rule{
+			{Devices: []string{"android"}, Countries: []string{"RU"}, Target: "https://rustore.ru/app"},
+			{Languages: []string{"de"}, Target: "https://ya.de"},
+		},
+		Variants:       []store.Variant{{Target: "https://a.ru", Weight: 1}, {Target: "https://b.ru", Weight: 1}},
+		StickyVariants: true,
+	}
+	second := 1
+	tests := []struct {
+		name  string
+		visit Visit
+		want  Destination
+	}{
+		{
+			name:  "country_rule",
+			visit: Visit{Device: "android", IP: net.ParseIP("10.0.0.1")},
+			want:  Destination{Location: "https://rustore.ru/app?utm_source=news", Variant: -1},
+		},
+		{
+			name:  "language_rule_query",
+			visit: Visit{Device: "android", Language: "de-at", Query: url.Values{"ref": {"tg"}}},
+			want:  Destination{Location: "https://ya.de?ref=tg&utm_source=news", Variant: -1},
+		},
+		{
+			name:  "sticky_variant",
+			visit: Visit{Device: "desktop", Variant: &second},
+			want:  Destination{Location: "https://b.ru?utm_source=news", Variant: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, s.Destination(URL, tt.visit), "Адрес перехода выбран неверно")
+			},
+		)
+	}
+}
+
+func TestDeletionJobs_Prune(t *testing.T) {
+	userID := uuid.New()
+	dj := newDeletionJobs()
+	dj.max = 10
+
+	expired := newDeletionJob(userID, []string{"foreign1"}, nil)
+	expired.UpdatedAt = time.Now().Add(-deletionJobTTL - time.Minute)
+	require.True(t, dj.add(expired))
+	_, ok := dj.get(expired.ID)
+	assert.False(t, ok, "Устаревшая задача не удалена")
+
+	var finished []*DeletionJob
+	for i := 0; i < dj.max; i++ {
+		job := newDeletionJob(userID, []string{"foreign1"}, nil)
+		require.True(t, dj.add(job))
+		finished = append(finished, job)
+	}
+	queued := newDeletionJob(userID, []string{"own00001"}, []store.URL{{ShortURL: "own00001"}})
+	require.True(t, dj.add(queued))
+	assert.LessOrEqual(t, len(dj.jobs), dj.max, "Превышено число задач")
+	_, ok = dj.get(finished[0].ID)
+	assert.False(t, ok, "Старейшая завершенная задача не удалена")
+	_, ok = dj.get(finished[dj.max-1].ID)
+	assert.True(t, ok, "Удалена новая задача")
+
+	dj = newDeletionJobs()
+	dj.max = 2
+	for i := 0; i < dj.max; i++ {
+		require.True(t, dj.add(newDeletionJob(userID, []string{"own00001"}, []store.URL{{ShortURL: "own00001"}})))
+	}
+	assert.False(
+		t, dj.add(newDeletionJob(userID, []string{"own00001"}, []store.URL{{ShortURL: "own00001"}})),
+		"Добавлена задача сверх лимита незавершенных",
+	)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+)
+
+// AddTags attaches the tags to the user's URL, tags already attached are
+// ignored. The URL keeps at most maxTags tags.
+func (s *Service) AddTags(ctx context.Context, userID uuid.UUID, id string, tags []string) (store.URL, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return store.URL{}, err
+	}
+	if len(tags) == 0 {
+		return store.URL{}, fmt.Errorf("%w: no tags", ErrInvalidMetadata)
+	}
+
+	return s.updateTags(ctx, userID, id, tags, nil)
+}
+
+// RemoveTags detaches the tags from the user's URL, missing tags are ignored.
+func (s *Service) RemoveTags(ctx context.Context, userID uuid.UUID, id string, tags []string) (store.URL, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return store.URL{}, err
+	}
+
+	return s.updateTags(ctx, userID, id, nil, tags)
+}
+
+func (s *Service) updateTags(ctx context.Context, userID uuid.UUID, id string, add, remove []string) (store.URL, error) {
+	writer, ok := s.writer.(store.TagEditor)
+	if !ok {
+		return store.URL{}, ErrNotSupported
+	}
+	URL, err := writer.UpdateTags(ctx, userID.String(), id, add, remove, maxTags)
+	if err != nil {
+		var deletedErr *store.DeletedURLError
+		switch {
+		case errors.Is(err, store.ErrTooManyTags):
+			return store.URL{}, fmt.Errorf("%w: more than %d tags", ErrInvalidMetadata, maxTags)
+		case errors.As(err, &deletedErr):
+			return store.URL{}, ErrDeleted
+		case errors.Is(err, store.ErrNotFound):
+			return store.URL{}, ErrNotFound
+		}
+		return store.URL{}, fmt.Errorf("failed to update tags: %w", err)
+	}
+
+	return URL, nil
+}
+
+// TagCounts returns the tags of the user's URLs with the number of URLs
+// having them, most used first.
+func (s *Service) TagCounts(ctx context.Context, userID uuid.UUID) ([]store.TagCount, error) {
+	reader, ok := s.reader.(store.TagCounter)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	counts, err := reader.GetTagCounts(ctx, userID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tags: %w", err)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,70 @@
+package sqldb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
+)
+
+// Dialect covers what differs between the supported engines. The rest of the
+// SQL of the stores is shared as is since both accept it: UPDATE ... FROM a
+// VALUES CTE and RETURNING (SQLite 3.35 and later), and no ON CONFLICT clauses
+// are used, conflicts are detected by IsUniqueViolation.
+type Dialect interface {
+	Placeholder(n int) string
+	IsUniqueViolation(err error) bool
+	IdentityColumn() string
+	TimestampType() string
+}
+
+type Postgres struct{}
+
+func (Postgres) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (Postgres) IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgerrcode.UniqueViolation
+}
+
+func (Postgres) IdentityColumn() string {
+	return "int PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY"
+}
+
+func (Postgres) TimestampType() string {
+	return "timestamptz"
+}
+
+type SQLite struct{}
+
+func (SQLite) Placeholder(n int) string {
+	return fmt.Sprintf("?%d", n)
+}
+
+func (SQLite) IsUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func (SQLite) IdentityColumn() string {
+	return "INTEGER PRIMARY KEY AUTOINCREMENT"
+}
+
+func (SQLite) TimestampType() string {
+	return "TIMESTAMP"
+}
+
+// Placeholders returns count comma separated placeholders numbered from start,
+// each wrapped with the given format, e.g. "($1),($2)" for format "(%s)".
+func Placeholders(d Dialect, start, count int, format string) string {
+	items := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		items = append(items, fmt.Sprintf(format, d.Placeholder(start+i)))
+	}
+	return strings.Join(items, ",")
+}
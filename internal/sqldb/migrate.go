@@ -0,0 +1,240 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"time"
+)
+
+// migration is a schema version, fill optionally updates existing rows in Go
+// after query when SQL alone can not compute the values.
+type migration struct {
+	query func(d Dialect) string
+	fill  func(ctx context.Context, tx *sql.Tx, d Dialect) error
+}
+
+var migrations = []migration{
+	{
+		query: func(d Dialect) string {
+			return `CREATE TABLE IF NOT EXISTS short_url(id ` + d.IdentityColumn() + `, full_url varchar, 
+short_url varchar, user_id varchar(36), is_deleted bool default false not null)`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `CREATE UNIQUE INDEX IF NOT EXISTS full_url ON short_url(full_url)`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN deleted_at ` + d.TimestampType()
+		},
+	},
+	// URLs deleted before deleted_at existed start their retention now.
+	{
+		query: func(d Dialect) string {
+			return `UPDATE short_url SET deleted_at = CURRENT_TIMESTAMP WHERE is_deleted = true AND deleted_at IS NULL`
+		},
+	},
+	// URLs saved before created_at existed are created now, the time is passed
+	// from Go to be stored in the same format as new ones.
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN created_at ` + d.TimestampType()
+		},
+		fill: func(ctx context.Context, tx *sql.Tx, d Dialect) error {
+			_, err := tx.ExecContext(
+				ctx, `UPDATE short_url SET created_at = `+d.Placeholder(1)+` WHERE created_at IS NULL`,
+				time.Now().UTC(),
+			)
+			return err
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN host varchar`
+		},
+		fill: fillHosts,
+	},
+	{
+		query: func(d Dialect) string {
+			return `CREATE INDEX IF NOT EXISTS user_created ON short_url(user_id, created_at, short_url)`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN updated_at ` + d.TimestampType()
+		},
+		fill: func(ctx context.Context, tx *sql.Tx, d Dialect) error {
+			_, err := tx.ExecContext(ctx, `UPDATE short_url SET updated_at = created_at WHERE updated_at IS NULL`)
+			return err
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN title varchar`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN note varchar`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `CREATE TABLE IF NOT EXISTS url_tag(short_url varchar NOT NULL, tag varchar NOT NULL, 
+PRIMARY KEY(short_url, tag))`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `CREATE TABLE IF NOT EXISTS url_revision(id ` + d.IdentityColumn() + `, short_url varchar NOT NULL, 
+full_url varchar, replaced_at ` + d.TimestampType() + `)`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `CREATE INDEX IF NOT EXISTS url_revision_short_url ON url_revision(short_url)`
+		},
+	},
+	// The primary key of url_tag serves lookups by short_url, this one serves
+	// filtering by tag.
+	{
+		query: func(d Dialect) string {
+			return `CREATE INDEX IF NOT EXISTS url_tag_tag ON url_tag(tag, short_url)`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN always_preview bool default false not null`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN password_hash varchar(60)`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN max_clicks integer default 0 not null`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN clicks integer default 0 not null`
+		},
+	},
+	// Condition values of a rule are joined with commas.
+	{
+		query: func(d Dialect) string {
+			return `CREATE TABLE IF NOT EXISTS url_rule(short_url varchar NOT NULL, position integer NOT NULL, 
+devices varchar, languages varchar, countries varchar, target varchar NOT NULL, PRIMARY KEY(short_url, position))`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `CREATE TABLE IF NOT EXISTS url_variant(short_url varchar NOT NULL, position integer NOT NULL,
+target varchar NOT NULL, weight integer NOT NULL, served integer default 0 NOT NULL, PRIMARY KEY(short_url, position))`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN sticky_variants boolean default false not null`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN utm varchar`
+		},
+	},
+	{
+		query: func(d Dialect) string {
+			return `ALTER TABLE short_url ADD COLUMN query_policy varchar`
+		},
+	},
+}
+
+func fillHosts(ctx context.Context, tx *sql.Tx, d Dialect) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, full_url FROM short_url WHERE host IS NULL`)
+	if err != nil {
+		return err
+	}
+	hosts := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var fullURL sql.NullString
+		if err := rows.Scan(&id, &fullURL); err != nil {
+			rows.Close()
+			return err
+		}
+		hosts[id] = utils.URLHost(fullURL.String)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+	query := `UPDATE short_url SET host = ` + d.Placeholder(1) + ` WHERE id = ` + d.Placeholder(2)
+	for id, host := range hosts {
+		if _, err := tx.ExecContext(ctx, query, host, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations(version int PRIMARY KEY)`)
+	return err
+}
+
+func Migrate(db *sql.DB, d Dialect) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := createMigrationsTable(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	var current int
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		err := applyMigration(ctx, db, d, version, migrations[i])
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, d Dialect, version int, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, m.query(d)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if m.fill != nil {
+		if err := m.fill(ctx, tx, d); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	query := `INSERT INTO schema_migrations(version) VALUES (` + d.Placeholder(1) + `)`
+	if _, err := tx.ExecContext(ctx, query, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
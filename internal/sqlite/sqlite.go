@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqldb"
+	_ "modernc.org/sqlite"
+)
+
+func GetConnection(path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite storage %s: %w", path, err)
+	}
+	// SQLite allows a single writer, serialising access avoids SQLITE_BUSY errors.
+	db.SetMaxOpenConns(1)
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open sqlite storage %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+func InitializeDB(db *sql.DB) error {
+	return sqldb.Migrate(db, sqldb.SQLite{})
+}
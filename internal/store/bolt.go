@@ -0,0 +1,643 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/boltdb"
+	"github.com/google/uuid"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+type boltURL struct {
+	OriginalURL   string         `json:"original_url"`
+	UserID        uuid.UUID      `json:"user_id"`
+	IsDeleted     bool           `json:"is_deleted"`
+	DeletedAt     time.Time      `json:"deleted_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	Title         string         `json:"title,omitempty"`
+	Tags          []string       `json:"tags,omitempty"`
+	Note          string         `json:"note,omitempty"`
+	Revisions     []boltRevision `json:"revisions,omitempty"`
+	AlwaysPreview bool           `json:"always_preview,omitempty"`
+	PasswordHash  string         `json:"password_hash,omitempty"`
+	MaxClicks     int            `json:"max_clicks,omitempty"`
+	Clicks        int            `json:"clicks,omitempty"`
+	Rules         []boltRule     `json:"rules,omitempty"`
+	Variants      []boltVariant  `json:"variants,omitempty"`
+	Sticky        bool           `json:"sticky_variants,omitempty"`
+	UTM           string         `json:"utm,omitempty"`
+	QueryPolicy   string         `json:"query_policy,omitempty"`
+}
+
+type boltRule struct {
+	Devices   []string `json:"devices,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	Target    string   `json:"target"`
+}
+
+type boltVariant struct {
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+	Served int    `json:"served,omitempty"`
+}
+
+type boltRevision struct {
+	OriginalURL string    `json:"original_url"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+func (u *boltURL) toURL(shortURL string) URL {
+	URL := URL{
+		OriginalURL: u.OriginalURL, ShortURL: shortURL, UserID: u.UserID, IsDeleted: u.IsDeleted,
+		DeletedAt: u.DeletedAt, CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt, Title: u.Title, Tags: u.Tags,
+		Note: u.Note, AlwaysPreview: u.AlwaysPreview, PasswordHash: u.PasswordHash, MaxClicks: u.MaxClicks,
+		Clicks: u.Clicks, StickyVariants: u.Sticky, UTM: u.UTM, QueryPolicy: u.QueryPolicy,
+	}
+	for _, r := range u.Rules {
+		URL.Rules = append(
+			URL.Rules, Rule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target},
+		)
+	}
+	for _, v := range u.Variants {
+		URL.Variants = append(URL.Variants, Variant{Target: v.Target, Weight: v.Weight, Served: v.Served})
+	}
+
+	return URL
+}
+
+func getBoltURL(tx *bbolt.Tx, shortURL string) (*boltURL, error) {
+	data := tx.Bucket(boltdb.URLBucket).Get([]byte(shortURL))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var u boltURL
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func putBoltURL(tx *bbolt.Tx, shortURL string, u *boltURL) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(boltdb.URLBucket).Put([]byte(shortURL), data)
+}
+
+type BoltReader struct {
+	DB *bbolt.DB
+}
+
+func (br *BoltReader) GetURL(ctx context.Context, shortURL string) (string, error) {
+	var fullURL string
+	err := br.DB.View(
+		func(tx *bbolt.Tx) error {
+			u, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			if u.IsDeleted {
+				return &DeletedURLError{Err: errors.New(shortURL)}
+			}
+			fullURL = u.OriginalURL
+			return nil
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return fullURL, nil
+}
+
+func (br *BoltReader) GetURLInfo(ctx context.Context, shortURL string) (URL, error) {
+	var URLInfo URL
+	err := br.DB.View(
+		func(tx *bbolt.Tx) error {
+			u, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			URLInfo = u.toURL(shortURL)
+			return nil
+		},
+	)
+
+	return URLInfo, err
+}
+
+func (br *BoltReader) GetRevisions(ctx context.Context, shortURL string) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	err := br.DB.View(
+		func(tx *bbolt.Tx) error {
+			u, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			for _, r := range u.Revisions {
+				revisions = append(revisions, Revision{OriginalURL: r.OriginalURL, ReplacedAt: r.ReplacedAt})
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (br *BoltReader) GetURLsByUserID(ctx context.Context, userID string) ([]URL, error) {
+	urls := make([]URL, 0)
+	err := br.DB.View(
+		func(tx *bbolt.Tx) error {
+			userBucket := tx.Bucket(boltdb.UserBucket).Bucket([]byte(userID))
+			if userBucket == nil {
+				return nil
+			}
+			return userBucket.ForEach(
+				func(k, _ []byte) error {
+					u, err := getBoltURL(tx, string(k))
+					if err != nil {
+						return err
+					}
+					urls = append(urls, u.toURL(string(k)))
+					return nil
+				},
+			)
+		},
+	)
+
+	return urls, err
+}
+
+func (br *BoltReader) ListURLsByUserID(ctx context.Context, userID string, opts ListOptions) (URLPage, error) {
+	urls, err := br.GetURLsByUserID(ctx, userID)
+	if err != nil {
+		return URLPage{}, err
+	}
+
+	return pageURLs(urls, opts), nil
+}
+
+func (br *BoltReader) GetTagCounts(ctx context.Context, userID string) ([]TagCount, error) {
+	urls, err := br.GetURLsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return countTags(urls), nil
+}
+
+func (br *BoltReader) FilterURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	return br.filterURLsByUserID(userID, URLs, false)
+}
+
+func (br *BoltReader) FilterDeletedURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	return br.filterURLsByUserID(userID, URLs, true)
+}
+
+func (br *BoltReader) filterURLsByUserID(userID string, URLs []URL, deleted bool) ([]URL, error) {
+	urls := make([]URL, 0)
+	err := br.DB.View(
+		func(tx *bbolt.Tx) error {
+			userBucket := tx.Bucket(boltdb.UserBucket).Bucket([]byte(userID))
+			if userBucket == nil {
+				return nil
+			}
+			for _, URL := range URLs {
+				if userBucket.Get([]byte(URL.ShortURL)) == nil {
+					continue
+				}
+				u, err := getBoltURL(tx, URL.ShortURL)
+				if err != nil {
+					return err
+				}
+				if u.IsDeleted == deleted {
+					urls = append(urls, URL)
+				}
+			}
+			return nil
+		},
+	)
+
+	return urls, err
+}
+
+func (br *BoltReader) Ping(ctx context.Context) error {
+	return br.DB.View(
+		func(tx *bbolt.Tx) error {
+			return nil
+		},
+	)
+}
+
+func (br *BoltReader) ForEachURL(ctx context.Context, fn func(URL URL) error) error {
+	return br.DB.View(
+		func(tx *bbolt.Tx) error {
+			return tx.Bucket(boltdb.URLBucket).ForEach(
+				func(k, v []byte) error {
+					var u boltURL
+					if err := json.Unmarshal(v, &u); err != nil {
+						return err
+					}
+					return fn(u.toURL(string(k)))
+				},
+			)
+		},
+	)
+}
+
+func (br *BoltReader) GetStats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	users := make(map[uuid.UUID]struct{})
+	err := br.DB.View(
+		func(tx *bbolt.Tx) error {
+			return tx.Bucket(boltdb.URLBucket).ForEach(
+				func(_, v []byte) error {
+					var u boltURL
+					if err := json.Unmarshal(v, &u); err != nil {
+						return err
+					}
+					if !u.IsDeleted {
+						stats.URLs++
+						users[u.UserID] = struct{}{}
+					}
+					return nil
+				},
+			)
+		},
+	)
+	stats.Users = len(users)
+
+	return stats, err
+}
+
+type BoltWriter struct {
+	DB *bbolt.DB
+}
+
+func saveBoltURL(tx *bbolt.Tx, URL URL) error {
+	fullURLs := tx.Bucket(boltdb.FullURLBucket)
+	if short := fullURLs.Get([]byte(URL.OriginalURL)); short != nil {
+		return &ConflictError{ShortURL: string(short), Err: errors.New(URL.OriginalURL)}
+	}
+	if tx.Bucket(boltdb.URLBucket).Get([]byte(URL.ShortURL)) != nil {
+		return errors.New("short url already exists")
+	}
+	URL = withCreatedAt(URL, time.Now().UTC())
+	u := &boltURL{
+		OriginalURL: URL.OriginalURL, UserID: URL.UserID, IsDeleted: URL.IsDeleted, CreatedAt: URL.CreatedAt,
+		UpdatedAt: URL.UpdatedAt, Title: URL.Title, Tags: URL.Tags, Note: URL.Note, AlwaysPreview: URL.AlwaysPreview,
+		PasswordHash: URL.PasswordHash, MaxClicks: URL.MaxClicks, Clicks: URL.Clicks, Sticky: URL.StickyVariants,
+		UTM: URL.UTM, QueryPolicy: URL.QueryPolicy,
+	}
+	if URL.IsDeleted {
+		u.DeletedAt = URL.DeletedAt
+	}
+	for _, r := range URL.Rules {
+		u.Rules = append(
+			u.Rules, boltRule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target},
+		)
+	}
+	for _, v := range URL.Variants {
+		u.Variants = append(u.Variants, boltVariant{Target: v.Target, Weight: v.Weight, Served: v.Served})
+	}
+	err := putBoltURL(tx, URL.ShortURL, u)
+	if err != nil {
+		return err
+	}
+	if err := fullURLs.Put([]byte(URL.OriginalURL), []byte(URL.ShortURL)); err != nil {
+		return err
+	}
+	userBucket, err := tx.Bucket(boltdb.UserBucket).CreateBucketIfNotExists([]byte(URL.UserID.String()))
+	if err != nil {
+		return err
+	}
+
+	return userBucket.Put([]byte(URL.ShortURL), []byte{})
+}
+
+func (bw *BoltWriter) SaveURL(ctx context.Context, URL URL) error {
+	return bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			return saveBoltURL(tx, URL)
+		},
+	)
+}
+
+func (bw *BoltWriter) SaveBatch(ctx context.Context, batchURL []URL) error {
+	return bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			for _, URL := range batchURL {
+				if err := saveBoltURL(tx, URL); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+}
+
+func (bw *BoltWriter) setDeleted(URLs []URL, deleted bool) error {
+	now := time.Now().UTC()
+	return bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			for _, URL := range URLs {
+				u, err := getBoltURL(tx, URL.ShortURL)
+				if err != nil {
+					if errors.Is(err, ErrNotFound) {
+						continue
+					}
+					return err
+				}
+				switch {
+				case !deleted:
+					u.DeletedAt = time.Time{}
+				case !u.IsDeleted || u.DeletedAt.IsZero():
+					u.DeletedAt = now
+				}
+				u.IsDeleted = deleted
+				if err := putBoltURL(tx, URL.ShortURL, u); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+}
+
+func (bw *BoltWriter) DeleteURLs(ctx context.Context, URLs []URL) error {
+	return bw.setDeleted(URLs, true)
+}
+
+func (bw *BoltWriter) DeleteUserURLs(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	now := time.Now().UTC()
+	deleted := make([]URL, 0, len(URLs))
+	err := bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			userBucket := tx.Bucket(boltdb.UserBucket).Bucket([]byte(userID))
+			if userBucket == nil {
+				return nil
+			}
+			for _, URL := range URLs {
+				if userBucket.Get([]byte(URL.ShortURL)) == nil {
+					continue
+				}
+				u, err := getBoltURL(tx, URL.ShortURL)
+				if err != nil {
+					return err
+				}
+				if !u.IsDeleted || u.DeletedAt.IsZero() {
+					u.DeletedAt = now
+				}
+				u.IsDeleted = true
+				if err := putBoltURL(tx, URL.ShortURL, u); err != nil {
+					return err
+				}
+				deleted = append(deleted, URL)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+func (bw *BoltWriter) UpdateMetadata(ctx context.Context, userID, shortURL string, meta Metadata) (URL, error) {
+	return bw.updateOwn(
+		userID, shortURL, func(u *URL) error {
+			u.setMetadata(meta, time.Now().UTC())
+			return nil
+		},
+	)
+}
+
+func (bw *BoltWriter) UpdateTags(
+	ctx context.Context, userID, shortURL string, add, remove []string, maxTags int,
+) (URL, error) {
+	return bw.updateOwn(
+		userID, shortURL, func(u *URL) error {
+			added, _ := u.editTags(add, remove, time.Now().UTC())
+			return checkTags(*u, added, maxTags)
+		},
+	)
+}
+
+// updateOwn applies fn to the metadata of the not deleted URL of the user,
+// the URL is not changed if fn fails.
+func (bw *BoltWriter) updateOwn(userID, shortURL string, fn func(u *URL) error) (URL, error) {
+	var updated URL
+	err := bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			userBucket := tx.Bucket(boltdb.UserBucket).Bucket([]byte(userID))
+			if userBucket == nil || userBucket.Get([]byte(shortURL)) == nil {
+				return ErrNotFound
+			}
+			u, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			if u.IsDeleted {
+				return &DeletedURLError{Err: errors.New(shortURL)}
+			}
+			updated = u.toURL(shortURL)
+			if err := fn(&updated); err != nil {
+				return err
+			}
+			u.Title, u.Tags, u.Note, u.UpdatedAt = updated.Title, updated.Tags, updated.Note, updated.UpdatedAt
+			u.AlwaysPreview = updated.AlwaysPreview
+			return putBoltURL(tx, shortURL, u)
+		},
+	)
+	if err != nil {
+		return URL{}, err
+	}
+
+	return updated, nil
+}
+
+func (bw *BoltWriter) UpdateOriginalURL(ctx context.Context, userID, shortURL, originalURL string) (URL, error) {
+	var updated URL
+	err := bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			userBucket := tx.Bucket(boltdb.UserBucket).Bucket([]byte(userID))
+			if userBucket == nil || userBucket.Get([]byte(shortURL)) == nil {
+				return ErrNotFound
+			}
+			u, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			updated = u.toURL(shortURL)
+			switch {
+			case u.IsDeleted:
+				return &DeletedURLError{Err: errors.New(shortURL)}
+			case u.OriginalURL == originalURL:
+				return nil
+			}
+			fullURLs := tx.Bucket(boltdb.FullURLBucket)
+			if short := fullURLs.Get([]byte(originalURL)); short != nil {
+				return &ConflictError{ShortURL: string(short), Err: errors.New(originalURL)}
+			}
+			if string(fullURLs.Get([]byte(u.OriginalURL))) == shortURL {
+				if err := fullURLs.Delete([]byte(u.OriginalURL)); err != nil {
+					return err
+				}
+			}
+			if err := fullURLs.Put([]byte(originalURL), []byte(shortURL)); err != nil {
+				return err
+			}
+			now := time.Now().UTC()
+			updated.replaceOriginalURL(originalURL, now)
+			u.Revisions = append(u.Revisions, boltRevision{OriginalURL: u.OriginalURL, ReplacedAt: now})
+			u.OriginalURL, u.UpdatedAt = originalURL, now
+			return putBoltURL(tx, shortURL, u)
+		},
+	)
+	if err != nil {
+		return URL{}, err
+	}
+
+	return updated, nil
+}
+
+func (bw *BoltWriter) CountClick(ctx context.Context, shortURL string) (URL, error) {
+	var updated URL
+	err := bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			u, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			updated = u.toURL(shortURL)
+			switch {
+			case u.IsDeleted:
+				return &DeletedURLError{Err: errors.New(shortURL)}
+			case updated.ClicksExhausted():
+				return ErrClickLimit
+			}
+			u.Clicks++
+			updated.Clicks = u.Clicks
+			return putBoltURL(tx, shortURL, u)
+		},
+	)
+	if err != nil {
+		return URL{}, err
+	}
+
+	return updated, nil
+}
+
+func (bw *BoltWriter) CountVariant(ctx context.Context, shortURL string, variant int) error {
+	return bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			u, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			if variant < 0 || variant >= len(u.Variants) {
+				return nil
+			}
+			u.Variants[variant].Served++
+			return putBoltURL(tx, shortURL, u)
+		},
+	)
+}
+
+func (bw *BoltWriter) RestoreURLs(ctx context.Context, URLs []URL) error {
+	return bw.setDeleted(URLs, false)
+}
+
+func purgeBoltURL(tx *bbolt.Tx, shortURL string) error {
+	u, err := getBoltURL(tx, shortURL)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := tx.Bucket(boltdb.URLBucket).Delete([]byte(shortURL)); err != nil {
+		return err
+	}
+	fullURLs := tx.Bucket(boltdb.FullURLBucket)
+	if string(fullURLs.Get([]byte(u.OriginalURL))) == shortURL {
+		if err := fullURLs.Delete([]byte(u.OriginalURL)); err != nil {
+			return err
+		}
+	}
+	if userBucket := tx.Bucket(boltdb.UserBucket).Bucket([]byte(u.UserID.String())); userBucket != nil {
+		return userBucket.Delete([]byte(shortURL))
+	}
+
+	return nil
+}
+
+func (bw *BoltWriter) PurgeURLs(ctx context.Context, URLs []URL) error {
+	return bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			for _, URL := range URLs {
+				if err := purgeBoltURL(tx, URL.ShortURL); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+}
+
+// PurgeDeletedBefore also stamps deleted records stored without a deletion
+// time, their retention starts now.
+func (bw *BoltWriter) PurgeDeletedBefore(ctx context.Context, before time.Time) (int, error) {
+	var purged int
+	err := bw.DB.Update(
+		func(tx *bbolt.Tx) error {
+			var expired []string
+			stamped := make(map[string]*boltURL)
+			err := tx.Bucket(boltdb.URLBucket).ForEach(
+				func(k, v []byte) error {
+					var u boltURL
+					if err := json.Unmarshal(v, &u); err != nil {
+						return err
+					}
+					switch {
+					case !u.IsDeleted:
+					case u.DeletedAt.IsZero():
+						u.DeletedAt = time.Now().UTC()
+						stamped[string(k)] = &u
+					case u.DeletedAt.Before(before):
+						expired = append(expired, string(k))
+					}
+					return nil
+				},
+			)
+			if err != nil {
+				return err
+			}
+			for shortURL, u := range stamped {
+				if err := putBoltURL(tx, shortURL, u); err != nil {
+					return err
+				}
+			}
+			for _, shortURL := range expired {
+				if err := purgeBoltURL(tx, shortURL); err != nil {
+					return err
+				}
+			}
+			purged = len(expired)
+			return nil
+		},
+	)
+
+	return purged, err
+}
@@ -3,24 +3,38 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/jackc/pgerrcode"
-	"github.com/lib/pq"
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqldb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
 	"strings"
+	"time"
 )
 
+func dialectOrDefault(d sqldb.Dialect) sqldb.Dialect {
+	if d == nil {
+		return sqldb.Postgres{}
+	}
+	return d
+}
+
 type DBReader struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect sqldb.Dialect
 }
 
 func (dbr *DBReader) GetURL(ctx context.Context, shortURL string) (string, error) {
+	d := dialectOrDefault(dbr.Dialect)
 	var fullURL string
 	var deleted bool
 	err := dbr.DB.QueryRowContext(
 		ctx,
-		`SELECT full_url, is_deleted FROM short_url WHERE short_url = $1`, shortURL,
+		`SELECT full_url, is_deleted FROM short_url WHERE short_url = `+d.Placeholder(1), shortURL,
 	).Scan(&fullURL, &deleted)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
 		return "", err
 	}
 	if deleted {
@@ -30,31 +44,354 @@ func (dbr *DBReader) GetURL(ctx context.Context, shortURL string) (string, error
 	return fullURL, nil
 }
 
-func (dbr *DBReader) GetURLsByUserID(ctx context.Context, userID string) ([]URL, error) {
-	urls := make([]URL, 0)
+func (dbr *DBReader) GetURLInfo(ctx context.Context, shortURL string) (URL, error) {
+	d := dialectOrDefault(dbr.Dialect)
+	u, err := scanURL(
+		dbr.DB.QueryRowContext(ctx, `SELECT `+urlColumns+` FROM short_url WHERE short_url = `+d.Placeholder(1), shortURL),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, ErrNotFound
+		}
+		return URL{}, err
+	}
+	URLs := []URL{u}
+	if err := loadDetails(ctx, dbr.DB, d, URLs); err != nil {
+		return URL{}, err
+	}
+
+	return URLs[0], nil
+}
+
+func (dbr *DBReader) GetRevisions(ctx context.Context, shortURL string) ([]Revision, error) {
+	d := dialectOrDefault(dbr.Dialect)
+	var count int
+	err := dbr.DB.QueryRowContext(
+		ctx, `SELECT COUNT(*) FROM short_url WHERE short_url = `+d.Placeholder(1), shortURL,
+	).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrNotFound
+	}
 	rows, err := dbr.DB.QueryContext(
-		ctx,
-		`SELECT full_url, short_url FROM short_url s WHERE s.user_id = $1`, userID,
+		ctx, `SELECT full_url, replaced_at FROM url_revision WHERE short_url = `+d.Placeholder(1)+` ORDER BY id`,
+		shortURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]Revision, 0)
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.OriginalURL, &r.ReplacedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
+
+func (dbr *DBReader) GetURLsByUserID(ctx context.Context, userID string) ([]URL, error) {
+	d := dialectOrDefault(dbr.Dialect)
+	urls, err := queryURLs(
+		ctx, dbr.DB, `SELECT `+urlColumns+` FROM short_url s WHERE s.user_id = `+d.Placeholder(1), userID,
 	)
 	if err != nil {
 		return urls, err
 	}
+
+	return urls, loadDetails(ctx, dbr.DB, d, urls)
+}
+
+const urlColumns = `full_url, short_url, user_id, is_deleted, deleted_at, created_at, updated_at, title, note,
+	always_preview, password_hash, max_clicks, clicks, sticky_variants, utm, query_policy`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanURL scans urlColumns, tags, rules and variants are loaded separately by
+// loadDetails.
+func scanURL(row rowScanner) (URL, error) {
+	var u URL
+	var deletedAt, createdAt, updatedAt sql.NullTime
+	var title, note, passwordHash, utm, queryPolicy sql.NullString
+	err := row.Scan(
+		&u.OriginalURL, &u.ShortURL, &u.UserID, &u.IsDeleted, &deletedAt, &createdAt, &updatedAt, &title, &note,
+		&u.AlwaysPreview, &passwordHash, &u.MaxClicks, &u.Clicks, &u.StickyVariants, &utm, &queryPolicy,
+	)
+	u.DeletedAt = deletedAt.Time
+	u.CreatedAt = createdAt.Time
+	u.UpdatedAt = updatedAt.Time
+	u.Title = title.String
+	u.Note = note.String
+	u.PasswordHash = passwordHash.String
+	u.UTM = utm.String
+	u.QueryPolicy = queryPolicy.String
+
+	return u, err
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// queryURLs reads all rows before returning, a single connection SQLite pool
+// is free for the next query then.
+func queryURLs(ctx context.Context, q queryer, query string, params ...any) ([]URL, error) {
+	urls := make([]URL, 0)
+	rows, err := q.QueryContext(ctx, query, params...)
+	if err != nil {
+		return urls, err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var u URL
-		err := rows.Scan(&u.OriginalURL, &u.ShortURL)
+		u, err := scanURL(rows)
 		if err != nil {
 			return urls, err
 		}
 		urls = append(urls, u)
 	}
-	err = rows.Err()
+
+	return urls, rows.Err()
+}
+
+// loadDetails sets the tags, the rules and the variants of the URLs.
+func loadDetails(ctx context.Context, q queryer, d sqldb.Dialect, URLs []URL) error {
+	if err := loadTags(ctx, q, d, URLs); err != nil {
+		return err
+	}
+	if err := loadRules(ctx, q, d, URLs); err != nil {
+		return err
+	}
+
+	return loadVariants(ctx, q, d, URLs)
+}
+
+// loadTags sets the tags of the URLs from url_tag.
+func loadTags(ctx context.Context, q queryer, d sqldb.Dialect, URLs []URL) error {
+	index := make(map[string]int, len(URLs))
+	for i, u := range URLs {
+		index[u.ShortURL] = i
+	}
+	for _, chunk := range split(URLs, 1000) {
+		if len(chunk) == 0 {
+			continue
+		}
+		var params []any
+		for _, u := range chunk {
+			params = append(params, u.ShortURL)
+		}
+		rows, err := q.QueryContext(
+			ctx,
+			`SELECT short_url, tag FROM url_tag WHERE short_url IN(`+sqldb.Placeholders(d, 1, len(chunk), "%s")+
+				`) ORDER BY short_url, tag`,
+			params...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var shortURL, tag string
+			if err := rows.Scan(&shortURL, &tag); err != nil {
+				rows.Close()
+				return err
+			}
+			if i, ok := index[shortURL]; ok {
+				URLs[i].Tags = append(URLs[i].Tags, tag)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadRules sets the rules of the URLs from url_rule.
+func loadRules(ctx context.Context, q queryer, d sqldb.Dialect, URLs []URL) error {
+	index := make(map[string]int, len(URLs))
+	for i, u := range URLs {
+		index[u.ShortURL] = i
+	}
+	for _, chunk := range split(URLs, 1000) {
+		if len(chunk) == 0 {
+			continue
+		}
+		var params []any
+		for _, u := range chunk {
+			params = append(params, u.ShortURL)
+		}
+		rows, err := q.QueryContext(
+			ctx,
+			`SELECT short_url, devices, languages, countries, target FROM url_rule WHERE short_url IN(`+
+				sqldb.Placeholders(d, 1, len(chunk), "%s")+`) ORDER BY short_url, position`,
+			params...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			shortURL, rule, err := scanRule(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if i, ok := index[shortURL]; ok {
+				URLs[i].Rules = append(URLs[i].Rules, rule)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanRule scans a url_rule row, condition values are joined with commas.
+func scanRule(row rowScanner) (string, Rule, error) {
+	var shortURL string
+	var rule Rule
+	var devices, languages, countries sql.NullString
+	if err := row.Scan(&shortURL, &devices, &languages, &countries, &rule.Target); err != nil {
+		return "", Rule{}, err
+	}
+	for _, c := range []struct {
+		value  sql.NullString
+		values *[]string
+	}{{devices, &rule.Devices}, {languages, &rule.Languages}, {countries, &rule.Countries}} {
+		if c.value.String != "" {
+			*c.values = strings.Split(c.value.String, ",")
+		}
+	}
+
+	return shortURL, rule, nil
+}
+
+// loadVariants sets the variants of the URLs from url_variant.
+func loadVariants(ctx context.Context, q queryer, d sqldb.Dialect, URLs []URL) error {
+	index := make(map[string]int, len(URLs))
+	for i, u := range URLs {
+		index[u.ShortURL] = i
+	}
+	for _, chunk := range split(URLs, 1000) {
+		if len(chunk) == 0 {
+			continue
+		}
+		var params []any
+		for _, u := range chunk {
+			params = append(params, u.ShortURL)
+		}
+		rows, err := q.QueryContext(
+			ctx,
+			`SELECT short_url, target, weight, served FROM url_variant WHERE short_url IN(`+
+				sqldb.Placeholders(d, 1, len(chunk), "%s")+`) ORDER BY short_url, position`,
+			params...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var shortURL string
+			var v Variant
+			if err := rows.Scan(&shortURL, &v.Target, &v.Weight, &v.Served); err != nil {
+				rows.Close()
+				return err
+			}
+			if i, ok := index[shortURL]; ok {
+				URLs[i].Variants = append(URLs[i].Variants, v)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (dbr *DBReader) ListURLsByUserID(ctx context.Context, userID string, opts ListOptions) (URLPage, error) {
+	d := dialectOrDefault(dbr.Dialect)
+	params := []interface{}{userID}
+	arg := func(v interface{}) string {
+		params = append(params, v)
+		return d.Placeholder(len(params))
+	}
+	conds := []string{`user_id = ` + d.Placeholder(1)}
+	if !opts.IncludeDeleted {
+		conds = append(conds, `is_deleted = false`)
+	}
+	if !opts.CreatedFrom.IsZero() {
+		conds = append(conds, `created_at >= `+arg(opts.CreatedFrom.UTC()))
+	}
+	if !opts.CreatedTo.IsZero() {
+		conds = append(conds, `created_at < `+arg(opts.CreatedTo.UTC()))
+	}
+	if opts.Domain != "" {
+		domain := strings.ToLower(strings.TrimSuffix(opts.Domain, "."))
+		conds = append(
+			conds, `(host = `+arg(domain)+` OR host LIKE `+arg("%."+escapeLike(domain))+` ESCAPE '\')`,
+		)
+	}
+	if opts.Search != "" {
+		search := arg("%" + escapeLike(strings.ToLower(opts.Search)) + "%")
+		conds = append(
+			conds, `(LOWER(full_url) LIKE `+search+` ESCAPE '\' OR LOWER(short_url) LIKE `+search+` ESCAPE '\')`,
+		)
+	}
+	if opts.Tag != "" {
+		conds = append(conds, `short_url IN(SELECT short_url FROM url_tag WHERE tag = `+arg(opts.Tag)+`)`)
+	}
+
+	var page URLPage
+	where := strings.Join(conds, ` AND `)
+	err := dbr.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM short_url WHERE `+where, params...).Scan(&page.Total)
 	if err != nil {
-		return urls, err
+		return URLPage{}, err
+	}
+	order, cmp := `DESC`, `<`
+	if opts.Ascending {
+		order, cmp = `ASC`, `>`
+	}
+	if opts.Cursor != nil {
+		where += ` AND (created_at, short_url) ` + cmp + ` (` + arg(opts.Cursor.CreatedAt.UTC()) + `, ` +
+			arg(opts.Cursor.ShortURL) + `)`
+	}
+	query := `SELECT ` + urlColumns + ` FROM short_url WHERE ` + where +
+		` ORDER BY created_at ` + order + `, short_url ` + order
+	if opts.Limit > 0 {
+		// One more row tells whether there is a next page.
+		query += ` LIMIT ` + arg(opts.Limit+1)
+	}
+	page.URLs, err = queryURLs(ctx, dbr.DB, query, params...)
+	if err != nil {
+		return URLPage{}, err
+	}
+	if opts.Limit > 0 && len(page.URLs) > opts.Limit {
+		page.URLs = page.URLs[:opts.Limit]
+		next := cursorOf(page.URLs[opts.Limit-1])
+		page.Next = &next
 	}
 
-	return urls, nil
+	return page, loadDetails(ctx, dbr.DB, d, page.URLs)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
 
 func (dbr *DBReader) Ping(ctx context.Context) error {
@@ -62,21 +399,158 @@ func (dbr *DBReader) Ping(ctx context.Context) error {
 	return dbr.DB.Ping()
 }
 
+// ForEachURL loads all tags, rules and variants first, a single connection
+// SQLite pool can not query them while the rows are open.
+func (dbr *DBReader) ForEachURL(ctx context.Context, fn func(URL URL) error) error {
+	tags, err := dbr.allTags(ctx)
+	if err != nil {
+		return err
+	}
+	rules, err := dbr.allRules(ctx)
+	if err != nil {
+		return err
+	}
+	variants, err := dbr.allVariants(ctx)
+	if err != nil {
+		return err
+	}
+	rows, err := dbr.DB.QueryContext(ctx, `SELECT `+urlColumns+` FROM short_url ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			return err
+		}
+		u.Tags = tags[u.ShortURL]
+		u.Rules = rules[u.ShortURL]
+		u.Variants = variants[u.ShortURL]
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (dbr *DBReader) allTags(ctx context.Context) (map[string][]string, error) {
+	rows, err := dbr.DB.QueryContext(ctx, `SELECT short_url, tag FROM url_tag ORDER BY short_url, tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var shortURL, tag string
+		if err := rows.Scan(&shortURL, &tag); err != nil {
+			return nil, err
+		}
+		tags[shortURL] = append(tags[shortURL], tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (dbr *DBReader) allRules(ctx context.Context) (map[string][]Rule, error) {
+	rows, err := dbr.DB.QueryContext(
+		ctx, `SELECT short_url, devices, languages, countries, target FROM url_rule ORDER BY short_url, position`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make(map[string][]Rule)
+	for rows.Next() {
+		shortURL, rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules[shortURL] = append(rules[shortURL], rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (dbr *DBReader) allVariants(ctx context.Context) (map[string][]Variant, error) {
+	rows, err := dbr.DB.QueryContext(
+		ctx, `SELECT short_url, target, weight, served FROM url_variant ORDER BY short_url, position`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make(map[string][]Variant)
+	for rows.Next() {
+		var shortURL string
+		var v Variant
+		if err := rows.Scan(&shortURL, &v.Target, &v.Weight, &v.Served); err != nil {
+			return nil, err
+		}
+		variants[shortURL] = append(variants[shortURL], v)
+	}
+
+	return variants, rows.Err()
+}
+
+func (dbr *DBReader) GetTagCounts(ctx context.Context, userID string) ([]TagCount, error) {
+	d := dialectOrDefault(dbr.Dialect)
+	rows, err := dbr.DB.QueryContext(
+		ctx,
+		`SELECT t.tag, COUNT(*) FROM url_tag t JOIN short_url s ON s.short_url = t.short_url
+	WHERE s.user_id = `+d.Placeholder(1)+` AND s.is_deleted = false GROUP BY t.tag ORDER BY COUNT(*) DESC, t.tag`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]TagCount, 0)
+	for rows.Next() {
+		var c TagCount
+		if err := rows.Scan(&c.Tag, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+func (dbr *DBReader) GetStats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := dbr.DB.QueryRowContext(
+		ctx, `SELECT COUNT(*), COUNT(DISTINCT user_id) FROM short_url WHERE is_deleted = false`,
+	).Scan(&stats.URLs, &stats.Users)
+
+	return stats, err
+}
+
 type DBWriter struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect sqldb.Dialect
 }
 
 func (dbw *DBWriter) SaveURL(ctx context.Context, URL URL) error {
-	query := `INSERT INTO short_url(full_url, short_url, user_id) VALUES ($1, $2, $3)`
-	stmt, err := dbw.DB.PrepareContext(ctx, query)
+	d := dialectOrDefault(dbw.Dialect)
+	URL = withCreatedAt(URL, time.Now().UTC())
+	tx, err := dbw.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
-	_, err = stmt.ExecContext(ctx, URL.OriginalURL, URL.ShortURL, URL.UserID)
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, insertURLsQuery(d, 1), urlParams(URL)...)
 	if err != nil {
-		if err, ok := err.(*pq.Error); ok && err.Code == pgerrcode.UniqueViolation {
-			short, err := getShortURLByFull(ctx, dbw.DB, URL.OriginalURL)
+		if d.IsUniqueViolation(err) {
+			// The connection of a single connection pool is released first.
+			tx.Rollback()
+			short, err := getShortURLByFull(ctx, dbw.DB, d, URL.OriginalURL)
 			if err != nil {
 				return err
 			}
@@ -84,6 +558,130 @@ func (dbw *DBWriter) SaveURL(ctx context.Context, URL URL) error {
 		}
 		return err
 	}
+	if err := insertTags(ctx, tx, d, URL); err != nil {
+		return err
+	}
+	if err := insertRules(ctx, tx, d, URL); err != nil {
+		return err
+	}
+	if err := insertVariants(ctx, tx, d, URL); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const urlInsertColumns = 17
+
+func insertURLsQuery(d sqldb.Dialect, count int) string {
+	inserts := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		inserts = append(inserts, "("+sqldb.Placeholders(d, i*urlInsertColumns+1, urlInsertColumns, "%s")+")")
+	}
+
+	return `INSERT INTO short_url(full_url, short_url, user_id, is_deleted, deleted_at, created_at, updated_at, host,
+	title, note, always_preview, password_hash, max_clicks, clicks, sticky_variants, utm, query_policy) VALUES ` +
+		strings.Join(inserts, ",")
+}
+
+func urlParams(u URL) []interface{} {
+	return []interface{}{
+		u.OriginalURL, u.ShortURL, u.UserID.String(), u.IsDeleted,
+		sql.NullTime{Time: u.DeletedAt.UTC(), Valid: u.IsDeleted && !u.DeletedAt.IsZero()}, u.CreatedAt.UTC(),
+		u.UpdatedAt.UTC(), utils.URLHost(u.OriginalURL), u.Title, u.Note, u.AlwaysPreview, sql.NullString{
+			String: u.PasswordHash, Valid: u.PasswordHash != "",
+		},
+		u.MaxClicks, u.Clicks, u.StickyVariants, sql.NullString{String: u.UTM, Valid: u.UTM != ""},
+		sql.NullString{String: u.QueryPolicy, Valid: u.QueryPolicy != ""},
+	}
+}
+
+// insertTags saves the tags of the URLs to url_tag.
+func insertTags(ctx context.Context, tx *sql.Tx, d sqldb.Dialect, URLs ...URL) error {
+	var params []interface{}
+	for _, u := range URLs {
+		for _, tag := range u.Tags {
+			params = append(params, u.ShortURL, tag)
+		}
+	}
+	for start := 0; start < len(params); start += 2000 {
+		end := start + 2000
+		if end > len(params) {
+			end = len(params)
+		}
+		var inserts []string
+		for i := start; i < end; i += 2 {
+			inserts = append(inserts, "("+sqldb.Placeholders(d, i-start+1, 2, "%s")+")")
+		}
+		query := `INSERT INTO url_tag(short_url, tag) VALUES ` + strings.Join(inserts, ",")
+		if _, err := tx.ExecContext(ctx, query, params[start:end]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const ruleInsertColumns = 6
+
+// insertRules saves the rules of the URLs to url_rule keeping their order.
+func insertRules(ctx context.Context, tx *sql.Tx, d sqldb.Dialect, URLs ...URL) error {
+	var params []interface{}
+	for _, u := range URLs {
+		for i, r := range u.Rules {
+			params = append(
+				params, u.ShortURL, i, strings.Join(r.Devices, ","), strings.Join(r.Languages, ","),
+				strings.Join(r.Countries, ","), r.Target,
+			)
+		}
+	}
+	step := 300 * ruleInsertColumns
+	for start := 0; start < len(params); start += step {
+		end := start + step
+		if end > len(params) {
+			end = len(params)
+		}
+		var inserts []string
+		for i := start; i < end; i += ruleInsertColumns {
+			inserts = append(inserts, "("+sqldb.Placeholders(d, i-start+1, ruleInsertColumns, "%s")+")")
+		}
+		query := `INSERT INTO url_rule(short_url, position, devices, languages, countries, target) VALUES ` +
+			strings.Join(inserts, ",")
+		if _, err := tx.ExecContext(ctx, query, params[start:end]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const variantInsertColumns = 5
+
+// insertVariants saves the variants of the URLs to url_variant keeping their
+// order.
+func insertVariants(ctx context.Context, tx *sql.Tx, d sqldb.Dialect, URLs ...URL) error {
+	var params []interface{}
+	for _, u := range URLs {
+		for i, v := range u.Variants {
+			params = append(params, u.ShortURL, i, v.Target, v.Weight, v.Served)
+		}
+	}
+	step := 300 * variantInsertColumns
+	for start := 0; start < len(params); start += step {
+		end := start + step
+		if end > len(params) {
+			end = len(params)
+		}
+		var inserts []string
+		for i := start; i < end; i += variantInsertColumns {
+			inserts = append(inserts, "("+sqldb.Placeholders(d, i-start+1, variantInsertColumns, "%s")+")")
+		}
+		query := `INSERT INTO url_variant(short_url, position, target, weight, served) VALUES ` +
+			strings.Join(inserts, ",")
+		if _, err := tx.ExecContext(ctx, query, params[start:end]...); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -105,24 +703,34 @@ func split(batchURL []URL, size int) [][]URL {
 }
 
 func (dbw *DBWriter) SaveBatch(ctx context.Context, batchURL []URL) error {
+	if len(batchURL) == 0 {
+		return nil
+	}
+	d := dialectOrDefault(dbw.Dialect)
 	chunks := split(batchURL, 1000)
 	tx, err := dbw.DB.Begin()
 	if err != nil {
 		return err
 	}
+	now := time.Now().UTC()
 	for _, chunk := range chunks {
-		query := "INSERT INTO short_url(full_url, short_url, user_id) VALUES "
-		var inserts []string
 		var params []interface{}
-		var i int
+		stamped := make([]URL, 0, len(chunk))
 		for _, u := range chunk {
-			inserts = append(inserts, fmt.Sprintf("($%d, $%d, $%d)", i+1, i+2, i+3))
-			i = i + 3
-			params = append(params, u.OriginalURL, u.ShortURL, u.UserID.String())
+			u = withCreatedAt(u, now)
+			stamped = append(stamped, u)
+			params = append(params, urlParams(u)...)
+		}
+		_, err := tx.ExecContext(ctx, insertURLsQuery(d, len(chunk)), params...)
+		if err == nil {
+			err = insertTags(ctx, tx, d, stamped...)
+		}
+		if err == nil {
+			err = insertRules(ctx, tx, d, stamped...)
+		}
+		if err == nil {
+			err = insertVariants(ctx, tx, d, stamped...)
 		}
-		queryVals := strings.Join(inserts, ",")
-		query = query + queryVals
-		_, err := tx.ExecContext(ctx, query, params...)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -132,11 +740,11 @@ func (dbw *DBWriter) SaveBatch(ctx context.Context, batchURL []URL) error {
 	return tx.Commit()
 }
 
-func getShortURLByFull(ctx context.Context, db *sql.DB, fullURL string) (string, error) {
+func getShortURLByFull(ctx context.Context, db *sql.DB, d sqldb.Dialect, fullURL string) (string, error) {
 	var shortURL string
 	err := db.QueryRowContext(
 		ctx,
-		`SELECT short_url FROM short_url WHERE full_url = $1`, fullURL,
+		`SELECT short_url FROM short_url WHERE full_url = `+d.Placeholder(1), fullURL,
 	).Scan(&shortURL)
 	if err != nil {
 		return "", err
@@ -146,6 +754,10 @@ func getShortURLByFull(ctx context.Context, db *sql.DB, fullURL string) (string,
 }
 
 func (dbw *DBWriter) DeleteURLs(ctx context.Context, batchURL []URL) error {
+	if len(batchURL) == 0 {
+		return nil
+	}
+	d := dialectOrDefault(dbw.Dialect)
 	chunks := split(batchURL, 1000)
 	tx, err := dbw.DB.Begin()
 	if err != nil {
@@ -156,18 +768,90 @@ func (dbw *DBWriter) DeleteURLs(ctx context.Context, batchURL []URL) error {
         %s
 	)
 	UPDATE short_url AS s
-	SET is_deleted = true
+	SET is_deleted = true, deleted_at = COALESCE(s.deleted_at, %s)
 	FROM _data
 	WHERE s.short_url = _data.short_url`
+	now := time.Now().UTC()
+	for _, chunk := range chunks {
+		params := []interface{}{now}
+		for _, u := range chunk {
+			params = append(params, u.ShortURL)
+		}
+		query := fmt.Sprintf(queryTpl, sqldb.Placeholders(d, 2, len(chunk), "(%s)"), d.Placeholder(1))
+		_, err := tx.ExecContext(ctx, query, params...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (dbw *DBWriter) DeleteUserURLs(ctx context.Context, userID string, batchURL []URL) ([]URL, error) {
+	deleted := make([]URL, 0, len(batchURL))
+	if len(batchURL) == 0 {
+		return deleted, nil
+	}
+	d := dialectOrDefault(dbw.Dialect)
+	chunks := split(batchURL, 1000)
+	tx, err := dbw.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	queryTpl := `UPDATE short_url SET is_deleted = true, deleted_at = COALESCE(deleted_at, %s)
+	WHERE user_id = %s AND short_url IN(%s) RETURNING short_url`
+	now := time.Now().UTC()
+	for _, chunk := range chunks {
+		params := []interface{}{now, userID}
+		for _, u := range chunk {
+			params = append(params, u.ShortURL)
+		}
+		query := fmt.Sprintf(
+			queryTpl, d.Placeholder(1), d.Placeholder(2), sqldb.Placeholders(d, 3, len(chunk), "%s"),
+		)
+		rows, err := tx.QueryContext(ctx, query, params...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var u URL
+			if err := rows.Scan(&u.ShortURL); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			deleted = append(deleted, u)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+func (dbw *DBWriter) RestoreURLs(ctx context.Context, batchURL []URL) error {
+	if len(batchURL) == 0 {
+		return nil
+	}
+	d := dialectOrDefault(dbw.Dialect)
+	chunks := split(batchURL, 1000)
+	tx, err := dbw.DB.Begin()
+	if err != nil {
+		return err
+	}
 	for _, chunk := range chunks {
-		var inserts []string
 		var params []interface{}
-		for i, u := range chunk {
-			inserts = append(inserts, fmt.Sprintf("($%d)", i+1))
+		for _, u := range chunk {
 			params = append(params, u.ShortURL)
 		}
-		queryVals := strings.Join(inserts, ",")
-		query := fmt.Sprintf(queryTpl, queryVals)
+		query := `UPDATE short_url SET is_deleted = false, deleted_at = NULL WHERE short_url IN(` + sqldb.Placeholders(d, 1, len(chunk), "%s") + `)`
 		_, err := tx.ExecContext(ctx, query, params...)
 		if err != nil {
 			tx.Rollback()
@@ -178,18 +862,287 @@ func (dbw *DBWriter) DeleteURLs(ctx context.Context, batchURL []URL) error {
 	return tx.Commit()
 }
 
+func (dbw *DBWriter) PurgeURLs(ctx context.Context, batchURL []URL) error {
+	if len(batchURL) == 0 {
+		return nil
+	}
+	d := dialectOrDefault(dbw.Dialect)
+	chunks := split(batchURL, 1000)
+	tx, err := dbw.DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		var params []interface{}
+		for _, u := range chunk {
+			params = append(params, u.ShortURL)
+		}
+		in := `short_url IN(` + sqldb.Placeholders(d, 1, len(chunk), "%s") + `)`
+		for _, table := range []string{"url_tag", "url_rule", "url_variant", "url_revision", "short_url"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+in, params...); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (dbw *DBWriter) PurgeDeletedBefore(ctx context.Context, before time.Time) (int, error) {
+	d := dialectOrDefault(dbw.Dialect)
+	tx, err := dbw.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	expired := `is_deleted = true AND deleted_at < ` + d.Placeholder(1)
+	for _, table := range []string{"url_tag", "url_rule", "url_variant", "url_revision"} {
+		_, err = tx.ExecContext(
+			ctx, `DELETE FROM `+table+` WHERE short_url IN(SELECT short_url FROM short_url WHERE `+expired+`)`,
+			before.UTC(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM short_url WHERE `+expired, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(purged), tx.Commit()
+}
+
+func (dbw *DBWriter) UpdateOriginalURL(ctx context.Context, userID, shortURL, originalURL string) (URL, error) {
+	d := dialectOrDefault(dbw.Dialect)
+	tx, err := dbw.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return URL{}, err
+	}
+	defer tx.Rollback()
+	u, err := selectOwnURL(ctx, tx, d, userID, shortURL)
+	if err != nil {
+		return URL{}, err
+	}
+	if u.OriginalURL == originalURL {
+		return u, nil
+	}
+	previous := u.OriginalURL
+	now := time.Now().UTC()
+	u.replaceOriginalURL(originalURL, now)
+	query := `UPDATE short_url SET full_url = ` + d.Placeholder(1) + `, host = ` + d.Placeholder(2) +
+		`, updated_at = ` + d.Placeholder(3) + ` WHERE short_url = ` + d.Placeholder(4)
+	_, err = tx.ExecContext(ctx, query, originalURL, utils.URLHost(originalURL), now, shortURL)
+	if err != nil {
+		if d.IsUniqueViolation(err) {
+			// The connection of a single connection pool is released first.
+			tx.Rollback()
+			short, err := getShortURLByFull(ctx, dbw.DB, d, originalURL)
+			if err != nil {
+				return URL{}, err
+			}
+			return URL{}, &ConflictError{ShortURL: short, Err: errors.New(originalURL)}
+		}
+		return URL{}, err
+	}
+	query = `INSERT INTO url_revision(short_url, full_url, replaced_at) VALUES (` + sqldb.Placeholders(d, 1, 3, "%s") + `)`
+	if _, err := tx.ExecContext(ctx, query, shortURL, previous, now); err != nil {
+		return URL{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return URL{}, err
+	}
+
+	return u, nil
+}
+
+// selectOwnURL returns the URL of the user that is not deleted.
+func selectOwnURL(ctx context.Context, tx *sql.Tx, d sqldb.Dialect, userID, shortURL string) (URL, error) {
+	selectURL := `SELECT ` + urlColumns + ` FROM short_url WHERE short_url = ` + d.Placeholder(1)
+	u, err := scanURL(tx.QueryRowContext(ctx, selectURL, shortURL))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return URL{}, ErrNotFound
+	case err != nil:
+		return URL{}, err
+	case u.UserID.String() != userID:
+		return URL{}, ErrNotFound
+	case u.IsDeleted:
+		return URL{}, &DeletedURLError{Err: errors.New(shortURL)}
+	}
+	URLs := []URL{u}
+	if err := loadDetails(ctx, tx, d, URLs); err != nil {
+		return URL{}, err
+	}
+
+	return URLs[0], nil
+}
+
+func (dbw *DBWriter) UpdateMetadata(ctx context.Context, userID, shortURL string, meta Metadata) (URL, error) {
+	d := dialectOrDefault(dbw.Dialect)
+	tx, err := dbw.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return URL{}, err
+	}
+	defer tx.Rollback()
+	u, err := selectOwnURL(ctx, tx, d, userID, shortURL)
+	if err != nil {
+		return URL{}, err
+	}
+	u.setMetadata(meta, time.Now().UTC())
+	query := `UPDATE short_url SET title = ` + d.Placeholder(1) + `, note = ` + d.Placeholder(2) +
+		`, always_preview = ` + d.Placeholder(3) + `, updated_at = ` + d.Placeholder(4) +
+		` WHERE short_url = ` + d.Placeholder(5)
+	if _, err := tx.ExecContext(ctx, query, u.Title, u.Note, u.AlwaysPreview, u.UpdatedAt, shortURL); err != nil {
+		return URL{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM url_tag WHERE short_url = `+d.Placeholder(1), shortURL); err != nil {
+		return URL{}, err
+	}
+	if err := insertTags(ctx, tx, d, u); err != nil {
+		return URL{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return URL{}, err
+	}
+
+	return u, nil
+}
+
+// UpdateTags counts the tags after the update of the URL row, it is locked
+// then and concurrent updates see the tags added meanwhile.
+func (dbw *DBWriter) UpdateTags(
+	ctx context.Context, userID, shortURL string, add, remove []string, maxTags int,
+) (URL, error) {
+	d := dialectOrDefault(dbw.Dialect)
+	tx, err := dbw.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return URL{}, err
+	}
+	defer tx.Rollback()
+	u, err := selectOwnURL(ctx, tx, d, userID, shortURL)
+	if err != nil {
+		return URL{}, err
+	}
+	added, removed := u.editTags(add, remove, time.Now().UTC())
+	if len(added) == 0 && len(removed) == 0 {
+		return u, nil
+	}
+	query := `UPDATE short_url SET updated_at = ` + d.Placeholder(1) + ` WHERE short_url = ` + d.Placeholder(2)
+	if _, err := tx.ExecContext(ctx, query, u.UpdatedAt, shortURL); err != nil {
+		return URL{}, err
+	}
+	if err := insertTags(ctx, tx, d, URL{ShortURL: shortURL, Tags: added}); err != nil {
+		return URL{}, err
+	}
+	if len(removed) > 0 {
+		params := []interface{}{shortURL}
+		for _, tag := range removed {
+			params = append(params, tag)
+		}
+		query := `DELETE FROM url_tag WHERE short_url = ` + d.Placeholder(1) +
+			` AND tag IN(` + sqldb.Placeholders(d, 2, len(removed), "%s") + `)`
+		if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+			return URL{}, err
+		}
+	}
+	URLs := []URL{u}
+	URLs[0].Tags = nil
+	if err := loadTags(ctx, tx, d, URLs); err != nil {
+		return URL{}, err
+	}
+	if err := checkTags(URLs[0], added, maxTags); err != nil {
+		return URL{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return URL{}, err
+	}
+
+	return URLs[0], nil
+}
+
+// CountClick increases the count in a single statement, concurrent clicks can
+// not exceed the limit.
+func (dbw *DBWriter) CountClick(ctx context.Context, shortURL string) (URL, error) {
+	d := dialectOrDefault(dbw.Dialect)
+	query := `UPDATE short_url SET clicks = clicks + 1
+	WHERE short_url = ` + d.Placeholder(1) + ` AND is_deleted = false AND (max_clicks = 0 OR clicks < max_clicks)
+	RETURNING ` + urlColumns
+	u, err := scanURL(dbw.DB.QueryRowContext(ctx, query, shortURL))
+	if errors.Is(err, sql.ErrNoRows) {
+		u, err = (&DBReader{DB: dbw.DB, Dialect: dbw.Dialect}).GetURLInfo(ctx, shortURL)
+		switch {
+		case err != nil:
+			return URL{}, err
+		case u.IsDeleted:
+			return URL{}, &DeletedURLError{Err: errors.New(shortURL)}
+		}
+		return URL{}, ErrClickLimit
+	}
+	if err != nil {
+		return URL{}, err
+	}
+	URLs := []URL{u}
+	if err := loadDetails(ctx, dbw.DB, d, URLs); err != nil {
+		return URL{}, err
+	}
+
+	return URLs[0], nil
+}
+
+// CountVariant ignores variants removed meanwhile, ErrNotFound is returned
+// only if there is no such short URL.
+func (dbw *DBWriter) CountVariant(ctx context.Context, shortURL string, variant int) error {
+	d := dialectOrDefault(dbw.Dialect)
+	res, err := dbw.DB.ExecContext(
+		ctx,
+		`UPDATE url_variant SET served = served + 1 WHERE short_url = `+d.Placeholder(1)+` AND position = `+
+			d.Placeholder(2),
+		shortURL, variant,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		_, err = (&DBReader{DB: dbw.DB, Dialect: dbw.Dialect}).GetURLInfo(ctx, shortURL)
+	}
+
+	return err
+}
+
 func (dbr *DBReader) FilterURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
-	queryTpl := `SELECT short_url FROM short_url s WHERE s.user_id = $1 AND s.short_url IN(%s) AND s.is_deleted = false`
-	var inserts []string
+	return dbr.filterURLsByUserID(ctx, userID, URLs, false)
+}
+
+func (dbr *DBReader) FilterDeletedURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	return dbr.filterURLsByUserID(ctx, userID, URLs, true)
+}
+
+func (dbr *DBReader) filterURLsByUserID(ctx context.Context, userID string, URLs []URL, deleted bool) ([]URL, error) {
+	d := dialectOrDefault(dbr.Dialect)
+	urls := make([]URL, 0)
+	if len(URLs) == 0 {
+		return urls, nil
+	}
+	queryTpl := `SELECT short_url FROM short_url s WHERE s.user_id = %s AND s.short_url IN(%s) AND s.is_deleted = %s`
 	var params []interface{}
 	params = append(params, userID)
-	for i, u := range URLs {
-		inserts = append(inserts, fmt.Sprintf("$%d", i+2))
+	for _, u := range URLs {
 		params = append(params, u.ShortURL)
 	}
-	queryVals := strings.Join(inserts, ",")
-	query := fmt.Sprintf(queryTpl, queryVals)
-	urls := make([]URL, 0)
+	params = append(params, deleted)
+	query := fmt.Sprintf(
+		queryTpl, d.Placeholder(1), sqldb.Placeholders(d, 2, len(URLs), "%s"), d.Placeholder(len(URLs)+2),
+	)
 	rows, err := dbr.DB.QueryContext(ctx, query, params...)
 	if err != nil {
 		return urls, err
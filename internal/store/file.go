@@ -2,8 +2,11 @@ package store
 
 import (
 	"context"
+	"fmt"
 	"github.com/ZhuzhomaAL/go-shortener/internal/file"
 	"github.com/google/uuid"
+	"io"
+	"time"
 )
 
 type FileReader struct {
@@ -14,29 +17,189 @@ func (fr *FileReader) GetURL(ctx context.Context, shortURL string) (string, erro
 	return fr.MemoryReader.GetURL(ctx, shortURL)
 }
 
+func (fr *FileReader) GetURLInfo(ctx context.Context, shortURL string) (URL, error) {
+	return fr.MemoryReader.GetURLInfo(ctx, shortURL)
+}
+
+func (fr *FileReader) GetRevisions(ctx context.Context, shortURL string) ([]Revision, error) {
+	return fr.MemoryReader.GetRevisions(ctx, shortURL)
+}
+
+func (fr *FileReader) GetURLsByUserID(ctx context.Context, userID string) ([]URL, error) {
+	return fr.MemoryReader.GetURLsByUserID(ctx, userID)
+}
+
+func (fr *FileReader) ListURLsByUserID(ctx context.Context, userID string, opts ListOptions) (URLPage, error) {
+	return fr.MemoryReader.ListURLsByUserID(ctx, userID, opts)
+}
+
+func (fr *FileReader) GetTagCounts(ctx context.Context, userID string) ([]TagCount, error) {
+	return fr.MemoryReader.GetTagCounts(ctx, userID)
+}
+
+func (fr *FileReader) FilterURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	return fr.MemoryReader.FilterURLsByUserID(ctx, userID, URLs)
+}
+
+func (fr *FileReader) FilterDeletedURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	return fr.MemoryReader.FilterDeletedURLsByUserID(ctx, userID, URLs)
+}
+
+func (fr *FileReader) ForEachURL(ctx context.Context, fn func(URL URL) error) error {
+	return fr.MemoryReader.ForEachURL(ctx, fn)
+}
+
+func (fr *FileReader) GetStats(ctx context.Context) (Stats, error) {
+	return fr.MemoryReader.GetStats(ctx)
+}
+
 type FileWriter struct {
 	MemoryWriter *MemoryWriter
 	Writer       *file.Writer
 }
 
 func (fw *FileWriter) SaveURL(ctx context.Context, URL URL) error {
+	URL = withCreatedAt(URL, time.Now().UTC())
 	err := fw.MemoryWriter.SaveURL(ctx, URL)
 	if err != nil {
 		return err
 	}
-	id := uuid.New()
-	fileURL := &file.URL{
-		ID:          id,
-		ShortURL:    URL.ShortURL,
-		OriginalURL: URL.OriginalURL,
-	}
 
-	return fw.Writer.WriteFile(fileURL)
+	return fw.writeFile(URL)
 }
 
 func (fw *FileWriter) SaveBatch(ctx context.Context, batchURL []URL) error {
+	now := time.Now().UTC()
+	stamped := make([]URL, 0, len(batchURL))
+	for _, URL := range batchURL {
+		stamped = append(stamped, withCreatedAt(URL, now))
+	}
+	batchURL = stamped
+	err := fw.MemoryWriter.SaveBatch(ctx, batchURL)
+	if err != nil {
+		return err
+	}
 	for _, item := range batchURL {
-		err := fw.SaveURL(ctx, item)
+		err := fw.writeFile(item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fw *FileWriter) DeleteURLs(ctx context.Context, URLs []URL) error {
+	err := fw.MemoryWriter.DeleteURLs(ctx, URLs)
+	if err != nil {
+		return err
+	}
+
+	return fw.writeCurrent(URLs)
+}
+
+func (fw *FileWriter) DeleteUserURLs(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	deleted, err := fw.MemoryWriter.DeleteUserURLs(ctx, userID, URLs)
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, fw.writeCurrent(deleted)
+}
+
+// writeCurrent appends the current in-memory state of the URLs to the file.
+func (fw *FileWriter) writeCurrent(URLs []URL) error {
+	for _, u := range URLs {
+		value, ok := fw.MemoryWriter.URLList.Load(u.ShortURL)
+		if !ok {
+			continue
+		}
+		err := fw.writeFile(value.(URL))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fw *FileWriter) UpdateMetadata(ctx context.Context, userID, shortURL string, meta Metadata) (URL, error) {
+	updated, err := fw.MemoryWriter.UpdateMetadata(ctx, userID, shortURL, meta)
+	if err != nil {
+		return URL{}, err
+	}
+
+	return updated, fw.writeFile(updated)
+}
+
+func (fw *FileWriter) UpdateTags(
+	ctx context.Context, userID, shortURL string, add, remove []string, maxTags int,
+) (URL, error) {
+	updated, err := fw.MemoryWriter.UpdateTags(ctx, userID, shortURL, add, remove, maxTags)
+	if err != nil {
+		return URL{}, err
+	}
+
+	return updated, fw.writeFile(updated)
+}
+
+func (fw *FileWriter) UpdateOriginalURL(ctx context.Context, userID, shortURL, originalURL string) (URL, error) {
+	updated, err := fw.MemoryWriter.UpdateOriginalURL(ctx, userID, shortURL, originalURL)
+	if err != nil {
+		return URL{}, err
+	}
+
+	return updated, fw.writeFile(updated)
+}
+
+// CountClick logs every counted click, LoadFile keeps the highest count of a
+// URL as records of concurrent updates may be written out of order.
+func (fw *FileWriter) CountClick(ctx context.Context, shortURL string) (URL, error) {
+	updated, err := fw.MemoryWriter.CountClick(ctx, shortURL)
+	if err != nil {
+		return URL{}, err
+	}
+
+	return updated, fw.writeFile(updated)
+}
+
+// CountVariant logs the counted visit as CountClick does.
+func (fw *FileWriter) CountVariant(ctx context.Context, shortURL string, variant int) error {
+	updated, err := fw.MemoryWriter.countVariant(shortURL, variant)
+	if err != nil {
+		return err
+	}
+
+	return fw.writeFile(updated)
+}
+
+func (fw *FileWriter) RestoreURLs(ctx context.Context, URLs []URL) error {
+	err := fw.MemoryWriter.RestoreURLs(ctx, URLs)
+	if err != nil {
+		return err
+	}
+
+	return fw.writeCurrent(URLs)
+}
+
+func (fw *FileWriter) PurgeURLs(ctx context.Context, URLs []URL) error {
+	err := fw.MemoryWriter.PurgeURLs(ctx, URLs)
+	if err != nil {
+		return err
+	}
+
+	return fw.writePurged(URLs)
+}
+
+func (fw *FileWriter) PurgeDeletedBefore(ctx context.Context, before time.Time) (int, error) {
+	purged := fw.MemoryWriter.purgeDeletedBefore(before)
+
+	return len(purged), fw.writePurged(purged)
+}
+
+func (fw *FileWriter) writePurged(URLs []URL) error {
+	for _, u := range URLs {
+		err := fw.Writer.WriteFile(&file.URL{ID: uuid.New(), ShortURL: u.ShortURL, IsPurged: true})
 		if err != nil {
 			return err
 		}
@@ -44,3 +207,129 @@ func (fw *FileWriter) SaveBatch(ctx context.Context, batchURL []URL) error {
 
 	return nil
 }
+
+func (fw *FileWriter) writeFile(URL URL) error {
+	fileURL := &file.URL{
+		ID:            uuid.New(),
+		ShortURL:      URL.ShortURL,
+		OriginalURL:   URL.OriginalURL,
+		UserID:        URL.UserID,
+		IsDeleted:     URL.IsDeleted,
+		Title:         URL.Title,
+		Tags:          URL.Tags,
+		Note:          URL.Note,
+		AlwaysPreview: URL.AlwaysPreview,
+		PasswordHash:  URL.PasswordHash,
+		MaxClicks:     URL.MaxClicks,
+		Clicks:        URL.Clicks,
+		Sticky:        URL.StickyVariants,
+		UTM:           URL.UTM,
+		QueryPolicy:   URL.QueryPolicy,
+	}
+	if !URL.DeletedAt.IsZero() {
+		fileURL.DeletedAt = &URL.DeletedAt
+	}
+	if !URL.CreatedAt.IsZero() {
+		fileURL.CreatedAt = &URL.CreatedAt
+	}
+	if !URL.UpdatedAt.IsZero() {
+		fileURL.UpdatedAt = &URL.UpdatedAt
+	}
+	for _, r := range URL.Rules {
+		fileURL.Rules = append(
+			fileURL.Rules, file.Rule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target},
+		)
+	}
+	for _, v := range URL.Variants {
+		fileURL.Variants = append(fileURL.Variants, file.Variant{Target: v.Target, Weight: v.Weight, Served: v.Served})
+	}
+	for _, r := range URL.revisions {
+		fileURL.Revisions = append(fileURL.Revisions, file.Revision{OriginalURL: r.OriginalURL, ReplacedAt: r.ReplacedAt})
+	}
+
+	return fw.Writer.WriteFile(fileURL)
+}
+
+// LoadFile replays the storage file into memory, later records of the same
+// short URL override earlier ones. Deleted records written without a deletion
+// time start their retention from the load, records written without a creation
+// time are created at the load. Click and variant counts only grow, the
+// highest ones written are kept.
+func LoadFile(fReader *file.Reader, memoryWriter *MemoryWriter) error {
+	loadedAt := time.Now().UTC()
+	for {
+		fileURL, err := fReader.ReadFile()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read the storage file: %w", err)
+		}
+		if fileURL.IsPurged {
+			memoryWriter.PurgeURLs(context.Background(), []URL{{ShortURL: fileURL.ShortURL}})
+			continue
+		}
+		URL := URL{
+			OriginalURL:    fileURL.OriginalURL,
+			ShortURL:       fileURL.ShortURL,
+			UserID:         fileURL.UserID,
+			IsDeleted:      fileURL.IsDeleted,
+			CreatedAt:      loadedAt,
+			Title:          fileURL.Title,
+			Tags:           fileURL.Tags,
+			Note:           fileURL.Note,
+			AlwaysPreview:  fileURL.AlwaysPreview,
+			PasswordHash:   fileURL.PasswordHash,
+			MaxClicks:      fileURL.MaxClicks,
+			Clicks:         fileURL.Clicks,
+			StickyVariants: fileURL.Sticky,
+			UTM:            fileURL.UTM,
+			QueryPolicy:    fileURL.QueryPolicy,
+		}
+		if fileURL.CreatedAt != nil {
+			URL.CreatedAt = *fileURL.CreatedAt
+		}
+		URL.UpdatedAt = URL.CreatedAt
+		if fileURL.UpdatedAt != nil {
+			URL.UpdatedAt = *fileURL.UpdatedAt
+		}
+		for _, r := range fileURL.Rules {
+			URL.Rules = append(
+				URL.Rules, Rule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target},
+			)
+		}
+		for _, v := range fileURL.Variants {
+			URL.Variants = append(URL.Variants, Variant{Target: v.Target, Weight: v.Weight, Served: v.Served})
+		}
+		for _, r := range fileURL.Revisions {
+			URL.revisions = append(URL.revisions, Revision{OriginalURL: r.OriginalURL, ReplacedAt: r.ReplacedAt})
+		}
+		if URL.IsDeleted {
+			URL.DeletedAt = loadedAt
+			if fileURL.DeletedAt != nil {
+				URL.DeletedAt = *fileURL.DeletedAt
+			}
+		}
+		memoryWriter.Restore(withStoredCounts(memoryWriter, URL))
+	}
+}
+
+// withStoredCounts raises the counts of the URL to the ones already loaded.
+func withStoredCounts(memoryWriter *MemoryWriter, u URL) URL {
+	value, ok := memoryWriter.URLList.Load(u.ShortURL)
+	if !ok {
+		return u
+	}
+	stored := value.(URL)
+	if stored.Clicks > u.Clicks {
+		u.Clicks = stored.Clicks
+	}
+	for i := range u.Variants {
+		if i < len(stored.Variants) && stored.Variants[i].Target == u.Variants[i].Target &&
+			stored.Variants[i].Served > u.Variants[i].Served {
+			u.Variants[i].Served = stored.Variants[i].Served
+		}
+	}
+
+	return u
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/utils"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions filter and paginate the URLs of a user ordered by creation time
+// and short URL, newest first unless Ascending is set. Zero values disable the
+// filters and Limit.
+type ListOptions struct {
+	Limit          int
+	Cursor         *Cursor
+	Ascending      bool
+	Domain         string
+	IncludeDeleted bool
+	CreatedFrom    time.Time
+	CreatedTo      time.Time
+	Search         string
+	Tag            string
+}
+
+// Cursor is the position of the last URL of a page, the next page starts
+// right after it.
+type Cursor struct {
+	CreatedAt time.Time
+	ShortURL  string
+}
+
+type cursorJSON struct {
+	CreatedAt time.Time `json:"t"`
+	ShortURL  string    `json:"s"`
+}
+
+// Encode returns the opaque form of the cursor passed to clients.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(cursorJSON{CreatedAt: c.CreatedAt.UTC(), ShortURL: c.ShortURL})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c cursorJSON
+	if err := json.Unmarshal(data, &c); err != nil || c.ShortURL == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return Cursor{CreatedAt: c.CreatedAt, ShortURL: c.ShortURL}, nil
+}
+
+type URLPage struct {
+	URLs []URL
+	// Total counts the URLs matching the filters on all pages.
+	Total int
+	// Next is nil on the last page.
+	Next *Cursor
+}
+
+func (opts ListOptions) match(u URL) bool {
+	switch {
+	case u.IsDeleted && !opts.IncludeDeleted:
+		return false
+	case !opts.CreatedFrom.IsZero() && u.CreatedAt.Before(opts.CreatedFrom):
+		return false
+	case !opts.CreatedTo.IsZero() && !u.CreatedAt.Before(opts.CreatedTo):
+		return false
+	case opts.Domain != "" && !utils.MatchDomain(utils.URLHost(u.OriginalURL), opts.Domain):
+		return false
+	case opts.Tag != "" && !u.hasTag(opts.Tag):
+		return false
+	case opts.Search != "":
+		search := strings.ToLower(opts.Search)
+		return strings.Contains(strings.ToLower(u.OriginalURL), search) ||
+			strings.Contains(strings.ToLower(u.ShortURL), search)
+	}
+
+	return true
+}
+
+// less reports whether a goes before b in the listing order.
+func (opts ListOptions) less(a, b Cursor) bool {
+	before := a.CreatedAt.Before(b.CreatedAt) || a.CreatedAt.Equal(b.CreatedAt) && a.ShortURL < b.ShortURL
+	after := a.CreatedAt.After(b.CreatedAt) || a.CreatedAt.Equal(b.CreatedAt) && a.ShortURL > b.ShortURL
+	if opts.Ascending {
+		return before
+	}
+	return after
+}
+
+func cursorOf(u URL) Cursor {
+	return Cursor{CreatedAt: u.CreatedAt, ShortURL: u.ShortURL}
+}
+
+// pageURLs pages the user's URLs of stores without an index.
+func pageURLs(URLs []URL, opts ListOptions) URLPage {
+	page := URLPage{URLs: make([]URL, 0)}
+	var matched []URL
+	for _, u := range URLs {
+		if opts.match(u) {
+			matched = append(matched, u)
+		}
+	}
+	page.Total = len(matched)
+	sort.Slice(
+		matched, func(i, j int) bool {
+			return opts.less(cursorOf(matched[i]), cursorOf(matched[j]))
+		},
+	)
+	for _, u := range matched {
+		if opts.Cursor != nil && !opts.less(*opts.Cursor, cursorOf(u)) {
+			continue
+		}
+		if opts.Limit > 0 && len(page.URLs) == opts.Limit {
+			next := cursorOf(page.URLs[len(page.URLs)-1])
+			page.Next = &next
+			break
+		}
+		page.URLs = append(page.URLs, u)
+	}
+
+	return page
+}
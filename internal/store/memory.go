@@ -3,35 +3,434 @@ package store
 import (
 	"context"
 	"errors"
-	"fmt"
 	"sync"
+	"time"
 )
 
 type MemoryReader struct {
 	URLList *sync.Map
+	// Tags is optional, URLs are scanned to filter and count tags without it.
+	Tags *TagIndex
 }
 
 func (mr *MemoryReader) GetURL(ctx context.Context, shortURL string) (string, error) {
-	fullURL, ok := mr.URLList.Load(shortURL)
+	value, ok := mr.URLList.Load(shortURL)
 	if !ok {
-		return "", errors.New("failed to load fullURL from memory")
+		return "", ErrNotFound
 	}
-	return fmt.Sprintf("%v", fullURL), nil
+	URL := value.(URL)
+	if URL.IsDeleted {
+		return "", &DeletedURLError{Err: errors.New(shortURL)}
+	}
+	return URL.OriginalURL, nil
+}
+
+func (mr *MemoryReader) GetURLInfo(ctx context.Context, shortURL string) (URL, error) {
+	value, ok := mr.URLList.Load(shortURL)
+	if !ok {
+		return URL{}, ErrNotFound
+	}
+
+	return value.(URL), nil
+}
+
+func (mr *MemoryReader) GetRevisions(ctx context.Context, shortURL string) ([]Revision, error) {
+	value, ok := mr.URLList.Load(shortURL)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]Revision{}, value.(URL).revisions...), nil
+}
+
+func (mr *MemoryReader) GetURLsByUserID(ctx context.Context, userID string) ([]URL, error) {
+	urls := make([]URL, 0)
+	mr.URLList.Range(
+		func(_, value any) bool {
+			URL := value.(URL)
+			if URL.UserID.String() == userID {
+				urls = append(urls, URL)
+			}
+			return true
+		},
+	)
+
+	return urls, nil
+}
+
+func (mr *MemoryReader) ListURLsByUserID(ctx context.Context, userID string, opts ListOptions) (URLPage, error) {
+	if opts.Tag != "" && mr.Tags != nil {
+		return pageURLs(mr.loadURLs(mr.Tags.shortURLs(userID, opts.Tag)), opts), nil
+	}
+	urls, err := mr.GetURLsByUserID(ctx, userID)
+	if err != nil {
+		return URLPage{}, err
+	}
+
+	return pageURLs(urls, opts), nil
+}
+
+func (mr *MemoryReader) GetTagCounts(ctx context.Context, userID string) ([]TagCount, error) {
+	if mr.Tags == nil {
+		urls, err := mr.GetURLsByUserID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return countTags(urls), nil
+	}
+	counts := make(map[string]int)
+	for tag, shortURLs := range mr.Tags.userTags(userID) {
+		for _, u := range mr.loadURLs(shortURLs) {
+			if !u.IsDeleted {
+				counts[tag]++
+			}
+		}
+	}
+
+	return sortTagCounts(counts), nil
+}
+
+// loadURLs returns the stored URLs of the short URLs, missing ones are skipped.
+func (mr *MemoryReader) loadURLs(shortURLs []string) []URL {
+	urls := make([]URL, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		if value, ok := mr.URLList.Load(shortURL); ok {
+			urls = append(urls, value.(URL))
+		}
+	}
+
+	return urls
+}
+
+func (mr *MemoryReader) FilterURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	return mr.filterURLsByUserID(userID, URLs, false), nil
+}
+
+func (mr *MemoryReader) FilterDeletedURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	return mr.filterURLsByUserID(userID, URLs, true), nil
+}
+
+func (mr *MemoryReader) filterURLsByUserID(userID string, URLs []URL, deleted bool) []URL {
+	urls := make([]URL, 0)
+	for _, u := range URLs {
+		value, ok := mr.URLList.Load(u.ShortURL)
+		if !ok {
+			continue
+		}
+		URL := value.(URL)
+		if URL.UserID.String() == userID && URL.IsDeleted == deleted {
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+func (mr *MemoryReader) ForEachURL(ctx context.Context, fn func(URL URL) error) error {
+	var err error
+	mr.URLList.Range(
+		func(_, value any) bool {
+			err = fn(value.(URL))
+			return err == nil
+		},
+	)
+
+	return err
+}
+
+func (mr *MemoryReader) GetStats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	users := make(map[string]struct{})
+	mr.URLList.Range(
+		func(_, value any) bool {
+			URL := value.(URL)
+			if !URL.IsDeleted {
+				stats.URLs++
+				users[URL.UserID.String()] = struct{}{}
+			}
+			return true
+		},
+	)
+	stats.Users = len(users)
+
+	return stats, nil
 }
 
 type MemoryWriter struct {
-	URLList *sync.Map
+	URLList     *sync.Map
+	FullURLList *sync.Map
+	// Tags is the index shared with MemoryReader, it is optional.
+	Tags *TagIndex
+	// mu serializes updates of stored URLs, they hold tags and can not be
+	// compared by CompareAndSwap.
+	mu sync.Mutex
 }
 
 func (mw *MemoryWriter) SaveURL(ctx context.Context, URL URL) error {
-	mw.URLList.Store(URL.ShortURL, URL.OriginalURL)
+	URL = withCreatedAt(URL, time.Now().UTC())
+	if short, loaded := mw.FullURLList.LoadOrStore(URL.OriginalURL, URL.ShortURL); loaded {
+		return &ConflictError{ShortURL: short.(string), Err: errors.New(URL.OriginalURL)}
+	}
+	if _, loaded := mw.URLList.LoadOrStore(URL.ShortURL, URL); loaded {
+		mw.FullURLList.Delete(URL.OriginalURL)
+		return errors.New("short url already exists")
+	}
+	mw.Tags.add(URL)
 	return nil
 }
 
 func (mw *MemoryWriter) SaveBatch(ctx context.Context, batchURL []URL) error {
-	for _, URL := range batchURL {
-		mw.URLList.Store(URL.ShortURL, URL.OriginalURL)
+	for i, u := range batchURL {
+		err := mw.SaveURL(ctx, u)
+		if err != nil {
+			for _, saved := range batchURL[:i] {
+				if value, loaded := mw.URLList.LoadAndDelete(saved.ShortURL); loaded {
+					mw.Tags.remove(value.(URL))
+				}
+				mw.FullURLList.Delete(saved.OriginalURL)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mw *MemoryWriter) DeleteURLs(ctx context.Context, URLs []URL) error {
+	mw.update(URLs, markDeleted(time.Now().UTC()))
+
+	return nil
+}
+
+func (mw *MemoryWriter) DeleteUserURLs(ctx context.Context, userID string, URLs []URL) ([]URL, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	markDeleted := markDeleted(time.Now().UTC())
+	deleted := make([]URL, 0, len(URLs))
+	for _, u := range URLs {
+		value, ok := mw.URLList.Load(u.ShortURL)
+		if !ok {
+			continue
+		}
+		URL := value.(URL)
+		if URL.UserID.String() != userID {
+			continue
+		}
+		markDeleted(&URL)
+		mw.URLList.Store(u.ShortURL, URL)
+		deleted = append(deleted, u)
+	}
+
+	return deleted, nil
+}
+
+func (mw *MemoryWriter) UpdateMetadata(ctx context.Context, userID, shortURL string, meta Metadata) (URL, error) {
+	return mw.updateOwn(
+		userID, shortURL, func(u *URL) error {
+			u.setMetadata(meta, time.Now().UTC())
+			return nil
+		},
+	)
+}
+
+func (mw *MemoryWriter) UpdateTags(
+	ctx context.Context, userID, shortURL string, add, remove []string, maxTags int,
+) (URL, error) {
+	return mw.updateOwn(
+		userID, shortURL, func(u *URL) error {
+			added, _ := u.editTags(add, remove, time.Now().UTC())
+			return checkTags(*u, added, maxTags)
+		},
+	)
+}
+
+// updateOwn applies fn to the not deleted URL of the user and reindexes its
+// tags, the URL is not changed if fn fails.
+func (mw *MemoryWriter) updateOwn(userID, shortURL string, fn func(u *URL) error) (URL, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	value, ok := mw.URLList.Load(shortURL)
+	if !ok {
+		return URL{}, ErrNotFound
+	}
+	previous := value.(URL)
+	switch {
+	case previous.UserID.String() != userID:
+		return URL{}, ErrNotFound
+	case previous.IsDeleted:
+		return URL{}, &DeletedURLError{Err: errors.New(shortURL)}
+	}
+	u := previous
+	if err := fn(&u); err != nil {
+		return URL{}, err
+	}
+	mw.URLList.Store(shortURL, u)
+	mw.Tags.replace(previous, u)
+
+	return u, nil
+}
+
+func (mw *MemoryWriter) UpdateOriginalURL(ctx context.Context, userID, shortURL, originalURL string) (URL, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	value, ok := mw.URLList.Load(shortURL)
+	if !ok {
+		return URL{}, ErrNotFound
+	}
+	u := value.(URL)
+	switch {
+	case u.UserID.String() != userID:
+		return URL{}, ErrNotFound
+	case u.IsDeleted:
+		return URL{}, &DeletedURLError{Err: errors.New(shortURL)}
+	case u.OriginalURL == originalURL:
+		return u, nil
+	}
+	if short, loaded := mw.FullURLList.LoadOrStore(originalURL, shortURL); loaded {
+		return URL{}, &ConflictError{ShortURL: short.(string), Err: errors.New(originalURL)}
+	}
+	mw.FullURLList.CompareAndDelete(u.OriginalURL, shortURL)
+	u.replaceOriginalURL(originalURL, time.Now().UTC())
+	mw.URLList.Store(shortURL, u)
+
+	return u, nil
+}
+
+func (mw *MemoryWriter) CountClick(ctx context.Context, shortURL string) (URL, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	value, ok := mw.URLList.Load(shortURL)
+	if !ok {
+		return URL{}, ErrNotFound
+	}
+	u := value.(URL)
+	switch {
+	case u.IsDeleted:
+		return URL{}, &DeletedURLError{Err: errors.New(shortURL)}
+	case u.ClicksExhausted():
+		return URL{}, ErrClickLimit
+	}
+	u.Clicks++
+	mw.URLList.Store(shortURL, u)
+
+	return u, nil
+}
+
+// CountVariant ignores deleted URLs and variants removed meanwhile.
+func (mw *MemoryWriter) CountVariant(ctx context.Context, shortURL string, variant int) error {
+	_, err := mw.countVariant(shortURL, variant)
+	return err
+}
+
+func (mw *MemoryWriter) countVariant(shortURL string, variant int) (URL, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	value, ok := mw.URLList.Load(shortURL)
+	if !ok {
+		return URL{}, ErrNotFound
+	}
+	u := value.(URL)
+	if variant < 0 || variant >= len(u.Variants) {
+		return u, nil
 	}
+	u.Variants = append([]Variant{}, u.Variants...)
+	u.Variants[variant].Served++
+	mw.URLList.Store(shortURL, u)
+
+	return u, nil
+}
+
+func markDeleted(now time.Time) func(URL *URL) {
+	return func(URL *URL) {
+		URL.IsDeleted = true
+		if URL.DeletedAt.IsZero() {
+			URL.DeletedAt = now
+		}
+	}
+}
+
+func (mw *MemoryWriter) RestoreURLs(ctx context.Context, URLs []URL) error {
+	mw.update(
+		URLs, func(URL *URL) {
+			URL.IsDeleted = false
+			URL.DeletedAt = time.Time{}
+		},
+	)
 
 	return nil
 }
+
+func (mw *MemoryWriter) update(URLs []URL, fn func(URL *URL)) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	for _, u := range URLs {
+		value, ok := mw.URLList.Load(u.ShortURL)
+		if !ok {
+			continue
+		}
+		URL := value.(URL)
+		fn(&URL)
+		mw.URLList.Store(u.ShortURL, URL)
+	}
+}
+
+func (mw *MemoryWriter) PurgeDeletedBefore(ctx context.Context, before time.Time) (int, error) {
+	return len(mw.purgeDeletedBefore(before)), nil
+}
+
+// purgeDeletedBefore returns the purged URLs.
+func (mw *MemoryWriter) purgeDeletedBefore(before time.Time) []URL {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	var purged []URL
+	mw.URLList.Range(
+		func(key, value any) bool {
+			URL := value.(URL)
+			if !URL.IsDeleted || URL.DeletedAt.IsZero() || !URL.DeletedAt.Before(before) {
+				return true
+			}
+			mw.URLList.Delete(key)
+			mw.FullURLList.CompareAndDelete(URL.OriginalURL, URL.ShortURL)
+			mw.Tags.remove(URL)
+			purged = append(purged, URL)
+			return true
+		},
+	)
+
+	return purged
+}
+
+func (mw *MemoryWriter) PurgeURLs(ctx context.Context, URLs []URL) error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	for _, u := range URLs {
+		value, ok := mw.URLList.LoadAndDelete(u.ShortURL)
+		if !ok {
+			continue
+		}
+		mw.FullURLList.CompareAndDelete(value.(URL).OriginalURL, u.ShortURL)
+		mw.Tags.remove(value.(URL))
+	}
+
+	return nil
+}
+
+// Restore puts a previously persisted URL into memory as is, bypassing the
+// conflict checks of SaveURL. The original URL of a replaced record is freed.
+func (mw *MemoryWriter) Restore(u URL) {
+	var replaced URL
+	if previous, loaded := mw.URLList.Swap(u.ShortURL, u); loaded {
+		replaced = previous.(URL)
+		mw.FullURLList.CompareAndDelete(replaced.OriginalURL, u.ShortURL)
+	}
+	mw.Tags.replace(replaced, u)
+	mw.FullURLList.Store(u.OriginalURL, u.ShortURL)
+}
@@ -2,8 +2,16 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("short url not found")
+	ErrClickLimit  = errors.New("short url reached its click limit")
+	ErrTooManyTags = errors.New("url has too many tags")
 )
 
 type URL struct {
@@ -11,6 +19,102 @@ type URL struct {
 	OriginalURL string
 	ShortURL    string
 	UserID      uuid.UUID
+	IsDeleted   bool
+	DeletedAt   time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Title       string
+	Tags        []string
+	Note        string
+	// AlwaysPreview shows the preview page to all visitors instead of
+	// redirecting.
+	AlwaysPreview bool
+	// PasswordHash is the bcrypt hash of the password visitors enter before
+	// being redirected, empty for links without a password.
+	PasswordHash string
+	// MaxClicks limits the redirects of the URL, zero is unlimited. Clicks
+	// counts the redirects of limited URLs.
+	MaxClicks int
+	Clicks    int
+	// Rules route visits to other destinations, the first matching rule wins
+	// and OriginalURL is the default.
+	Rules []Rule
+	// Variants split the visits not matching any rule between their targets
+	// by weight, StickyVariants serves a returning visitor the same variant.
+	Variants       []Variant
+	StickyVariants bool
+	// UTM is the encoded query of the UTM parameters added to the destination,
+	// QueryPolicy tells how the query of the visit is passed to it.
+	UTM         string
+	QueryPolicy string
+	// revisions are kept with the URL by stores without a revisions table.
+	revisions []Revision
+}
+
+// Rule routes the visits matching all its conditions to Target, a condition
+// without values matches any visit.
+type Rule struct {
+	Devices   []string
+	Languages []string
+	Countries []string
+	Target    string
+}
+
+// Variant is a weighted target of an A/B split URL, Served counts the visits
+// it got.
+type Variant struct {
+	Target string
+	Weight int
+	Served int
+}
+
+// Revision is a previous original URL of a short URL.
+type Revision struct {
+	OriginalURL string
+	// ReplacedAt is when the original URL was changed to the next one.
+	ReplacedAt time.Time
+}
+
+// Metadata is the user editable description of a URL, the stored always
+// preview flag is kept if AlwaysPreview is nil.
+type Metadata struct {
+	Title         string
+	Tags          []string
+	Note          string
+	AlwaysPreview *bool
+}
+
+// withCreatedAt stamps a URL saved without a creation time, a URL never
+// updated is updated at creation.
+func withCreatedAt(URL URL, now time.Time) URL {
+	if URL.CreatedAt.IsZero() {
+		URL.CreatedAt = now
+	}
+	if URL.UpdatedAt.IsZero() {
+		URL.UpdatedAt = URL.CreatedAt
+	}
+	return URL
+}
+
+// ClicksExhausted reports whether the URL reached its click limit.
+func (u URL) ClicksExhausted() bool {
+	return u.MaxClicks > 0 && u.Clicks >= u.MaxClicks
+}
+
+func (u *URL) replaceOriginalURL(originalURL string, now time.Time) {
+	u.revisions = append(append([]Revision{}, u.revisions...), Revision{OriginalURL: u.OriginalURL, ReplacedAt: now})
+	u.OriginalURL = originalURL
+	u.UpdatedAt = now
+}
+
+func (u *URL) setMetadata(meta Metadata, now time.Time) {
+	u.Title = meta.Title
+	u.Tags = append([]string(nil), meta.Tags...)
+	u.Note = meta.Note
+	if meta.AlwaysPreview != nil {
+		u.AlwaysPreview = *meta.AlwaysPreview
+	}
+	u.UpdatedAt = now
 }
 
 type ConflictError struct {
@@ -58,11 +162,108 @@ type DeleteURLs interface {
 	DeleteURLs(ctx context.Context, URLs []URL) error
 }
 
+// OwnerDeleter deletes only the URLs owned by the user and returns the
+// deleted ones.
+type OwnerDeleter interface {
+	DeleteUserURLs(ctx context.Context, userID string, URLs []URL) ([]URL, error)
+}
+
 type WriterDeleter interface {
 	Writer
 	DeleteURLs
 }
 
+type Iterable interface {
+	ForEachURL(ctx context.Context, fn func(URL URL) error) error
+}
+
+type Stats struct {
+	URLs  int
+	Users int
+}
+
+// StatsReader counts URLs that are not deleted and users owning them.
+type StatsReader interface {
+	GetStats(ctx context.Context) (Stats, error)
+}
+
+// URLInfoReader returns the whole record of a short URL, deleted ones
+// included.
+type URLInfoReader interface {
+	GetURLInfo(ctx context.Context, shortURL string) (URL, error)
+}
+
+type Restorer interface {
+	RestoreURLs(ctx context.Context, URLs []URL) error
+}
+
+// DeletedFilter is the counterpart of UsersURLGetter.FilterURLsByUserID
+// returning only deleted URLs of the user.
+type DeletedFilter interface {
+	FilterDeletedURLsByUserID(ctx context.Context, userID string, URLs []URL) ([]URL, error)
+}
+
+// RetentionPurger hard deletes URLs deleted before the given time and returns
+// their count.
+type RetentionPurger interface {
+	PurgeDeletedBefore(ctx context.Context, before time.Time) (int, error)
+}
+
+// URLLister returns a page of the user's URLs matching the options.
+type URLLister interface {
+	ListURLsByUserID(ctx context.Context, userID string, opts ListOptions) (URLPage, error)
+}
+
+// MetadataUpdater replaces the metadata of a URL owned by the user and returns
+// the updated URL. ErrNotFound is returned for URLs of other users and
+// DeletedURLError for deleted ones.
+type MetadataUpdater interface {
+	UpdateMetadata(ctx context.Context, userID, shortURL string, meta Metadata) (URL, error)
+}
+
+// DestinationUpdater changes the original URL of a URL owned by the user and
+// keeps the previous one as a revision. ConflictError is returned when the new
+// original URL is shortened already, ErrNotFound and DeletedURLError as by
+// MetadataUpdater.
+type DestinationUpdater interface {
+	UpdateOriginalURL(ctx context.Context, userID, shortURL, originalURL string) (URL, error)
+}
+
+// RevisionReader returns the revisions of a short URL, oldest first.
+type RevisionReader interface {
+	GetRevisions(ctx context.Context, shortURL string) ([]Revision, error)
+}
+
+// TagEditor adds and removes tags of a URL owned by the user and returns the
+// updated URL, deleted URLs are not updated. ErrTooManyTags is returned and
+// nothing is changed if tags are added beyond maxTags, zero is unlimited.
+type TagEditor interface {
+	UpdateTags(ctx context.Context, userID, shortURL string, add, remove []string, maxTags int) (URL, error)
+}
+
+// TagCounter returns the tags of the user's not deleted URLs with counts, most
+// used first.
+type TagCounter interface {
+	GetTagCounts(ctx context.Context, userID string) ([]TagCount, error)
+}
+
+// ClickCounter counts a redirect of a short URL and returns the updated URL.
+// The click limit is checked and the count increased atomically, ErrClickLimit
+// is returned once the limit is reached and DeletedURLError for deleted URLs.
+type ClickCounter interface {
+	CountClick(ctx context.Context, shortURL string) (URL, error)
+}
+
+// VariantCounter counts a visit served the variant at the index of the URL's
+// variants.
+type VariantCounter interface {
+	CountVariant(ctx context.Context, shortURL string, variant int) error
+}
+
+type Purger interface {
+	PurgeURLs(ctx context.Context, URLs []URL) error
+}
+
 func (ce *DeletedURLError) Error() string {
 	return fmt.Sprintf("requested URL deleted: %v", ce.Err)
 }
@@ -0,0 +1,162 @@
+package store_test
+
+import (
+	"context"
+	"github.com/ZhuzhomaAL/go-shortener/internal/boltdb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/file"
+	"github.com/ZhuzhomaAL/go-shortener/internal/postgres"
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqldb"
+	"github.com/ZhuzhomaAL/go-shortener/internal/sqlite"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store/storetest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newMemoryStore(t *testing.T) (store.UserIDReader, store.WriterDeleter) {
+	urlList, fullURLList, tags := &sync.Map{}, &sync.Map{}, &store.TagIndex{}
+	return &store.MemoryReader{URLList: urlList, Tags: tags},
+		&store.MemoryWriter{URLList: urlList, FullURLList: fullURLList, Tags: tags}
+}
+
+func newFileStore(t *testing.T, fileName string) (store.UserIDReader, store.WriterDeleter) {
+	memoryWriter := &store.MemoryWriter{URLList: &sync.Map{}, FullURLList: &sync.Map{}, Tags: &store.TagIndex{}}
+	fReader, err := file.NewFileReader(fileName)
+	require.NoError(t, err)
+	defer fReader.Close()
+	require.NoError(t, store.LoadFile(fReader, memoryWriter))
+
+	fWriter, err := file.NewFileWriter(fileName, file.SyncNone, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { fWriter.Close() })
+
+	reader := &store.FileReader{MemoryReader: &store.MemoryReader{URLList: memoryWriter.URLList, Tags: memoryWriter.Tags}}
+	return reader, &store.FileWriter{MemoryWriter: memoryWriter, Writer: fWriter}
+}
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, newMemoryStore)
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.Run(
+		t, func(t *testing.T) (store.UserIDReader, store.WriterDeleter) {
+			return newFileStore(t, filepath.Join(t.TempDir(), "storage.json"))
+		},
+	)
+}
+
+func TestFileStore_Reload(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.json")
+	_, writer := newFileStore(t, fileName)
+	userID := uuid.New()
+	deleted := store.URL{OriginalURL: "https://ya.ru", ShortURL: "deleted1", UserID: userID}
+	kept := store.URL{
+		OriginalURL: "https://practicum.yandex.ru", ShortURL: "kept0001", UserID: userID,
+		PasswordHash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		Rules:        []store.Rule{{Devices: []string{"ios"}, Target: "https://apps.apple.com/app/id1"}},
+		Variants:     []store.Variant{{Target: "https://a.ru", Weight: 1}, {Target: "https://b.ru", Weight: 1}},
+		UTM:          "utm_source=newsletter",
+		QueryPolicy:  "merge",
+	}
+	purged := store.URL{OriginalURL: "https://google.com", ShortURL: "purged01", UserID: userID}
+	restored := store.URL{OriginalURL: "https://yandex.ru", ShortURL: "restored", UserID: userID}
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{deleted, kept, purged, restored}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted, restored}))
+	require.NoError(t, writer.(store.Restorer).RestoreURLs(ctx, []store.URL{restored}))
+	require.NoError(t, writer.(store.Purger).PurgeURLs(ctx, []store.URL{purged}))
+	alwaysPreview := true
+	meta := store.Metadata{Title: "Практикум", Tags: []string{"go"}, Note: "заметка", AlwaysPreview: &alwaysPreview}
+	_, err := writer.(store.MetadataUpdater).UpdateMetadata(ctx, userID.String(), kept.ShortURL, meta)
+	require.NoError(t, err)
+	_, err = writer.(store.DestinationUpdater).UpdateOriginalURL(ctx, userID.String(), restored.ShortURL, "https://dzen.ru")
+	require.NoError(t, err)
+	_, err = writer.(store.ClickCounter).CountClick(ctx, kept.ShortURL)
+	require.NoError(t, err)
+	require.NoError(t, writer.(store.VariantCounter).CountVariant(ctx, kept.ShortURL, 1))
+	require.NoError(t, writer.(*store.FileWriter).Writer.Close())
+
+	reader, reloaded := newFileStore(t, fileName)
+	urls, err := reader.GetURLsByUserID(ctx, userID.String())
+	require.NoError(t, err)
+	require.Len(t, urls, 3)
+	fullURL, err := reader.GetURL(ctx, kept.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, kept.OriginalURL, fullURL)
+	info, err := reader.(store.URLInfoReader).GetURLInfo(ctx, kept.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(
+		t, store.Metadata{Title: info.Title, Tags: info.Tags, Note: info.Note, AlwaysPreview: &info.AlwaysPreview}, meta,
+		"Метаданные не восстановлены",
+	)
+	assert.True(t, info.UpdatedAt.After(info.CreatedAt), "Время изменения не восстановлено")
+	assert.Equal(t, kept.PasswordHash, info.PasswordHash, "Хеш пароля не восстановлен")
+	assert.Equal(t, 1, info.Clicks, "Переходы не восстановлены")
+	assert.Equal(t, kept.Rules, info.Rules, "Правила не восстановлены")
+	assert.Equal(
+		t, []store.Variant{{Target: "https://a.ru", Weight: 1}, {Target: "https://b.ru", Weight: 1, Served: 1}},
+		info.Variants, "Варианты не восстановлены",
+	)
+	assert.Equal(t, kept.UTM, info.UTM, "UTM-параметры не восстановлены")
+	assert.Equal(t, kept.QueryPolicy, info.QueryPolicy, "Политика параметров не восстановлена")
+	fullURL, err = reader.GetURL(ctx, restored.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://dzen.ru", fullURL)
+	revisions, err := reader.(store.RevisionReader).GetRevisions(ctx, restored.ShortURL)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1, "История изменений не восстановлена")
+	assert.Equal(t, restored.OriginalURL, revisions[0].OriginalURL)
+	reused := store.URL{OriginalURL: restored.OriginalURL, ShortURL: "reused01", UserID: userID}
+	assert.NoError(t, reloaded.SaveURL(ctx, reused), "Прежний URL не освобождён после загрузки")
+	_, err = reader.GetURL(ctx, deleted.ShortURL)
+	var deletedErr *store.DeletedURLError
+	assert.ErrorAs(t, err, &deletedErr)
+	_, err = reader.GetURL(ctx, purged.ShortURL)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestBoltStore(t *testing.T) {
+	storetest.Run(
+		t, func(t *testing.T) (store.UserIDReader, store.WriterDeleter) {
+			db, err := boltdb.GetConnection(filepath.Join(t.TempDir(), "storage.bolt"))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			require.NoError(t, boltdb.InitializeDB(db))
+			return &store.BoltReader{DB: db}, &store.BoltWriter{DB: db}
+		},
+	)
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(
+		t, func(t *testing.T) (store.UserIDReader, store.WriterDeleter) {
+			db, err := sqlite.GetConnection(filepath.Join(t.TempDir(), "storage.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			require.NoError(t, sqlite.InitializeDB(db))
+			return &store.DBReader{DB: db, Dialect: sqldb.SQLite{}}, &store.DBWriter{DB: db, Dialect: sqldb.SQLite{}}
+		},
+	)
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db := postgres.GetConnection(dsn)
+	defer db.Close()
+	require.NoError(t, postgres.InitializeDB(db))
+
+	storetest.Run(
+		t, func(t *testing.T) (store.UserIDReader, store.WriterDeleter) {
+			return &store.DBReader{DB: db}, &store.DBWriter{DB: db}
+		},
+	)
+}
@@ -0,0 +1,834 @@
+// Package storetest provides a conformance suite every store backend is
+// expected to pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/dchest/uniuri"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns a reader and a writer sharing the same storage. Backends
+// may reuse storage between calls, the suite only relies on unique data.
+type Factory func(t *testing.T) (store.UserIDReader, store.WriterDeleter)
+
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter)
+	}{
+		{name: "save_and_get", test: testSaveAndGet},
+		{name: "not_found", test: testNotFound},
+		{name: "conflict", test: testConflict},
+		{name: "batch", test: testBatch},
+		{name: "batch_conflict", test: testBatchConflict},
+		{name: "list_by_user", test: testListByUser},
+		{name: "filter_by_user", test: testFilterByUser},
+		{name: "delete", test: testDelete},
+		{name: "concurrent_save", test: testConcurrentSave},
+		{name: "for_each", test: testForEach},
+		{name: "purge", test: testPurge},
+		{name: "stats", test: testStats},
+		{name: "url_info", test: testURLInfo},
+		{name: "restore", test: testRestore},
+		{name: "filter_deleted_by_user", test: testFilterDeletedByUser},
+		{name: "purge_deleted_before", test: testPurgeDeletedBefore},
+		{name: "save_deleted", test: testSaveDeleted},
+		{name: "delete_user_urls", test: testDeleteUserURLs},
+		{name: "list_page", test: testListPage},
+		{name: "metadata", test: testMetadata},
+		{name: "update_original_url", test: testUpdateOriginalURL},
+		{name: "tags", test: testTags},
+		{name: "count_click", test: testCountClick},
+		{name: "count_variant", test: testCountVariant},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				reader, writer := newStore(t)
+				tt.test(t, reader, writer)
+			},
+		)
+	}
+}
+
+func newURL(userID uuid.UUID) store.URL {
+	return store.URL{
+		OriginalURL: "https://" + uniuri.NewLen(12) + ".ru",
+		ShortURL:    uniuri.NewLen(8),
+		UserID:      userID,
+	}
+}
+
+func shortURLs(URLs []store.URL) []string {
+	var res []string
+	for _, u := range URLs {
+		res = append(res, u.ShortURL)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func testSaveAndGet(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	URL := newURL(uuid.New())
+
+	require.NoError(t, writer.SaveURL(ctx, URL))
+	fullURL, err := reader.GetURL(ctx, URL.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, URL.OriginalURL, fullURL)
+}
+
+func testNotFound(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	_, err := reader.GetURL(context.Background(), uniuri.NewLen(8))
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testConflict(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	URL := newURL(uuid.New())
+	require.NoError(t, writer.SaveURL(ctx, URL))
+
+	duplicate := newURL(uuid.New())
+	duplicate.OriginalURL = URL.OriginalURL
+	err := writer.SaveURL(ctx, duplicate)
+	var conflictErr *store.ConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	assert.Equal(t, URL.ShortURL, conflictErr.ShortURL)
+
+	_, err = reader.GetURL(ctx, duplicate.ShortURL)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testBatch(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	userID := uuid.New()
+	batch := []store.URL{newURL(userID), newURL(userID), newURL(userID)}
+
+	require.NoError(t, writer.SaveBatch(ctx, nil))
+	require.NoError(t, writer.SaveBatch(ctx, batch))
+	for _, URL := range batch {
+		fullURL, err := reader.GetURL(ctx, URL.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(t, URL.OriginalURL, fullURL)
+	}
+}
+
+func testBatchConflict(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	userID := uuid.New()
+	existing := newURL(userID)
+	require.NoError(t, writer.SaveURL(ctx, existing))
+
+	duplicate := newURL(userID)
+	duplicate.OriginalURL = existing.OriginalURL
+	batch := []store.URL{newURL(userID), duplicate}
+	require.Error(t, writer.SaveBatch(ctx, batch))
+
+	for _, URL := range batch {
+		_, err := reader.GetURL(ctx, URL.ShortURL)
+		assert.ErrorIs(t, err, store.ErrNotFound, "Батч сохранён частично")
+	}
+}
+
+func testListByUser(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	userID, otherID := uuid.New(), uuid.New()
+	own := []store.URL{newURL(userID), newURL(userID)}
+	require.NoError(t, writer.SaveBatch(ctx, own))
+	require.NoError(t, writer.SaveURL(ctx, newURL(otherID)))
+
+	urls, err := reader.GetURLsByUserID(ctx, userID.String())
+	require.NoError(t, err)
+	assert.Equal(t, shortURLs(own), shortURLs(urls))
+	for _, u := range urls {
+		assert.Equal(t, userID, u.UserID)
+		assert.NotEmpty(t, u.OriginalURL)
+	}
+
+	urls, err = reader.GetURLsByUserID(ctx, uuid.New().String())
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func testFilterByUser(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	userID, otherID := uuid.New(), uuid.New()
+	own := newURL(userID)
+	foreign := newURL(otherID)
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{own, foreign}))
+
+	query := []store.URL{{ShortURL: own.ShortURL}, {ShortURL: foreign.ShortURL}, {ShortURL: uniuri.NewLen(8)}}
+	urls, err := reader.FilterURLsByUserID(ctx, userID.String(), query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{own.ShortURL}, shortURLs(urls))
+
+	urls, err = reader.FilterURLsByUserID(ctx, userID.String(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func testDelete(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	userID := uuid.New()
+	deleted, kept := newURL(userID), newURL(userID)
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{deleted, kept}))
+
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{{ShortURL: deleted.ShortURL}}))
+	require.NoError(t, writer.DeleteURLs(ctx, nil))
+
+	_, err := reader.GetURL(ctx, deleted.ShortURL)
+	var deletedErr *store.DeletedURLError
+	assert.ErrorAs(t, err, &deletedErr)
+	fullURL, err := reader.GetURL(ctx, kept.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, kept.OriginalURL, fullURL)
+
+	query := []store.URL{{ShortURL: deleted.ShortURL}, {ShortURL: kept.ShortURL}}
+	urls, err := reader.FilterURLsByUserID(ctx, userID.String(), query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{kept.ShortURL}, shortURLs(urls))
+
+	urls, err = reader.GetURLsByUserID(ctx, userID.String())
+	require.NoError(t, err)
+	for _, u := range urls {
+		assert.Equal(t, u.ShortURL == deleted.ShortURL, u.IsDeleted)
+	}
+
+	duplicate := newURL(userID)
+	duplicate.OriginalURL = deleted.OriginalURL
+	var conflictErr *store.ConflictError
+	require.ErrorAs(t, writer.SaveURL(ctx, duplicate), &conflictErr)
+	assert.Equal(t, deleted.ShortURL, conflictErr.ShortURL)
+}
+
+func testConcurrentSave(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ctx := context.Background()
+	userID := uuid.New()
+	shared := newURL(userID).OriginalURL
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var saved []store.URL
+	var conflicts []string
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			URL := newURL(userID)
+			assert.NoError(t, writer.SaveURL(ctx, URL))
+		}()
+		go func() {
+			defer wg.Done()
+			URL := newURL(userID)
+			URL.OriginalURL = shared
+			err := writer.SaveURL(ctx, URL)
+			var conflictErr *store.ConflictError
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				saved = append(saved, URL)
+			case errors.As(err, &conflictErr):
+				conflicts = append(conflicts, conflictErr.ShortURL)
+			default:
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, saved, 1, "Один и тот же URL сохранён несколько раз")
+	for _, short := range conflicts {
+		assert.Equal(t, saved[0].ShortURL, short)
+	}
+	urls, err := reader.GetURLsByUserID(ctx, userID.String())
+	require.NoError(t, err)
+	assert.Len(t, urls, 21)
+}
+
+func testForEach(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	iterable, ok := reader.(store.Iterable)
+	if !ok {
+		t.Skip("reader does not implement store.Iterable")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	batch := []store.URL{newURL(userID), newURL(userID)}
+	require.NoError(t, writer.SaveBatch(ctx, batch))
+	require.NoError(t, writer.DeleteURLs(ctx, batch[:1]))
+
+	var urls []store.URL
+	err := iterable.ForEachURL(
+		ctx, func(URL store.URL) error {
+			if URL.UserID == userID {
+				urls = append(urls, URL)
+			}
+			return nil
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, shortURLs(batch), shortURLs(urls))
+	for _, u := range urls {
+		assert.Equal(t, u.ShortURL == batch[0].ShortURL, u.IsDeleted)
+	}
+
+	stop := errors.New("stop")
+	err = iterable.ForEachURL(
+		ctx, func(URL store.URL) error {
+			return stop
+		},
+	)
+	assert.ErrorIs(t, err, stop)
+}
+
+func testPurge(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	purger, ok := writer.(store.Purger)
+	if !ok {
+		t.Skip("writer does not implement store.Purger")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	purged, kept := newURL(userID), newURL(userID)
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{purged, kept}))
+
+	require.NoError(t, purger.PurgeURLs(ctx, []store.URL{{ShortURL: purged.ShortURL}, {ShortURL: uniuri.NewLen(8)}}))
+	_, err := reader.GetURL(ctx, purged.ShortURL)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	urls, err := reader.GetURLsByUserID(ctx, userID.String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{kept.ShortURL}, shortURLs(urls))
+
+	reused := newURL(userID)
+	reused.OriginalURL = purged.OriginalURL
+	require.NoError(t, writer.SaveURL(ctx, reused), "Полный URL остался занят после удаления")
+}
+
+func testStats(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	statsReader, ok := reader.(store.StatsReader)
+	if !ok {
+		t.Skip("reader does not implement store.StatsReader")
+	}
+	ctx := context.Background()
+	before, err := statsReader.GetStats(ctx)
+	require.NoError(t, err)
+
+	userID := uuid.New()
+	deleted := newURL(userID)
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{newURL(userID), newURL(userID), deleted}))
+	require.NoError(t, writer.SaveURL(ctx, newURL(uuid.New())))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted}))
+
+	after, err := statsReader.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before.URLs+3, after.URLs, "Количество URL не совпадает с ожидаемым")
+	assert.Equal(t, before.Users+2, after.Users, "Количество пользователей не совпадает с ожидаемым")
+}
+
+func testURLInfo(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	infoReader, ok := reader.(store.URLInfoReader)
+	if !ok {
+		t.Skip("reader does not implement store.URLInfoReader")
+	}
+	ctx := context.Background()
+	URL := newURL(uuid.New())
+	URL.PasswordHash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+	URL.Rules = []store.Rule{
+		{Devices: []string{"ios"}, Target: "https://apps.apple.com/app/id1"},
+		{Devices: []string{"android"}, Languages: []string{"ru", "uk"}, Countries: []string{"RU"}, Target: "https://ru.ru"},
+	}
+	URL.UTM = "utm_medium=email&utm_source=newsletter"
+	URL.QueryPolicy = "override"
+	require.NoError(t, writer.SaveURL(ctx, URL))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{URL}))
+
+	info, err := infoReader.GetURLInfo(ctx, URL.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, URL.OriginalURL, info.OriginalURL)
+	assert.Equal(t, URL.UserID, info.UserID)
+	assert.Equal(t, URL.PasswordHash, info.PasswordHash, "Хеш пароля не сохранён")
+	assert.Equal(t, URL.Rules, info.Rules, "Правила не сохранены")
+	assert.Equal(t, URL.UTM, info.UTM, "UTM-параметры не сохранены")
+	assert.Equal(t, URL.QueryPolicy, info.QueryPolicy, "Политика параметров не сохранена")
+	assert.True(t, info.IsDeleted, "URL не помечен удаленным")
+
+	_, err = infoReader.GetURLInfo(ctx, uniuri.NewLen(8))
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testRestore(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	restorer, ok := writer.(store.Restorer)
+	if !ok {
+		t.Skip("writer does not implement store.Restorer")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	restored, deleted := newURL(userID), newURL(userID)
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{restored, deleted}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{restored, deleted}))
+
+	require.NoError(t, restorer.RestoreURLs(ctx, []store.URL{{ShortURL: restored.ShortURL}, {ShortURL: uniuri.NewLen(8)}}))
+	fullURL, err := reader.GetURL(ctx, restored.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, restored.OriginalURL, fullURL)
+	_, err = reader.GetURL(ctx, deleted.ShortURL)
+	var deletedErr *store.DeletedURLError
+	assert.ErrorAs(t, err, &deletedErr)
+}
+
+func testFilterDeletedByUser(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	deletedFilter, ok := reader.(store.DeletedFilter)
+	if !ok {
+		t.Skip("reader does not implement store.DeletedFilter")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	deleted, active, foreign := newURL(userID), newURL(userID), newURL(uuid.New())
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{deleted, active, foreign}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted, foreign}))
+
+	urls, err := deletedFilter.FilterDeletedURLsByUserID(
+		ctx, userID.String(), []store.URL{{ShortURL: deleted.ShortURL}, {ShortURL: active.ShortURL}, {ShortURL: foreign.ShortURL}},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{deleted.ShortURL}, shortURLs(urls))
+}
+
+func testPurgeDeletedBefore(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	retentionPurger, ok := writer.(store.RetentionPurger)
+	if !ok {
+		t.Skip("writer does not implement store.RetentionPurger")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	deleted, kept := newURL(userID), newURL(userID)
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{deleted, kept}))
+	before := time.Now().Add(-time.Hour)
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted}))
+
+	_, err := retentionPurger.PurgeDeletedBefore(ctx, before)
+	require.NoError(t, err)
+	_, err = reader.GetURL(ctx, deleted.ShortURL)
+	var deletedErr *store.DeletedURLError
+	require.ErrorAs(t, err, &deletedErr, "URL удален до истечения срока хранения")
+
+	purged, err := retentionPurger.PurgeDeletedBefore(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, 1)
+	_, err = reader.GetURL(ctx, deleted.ShortURL)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	fullURL, err := reader.GetURL(ctx, kept.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, kept.OriginalURL, fullURL)
+}
+
+func testSaveDeleted(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	retentionPurger, ok := writer.(store.RetentionPurger)
+	if !ok {
+		t.Skip("writer does not implement store.RetentionPurger")
+	}
+	ctx := context.Background()
+	URL := newURL(uuid.New())
+	URL.IsDeleted = true
+	URL.DeletedAt = time.Now().Add(-48 * time.Hour).UTC()
+	require.NoError(t, writer.SaveURL(ctx, URL))
+	_, err := reader.GetURL(ctx, URL.ShortURL)
+	var deletedErr *store.DeletedURLError
+	require.ErrorAs(t, err, &deletedErr, "URL не сохранен удаленным")
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{URL}))
+
+	_, err = retentionPurger.PurgeDeletedBefore(ctx, time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	_, err = reader.GetURL(ctx, URL.ShortURL)
+	assert.ErrorIs(t, err, store.ErrNotFound, "Время удаления не сохранено")
+}
+
+func testDeleteUserURLs(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	ownerDeleter, ok := writer.(store.OwnerDeleter)
+	if !ok {
+		t.Skip("writer does not implement store.OwnerDeleter")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	own, foreign := newURL(userID), newURL(uuid.New())
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{own, foreign}))
+
+	deleted, err := ownerDeleter.DeleteUserURLs(
+		ctx, userID.String(),
+		[]store.URL{{ShortURL: own.ShortURL}, {ShortURL: foreign.ShortURL}, {ShortURL: uniuri.NewLen(8)}},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{own.ShortURL}, shortURLs(deleted))
+	var deletedErr *store.DeletedURLError
+	_, err = reader.GetURL(ctx, own.ShortURL)
+	assert.ErrorAs(t, err, &deletedErr)
+	fullURL, err := reader.GetURL(ctx, foreign.ShortURL)
+	require.NoError(t, err, "Удален чужой URL")
+	assert.Equal(t, foreign.OriginalURL, fullURL)
+}
+
+func testListPage(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	lister, ok := reader.(store.URLLister)
+	if !ok {
+		t.Skip("reader does not implement store.URLLister")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	domain := strings.ToLower(uniuri.NewLen(12)) + ".org"
+	base := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	var URLs []store.URL
+	for i := 0; i < 5; i++ {
+		URL := newURL(userID)
+		URL.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		URLs = append(URLs, URL)
+	}
+	URLs[1].OriginalURL = "https://" + domain + "/" + uniuri.NewLen(12)
+	URLs[3].OriginalURL = "https://www." + domain + ":8080/" + uniuri.NewLen(12)
+	require.NoError(t, writer.SaveBatch(ctx, URLs))
+	require.NoError(t, writer.SaveURL(ctx, newURL(uuid.New())))
+	require.NoError(t, writer.DeleteURLs(ctx, URLs[4:]))
+
+	list := func(opts store.ListOptions) ([]string, int) {
+		var res []string
+		var total int
+		for i := 0; i < 10; i++ {
+			page, err := lister.ListURLsByUserID(ctx, userID.String(), opts)
+			require.NoError(t, err)
+			total = page.Total
+			for _, u := range page.URLs {
+				res = append(res, u.ShortURL)
+			}
+			if page.Next == nil {
+				return res, total
+			}
+			opts.Cursor = page.Next
+		}
+		t.Fatal("Пагинация не завершилась")
+		return nil, 0
+	}
+	ids := func(indexes ...int) []string {
+		var res []string
+		for _, i := range indexes {
+			res = append(res, URLs[i].ShortURL)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name  string
+		opts  store.ListOptions
+		want  []string
+		total int
+	}{
+		{name: "newest_first", opts: store.ListOptions{Limit: 2}, want: ids(3, 2, 1, 0), total: 4},
+		{name: "ascending", opts: store.ListOptions{Limit: 3, Ascending: true}, want: ids(0, 1, 2, 3), total: 4},
+		{name: "include_deleted", opts: store.ListOptions{Limit: 2, IncludeDeleted: true}, want: ids(4, 3, 2, 1, 0), total: 5},
+		{name: "domain", opts: store.ListOptions{Limit: 1, Domain: strings.ToUpper(domain)}, want: ids(3, 1), total: 2},
+		{
+			name: "created_range",
+			opts: store.ListOptions{CreatedFrom: URLs[1].CreatedAt, CreatedTo: URLs[3].CreatedAt},
+			want: ids(2, 1), total: 2,
+		},
+		{
+			name: "search", opts: store.ListOptions{Search: strings.ToUpper(URLs[2].OriginalURL[8:14])},
+			want: ids(2), total: 1,
+		},
+		{name: "not_found", opts: store.ListOptions{Search: uniuri.NewLen(20)}, total: 0},
+	}
+	for _, tt := range tests {
+		got, total := list(tt.opts)
+		assert.Equal(t, tt.want, got, tt.name)
+		assert.Equal(t, tt.total, total, tt.name)
+	}
+
+	page, err := lister.ListURLsByUserID(ctx, userID.String(), store.ListOptions{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	assert.True(t, URLs[3].CreatedAt.Equal(page.URLs[0].CreatedAt), "Время создания не сохранено")
+	cursor, err := store.DecodeCursor(page.Next.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, page.URLs[0].ShortURL, cursor.ShortURL)
+}
+
+func testMetadata(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	infoReader, ok := reader.(store.URLInfoReader)
+	if !ok {
+		t.Skip("reader does not implement store.URLInfoReader")
+	}
+	updater, ok := writer.(store.MetadataUpdater)
+	if !ok {
+		t.Skip("writer does not implement store.MetadataUpdater")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	URL, deleted := newURL(userID), newURL(userID)
+	URL.Title, URL.Tags, URL.Note = "Заголовок", []string{"a", "b"}, "заметка"
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{URL, deleted}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted}))
+
+	saved, err := infoReader.GetURLInfo(ctx, URL.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, URL.Title, saved.Title)
+	assert.Equal(t, URL.Tags, saved.Tags)
+	assert.Equal(t, URL.Note, saved.Note)
+	assert.False(t, saved.CreatedAt.IsZero(), "Не задано время создания")
+	assert.True(t, saved.UpdatedAt.Equal(saved.CreatedAt), "Время изменения не совпадает с временем создания")
+
+	alwaysPreview := true
+	meta := store.Metadata{Title: "Новый", Tags: []string{"c"}, AlwaysPreview: &alwaysPreview}
+	updated, err := updater.UpdateMetadata(ctx, userID.String(), URL.ShortURL, meta)
+	require.NoError(t, err)
+	assert.Equal(t, meta.Tags, updated.Tags)
+	assert.Empty(t, updated.Note)
+	assert.True(t, updated.AlwaysPreview)
+
+	urls, err := reader.GetURLsByUserID(ctx, userID.String())
+	require.NoError(t, err)
+	for _, u := range urls {
+		if u.ShortURL == URL.ShortURL {
+			assert.Equal(t, meta.Title, u.Title)
+			assert.Equal(t, meta.Tags, u.Tags)
+			assert.Empty(t, u.Note)
+			assert.True(t, u.AlwaysPreview, "Не сохранён показ предпросмотра")
+			assert.False(t, u.UpdatedAt.Before(saved.UpdatedAt), "Время изменения не обновлено")
+		}
+	}
+	updated, err = updater.UpdateMetadata(ctx, userID.String(), URL.ShortURL, store.Metadata{Title: "Без флага"})
+	require.NoError(t, err)
+	assert.True(t, updated.AlwaysPreview, "Показ предпросмотра сброшен без флага в запросе")
+
+	_, err = updater.UpdateMetadata(ctx, uuid.New().String(), URL.ShortURL, meta)
+	assert.ErrorIs(t, err, store.ErrNotFound, "Изменены метаданные чужой ссылки")
+	_, err = updater.UpdateMetadata(ctx, userID.String(), uniuri.NewLen(8), meta)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = updater.UpdateMetadata(ctx, userID.String(), deleted.ShortURL, meta)
+	var deletedErr *store.DeletedURLError
+	assert.ErrorAs(t, err, &deletedErr)
+}
+
+func testUpdateOriginalURL(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	updater, ok := writer.(store.DestinationUpdater)
+	if !ok {
+		t.Skip("writer does not implement store.DestinationUpdater")
+	}
+	revisionReader, ok := reader.(store.RevisionReader)
+	if !ok {
+		t.Skip("reader does not implement store.RevisionReader")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	URL, other, deleted := newURL(userID), newURL(userID), newURL(userID)
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{URL, other, deleted}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted}))
+
+	destination := newURL(userID).OriginalURL
+	updated, err := updater.UpdateOriginalURL(ctx, userID.String(), URL.ShortURL, destination)
+	require.NoError(t, err)
+	assert.Equal(t, destination, updated.OriginalURL)
+	_, err = updater.UpdateOriginalURL(ctx, userID.String(), URL.ShortURL, destination)
+	require.NoError(t, err, "Повторное изменение на тот же URL")
+	fullURL, err := reader.GetURL(ctx, URL.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, destination, fullURL)
+
+	revisions, err := revisionReader.GetRevisions(ctx, URL.ShortURL)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, URL.OriginalURL, revisions[0].OriginalURL)
+	assert.False(t, revisions[0].ReplacedAt.IsZero(), "Не задано время замены")
+
+	reused := newURL(userID)
+	reused.OriginalURL = URL.OriginalURL
+	require.NoError(t, writer.SaveURL(ctx, reused), "Прежний URL не освобождён")
+
+	_, err = updater.UpdateOriginalURL(ctx, userID.String(), URL.ShortURL, other.OriginalURL)
+	var conflictErr *store.ConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	assert.Equal(t, other.ShortURL, conflictErr.ShortURL)
+
+	_, err = updater.UpdateOriginalURL(ctx, uuid.New().String(), URL.ShortURL, newURL(userID).OriginalURL)
+	assert.ErrorIs(t, err, store.ErrNotFound, "Изменена чужая ссылка")
+	_, err = updater.UpdateOriginalURL(ctx, userID.String(), deleted.ShortURL, newURL(userID).OriginalURL)
+	var deletedErr *store.DeletedURLError
+	assert.ErrorAs(t, err, &deletedErr)
+	_, err = revisionReader.GetRevisions(ctx, uniuri.NewLen(8))
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testTags(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	lister, ok := reader.(store.URLLister)
+	if !ok {
+		t.Skip("reader does not implement store.URLLister")
+	}
+	counter, ok := reader.(store.TagCounter)
+	if !ok {
+		t.Skip("reader does not implement store.TagCounter")
+	}
+	editor, ok := writer.(store.TagEditor)
+	if !ok {
+		t.Skip("writer does not implement store.TagEditor")
+	}
+	ctx := context.Background()
+	userID := uuid.New()
+	first, second, deleted := newURL(userID), newURL(userID), newURL(userID)
+	first.Tags, deleted.Tags = []string{"news"}, []string{"go"}
+	foreign := newURL(uuid.New())
+	foreign.Tags = []string{"go"}
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{first, second, deleted, foreign}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted}))
+
+	updated, err := editor.UpdateTags(ctx, userID.String(), first.ShortURL, []string{"go", "news"}, nil, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go", "news"}, updated.Tags)
+	_, err = editor.UpdateTags(ctx, userID.String(), first.ShortURL, []string{"extra"}, nil, 2)
+	assert.ErrorIs(t, err, store.ErrTooManyTags, "Превышен лимит тегов")
+	_, err = editor.UpdateTags(ctx, userID.String(), second.ShortURL, []string{"go"}, nil, 0)
+	require.NoError(t, err)
+
+	counts, err := counter.GetTagCounts(ctx, userID.String())
+	require.NoError(t, err)
+	assert.Equal(
+		t, []store.TagCount{{Tag: "go", Count: 2}, {Tag: "news", Count: 1}}, counts,
+		"Удалённые и чужие ссылки учтены в подсчёте",
+	)
+
+	page, err := lister.ListURLsByUserID(ctx, userID.String(), store.ListOptions{Tag: "go"})
+	require.NoError(t, err)
+	assert.Equal(t, shortURLs([]store.URL{first, second}), shortURLs(page.URLs))
+	assert.Equal(t, 2, page.Total)
+
+	updated, err = editor.UpdateTags(ctx, userID.String(), first.ShortURL, nil, []string{"go", "missing"}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"news"}, updated.Tags)
+	page, err = lister.ListURLsByUserID(ctx, userID.String(), store.ListOptions{Tag: "go"})
+	require.NoError(t, err)
+	assert.Equal(t, shortURLs([]store.URL{second}), shortURLs(page.URLs), "Ссылка найдена по снятому тегу")
+	page, err = lister.ListURLsByUserID(ctx, userID.String(), store.ListOptions{Tag: "go", IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, shortURLs([]store.URL{second, deleted}), shortURLs(page.URLs))
+
+	_, err = editor.UpdateTags(ctx, uuid.New().String(), first.ShortURL, []string{"go"}, nil, 0)
+	assert.ErrorIs(t, err, store.ErrNotFound, "Изменены теги чужой ссылки")
+	_, err = editor.UpdateTags(ctx, userID.String(), deleted.ShortURL, []string{"go"}, nil, 0)
+	var deletedErr *store.DeletedURLError
+	assert.ErrorAs(t, err, &deletedErr)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			_, err := editor.UpdateTags(ctx, userID.String(), second.ShortURL, []string{tag}, nil, 3)
+			if err != nil {
+				assert.ErrorIs(t, err, store.ErrTooManyTags)
+			}
+		}("tag" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	page, err = lister.ListURLsByUserID(ctx, userID.String(), store.ListOptions{Tag: "go"})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	assert.Len(t, page.URLs[0].Tags, 3, "Лимит тегов превышен параллельными запросами")
+}
+
+func testCountClick(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	counter, ok := writer.(store.ClickCounter)
+	if !ok {
+		t.Skip("writer does not implement store.ClickCounter")
+	}
+	ctx := context.Background()
+	limited := newURL(uuid.New())
+	limited.MaxClicks = 3
+	unlimited := newURL(uuid.New())
+	deleted := newURL(uuid.New())
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{limited, unlimited, deleted}))
+	require.NoError(t, writer.DeleteURLs(ctx, []store.URL{deleted}))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var counted, exhausted int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := counter.CountClick(ctx, limited.ShortURL)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				counted++
+			case errors.Is(err, store.ErrClickLimit):
+				exhausted++
+			default:
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, counted, "Превышен лимит переходов")
+	assert.Equal(t, 7, exhausted)
+	if infoReader, ok := reader.(store.URLInfoReader); ok {
+		info, err := infoReader.GetURLInfo(ctx, limited.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(t, 3, info.Clicks)
+		assert.Equal(t, 3, info.MaxClicks)
+		assert.True(t, info.ClicksExhausted())
+	}
+
+	for i := 1; i <= 2; i++ {
+		updated, err := counter.CountClick(ctx, unlimited.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(t, i, updated.Clicks)
+		assert.False(t, updated.ClicksExhausted())
+	}
+	_, err := counter.CountClick(ctx, deleted.ShortURL)
+	var deletedErr *store.DeletedURLError
+	assert.ErrorAs(t, err, &deletedErr)
+	_, err = counter.CountClick(ctx, uniuri.NewLen(8))
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testCountVariant(t *testing.T, reader store.UserIDReader, writer store.WriterDeleter) {
+	counter, ok := writer.(store.VariantCounter)
+	if !ok {
+		t.Skip("writer does not implement store.VariantCounter")
+	}
+	ctx := context.Background()
+	URL := newURL(uuid.New())
+	URL.Variants = []store.Variant{{Target: "https://a.ru", Weight: 3}, {Target: "https://b.ru", Weight: 1}}
+	URL.StickyVariants = true
+	require.NoError(t, writer.SaveBatch(ctx, []store.URL{URL}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(variant int) {
+			defer wg.Done()
+			assert.NoError(t, counter.CountVariant(ctx, URL.ShortURL, variant%2))
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, counter.CountVariant(ctx, URL.ShortURL, 5), "Несуществующий вариант не пропущен")
+	assert.ErrorIs(t, counter.CountVariant(ctx, "missing1", 0), store.ErrNotFound, "Посчитан показ несуществующей ссылки")
+
+	if infoReader, ok := reader.(store.URLInfoReader); ok {
+		info, err := infoReader.GetURLInfo(ctx, URL.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(
+			t, []store.Variant{{Target: "https://a.ru", Weight: 3, Served: 5}, {Target: "https://b.ru", Weight: 1, Served: 5}},
+			info.Variants, "Показы вариантов не посчитаны",
+		)
+		assert.True(t, info.StickyVariants)
+	}
+}
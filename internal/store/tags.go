@@ -0,0 +1,174 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TagCount is the number of not deleted URLs of a user with the tag.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// editTags adds and removes the tags keeping them sorted and returns the tags
+// actually added and removed. A URL without changes is not updated.
+func (u *URL) editTags(add, remove []string, now time.Time) (added, removed []string) {
+	tags := make(map[string]struct{}, len(u.Tags)+len(add))
+	for _, tag := range u.Tags {
+		tags[tag] = struct{}{}
+	}
+	for _, tag := range add {
+		if _, ok := tags[tag]; !ok {
+			tags[tag] = struct{}{}
+			added = append(added, tag)
+		}
+	}
+	for _, tag := range remove {
+		if _, ok := tags[tag]; ok {
+			delete(tags, tag)
+			removed = append(removed, tag)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+	u.Tags = make([]string, 0, len(tags))
+	for tag := range tags {
+		u.Tags = append(u.Tags, tag)
+	}
+	sort.Strings(u.Tags)
+	u.UpdatedAt = now
+
+	return added, removed
+}
+
+// checkTags reports ErrTooManyTags if the tags were added beyond maxTags.
+func checkTags(u URL, added []string, maxTags int) error {
+	if maxTags > 0 && len(added) > 0 && len(u.Tags) > maxTags {
+		return ErrTooManyTags
+	}
+	return nil
+}
+
+func (u URL) hasTag(tag string) bool {
+	for _, t := range u.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// countTags counts the tags of the not deleted URLs, most used first.
+func countTags(URLs []URL) []TagCount {
+	counts := make(map[string]int)
+	for _, u := range URLs {
+		if u.IsDeleted {
+			continue
+		}
+		for _, tag := range u.Tags {
+			counts[tag]++
+		}
+	}
+
+	return sortTagCounts(counts)
+}
+
+func sortTagCounts(counts map[string]int) []TagCount {
+	tagCounts := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tagCounts = append(tagCounts, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(
+		tagCounts, func(i, j int) bool {
+			if tagCounts[i].Count != tagCounts[j].Count {
+				return tagCounts[i].Count > tagCounts[j].Count
+			}
+			return tagCounts[i].Tag < tagCounts[j].Tag
+		},
+	)
+
+	return tagCounts
+}
+
+// TagIndex maps the tags of every user to the short URLs having them, the
+// memory store filters and counts tags without scanning all URLs. The zero
+// value is ready to use, it is shared by MemoryReader and MemoryWriter.
+type TagIndex struct {
+	mu   sync.RWMutex
+	tags map[string]map[string]map[string]struct{}
+}
+
+// replace moves the index from the tags of previous to the tags of current,
+// an empty URL stands for a missing one. A nil index is ignored.
+func (ti *TagIndex) replace(previous, current URL) {
+	if ti == nil {
+		return
+	}
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	if ti.tags == nil {
+		ti.tags = make(map[string]map[string]map[string]struct{})
+	}
+	if previous.ShortURL != "" {
+		userTags := ti.tags[previous.UserID.String()]
+		for _, tag := range previous.Tags {
+			delete(userTags[tag], previous.ShortURL)
+			if len(userTags[tag]) == 0 {
+				delete(userTags, tag)
+			}
+		}
+		if len(userTags) == 0 {
+			delete(ti.tags, previous.UserID.String())
+		}
+	}
+	if current.ShortURL == "" || len(current.Tags) == 0 {
+		return
+	}
+	userTags, ok := ti.tags[current.UserID.String()]
+	if !ok {
+		userTags = make(map[string]map[string]struct{})
+		ti.tags[current.UserID.String()] = userTags
+	}
+	for _, tag := range current.Tags {
+		if userTags[tag] == nil {
+			userTags[tag] = make(map[string]struct{})
+		}
+		userTags[tag][current.ShortURL] = struct{}{}
+	}
+}
+
+func (ti *TagIndex) add(u URL) {
+	ti.replace(URL{}, u)
+}
+
+func (ti *TagIndex) remove(u URL) {
+	ti.replace(u, URL{})
+}
+
+func (ti *TagIndex) shortURLs(userID, tag string) []string {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	shortURLs := make([]string, 0, len(ti.tags[userID][tag]))
+	for shortURL := range ti.tags[userID][tag] {
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs
+}
+
+func (ti *TagIndex) userTags(userID string) map[string][]string {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	tags := make(map[string][]string, len(ti.tags[userID]))
+	for tag, shortURLs := range ti.tags[userID] {
+		for shortURL := range shortURLs {
+			tags[tag] = append(tags[tag], shortURL)
+		}
+	}
+	return tags
+}
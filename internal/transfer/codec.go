@@ -0,0 +1,339 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatJSONLines Format = "jsonl"
+	FormatCSV       Format = "csv"
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case FormatJSONLines, FormatCSV:
+		return Format(format), nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected one of: jsonl, csv", format)
+}
+
+type record struct {
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	UserID        uuid.UUID  `json:"user_id"`
+	IsDeleted     bool       `json:"is_deleted"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+	Title         string     `json:"title,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	Note          string     `json:"note,omitempty"`
+	AlwaysPreview bool       `json:"always_preview,omitempty"`
+	PasswordHash  string     `json:"password_hash,omitempty"`
+	MaxClicks     int        `json:"max_clicks,omitempty"`
+	Clicks        int        `json:"clicks,omitempty"`
+	Rules         []rule     `json:"rules,omitempty"`
+	Variants      []variant  `json:"variants,omitempty"`
+	Sticky        bool       `json:"sticky_variants,omitempty"`
+	UTM           string     `json:"utm,omitempty"`
+	QueryPolicy   string     `json:"query_policy,omitempty"`
+}
+
+type rule struct {
+	Devices   []string `json:"devices,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	Target    string   `json:"target"`
+}
+
+func newRules(rules []store.Rule) []rule {
+	var res []rule
+	for _, r := range rules {
+		res = append(res, rule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target})
+	}
+	return res
+}
+
+func storeRules(rules []rule) []store.Rule {
+	var res []store.Rule
+	for _, r := range rules {
+		res = append(
+			res, store.Rule{Devices: r.Devices, Languages: r.Languages, Countries: r.Countries, Target: r.Target},
+		)
+	}
+	return res
+}
+
+type variant struct {
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+	Served int    `json:"served,omitempty"`
+}
+
+func newVariants(variants []store.Variant) []variant {
+	var res []variant
+	for _, v := range variants {
+		res = append(res, variant{Target: v.Target, Weight: v.Weight, Served: v.Served})
+	}
+	return res
+}
+
+func storeVariants(variants []variant) []store.Variant {
+	var res []store.Variant
+	for _, v := range variants {
+		res = append(res, store.Variant{Target: v.Target, Weight: v.Weight, Served: v.Served})
+	}
+	return res
+}
+
+// csvHeader lists the columns, tags are joined with commas, rules and
+// variants are encoded as JSON. Password hashes are exported so that protected links stay
+// protected after an import.
+var csvHeader = []string{
+	"short_url", "original_url", "user_id", "is_deleted", "deleted_at", "created_at", "updated_at", "title",
+	"tags", "note", "always_preview", "password_hash", "max_clicks", "clicks", "rules", "variants",
+	"sticky_variants", "utm", "query_policy",
+}
+
+type encoder interface {
+	Encode(URL store.URL) error
+	Flush() error
+}
+
+type decoder interface {
+	Decode() (store.URL, error)
+}
+
+func newEncoder(w io.Writer, format Format) (encoder, error) {
+	switch format {
+	case FormatJSONLines:
+		bw := bufio.NewWriter(w)
+		return &jsonEncoder{w: bw, encoder: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{w: cw}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func newDecoder(r io.Reader, format Format) (decoder, error) {
+	switch format {
+	case FormatJSONLines:
+		return &jsonDecoder{decoder: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv header: %w", err)
+		}
+		columns := make(map[string]int)
+		for i, name := range header {
+			columns[name] = i
+		}
+		for _, name := range csvHeader[:2] {
+			if _, ok := columns[name]; !ok {
+				return nil, fmt.Errorf("csv header has no %s column", name)
+			}
+		}
+		cr.FieldsPerRecord = len(header)
+		return &csvDecoder{r: cr, columns: columns}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type jsonEncoder struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e *jsonEncoder) Encode(URL store.URL) error {
+	r := record{
+		ShortURL: URL.ShortURL, OriginalURL: URL.OriginalURL, UserID: URL.UserID, IsDeleted: URL.IsDeleted,
+		Title: URL.Title, Tags: URL.Tags, Note: URL.Note, AlwaysPreview: URL.AlwaysPreview,
+		PasswordHash: URL.PasswordHash, MaxClicks: URL.MaxClicks, Clicks: URL.Clicks, Rules: newRules(URL.Rules),
+		Variants: newVariants(URL.Variants), Sticky: URL.StickyVariants, UTM: URL.UTM, QueryPolicy: URL.QueryPolicy,
+	}
+	if URL.IsDeleted && !URL.DeletedAt.IsZero() {
+		r.DeletedAt = &URL.DeletedAt
+	}
+	if !URL.CreatedAt.IsZero() {
+		r.CreatedAt = &URL.CreatedAt
+	}
+	if !URL.UpdatedAt.IsZero() {
+		r.UpdatedAt = &URL.UpdatedAt
+	}
+	return e.encoder.Encode(r)
+}
+
+func (e *jsonEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type jsonDecoder struct {
+	decoder *json.Decoder
+}
+
+func (d *jsonDecoder) Decode() (store.URL, error) {
+	var r record
+	if err := d.decoder.Decode(&r); err != nil {
+		return store.URL{}, err
+	}
+	URL := store.URL{
+		ShortURL: r.ShortURL, OriginalURL: r.OriginalURL, UserID: r.UserID, IsDeleted: r.IsDeleted,
+		Title: r.Title, Tags: r.Tags, Note: r.Note, AlwaysPreview: r.AlwaysPreview,
+		PasswordHash: r.PasswordHash, MaxClicks: r.MaxClicks, Clicks: r.Clicks, Rules: storeRules(r.Rules),
+		Variants: storeVariants(r.Variants), StickyVariants: r.Sticky, UTM: r.UTM, QueryPolicy: r.QueryPolicy,
+	}
+	if r.IsDeleted && r.DeletedAt != nil {
+		URL.DeletedAt = *r.DeletedAt
+	}
+	if r.CreatedAt != nil {
+		URL.CreatedAt = *r.CreatedAt
+	}
+	if r.UpdatedAt != nil {
+		URL.UpdatedAt = *r.UpdatedAt
+	}
+	return URL, nil
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Encode(URL store.URL) error {
+	var deletedAt, rules, variants string
+	if URL.IsDeleted {
+		deletedAt = formatTime(URL.DeletedAt)
+	}
+	if len(URL.Rules) > 0 {
+		data, err := json.Marshal(newRules(URL.Rules))
+		if err != nil {
+			return err
+		}
+		rules = string(data)
+	}
+	if len(URL.Variants) > 0 {
+		data, err := json.Marshal(newVariants(URL.Variants))
+		if err != nil {
+			return err
+		}
+		variants = string(data)
+	}
+	return e.w.Write(
+		[]string{
+			URL.ShortURL, URL.OriginalURL, URL.UserID.String(), strconv.FormatBool(URL.IsDeleted), deletedAt,
+			formatTime(URL.CreatedAt), formatTime(URL.UpdatedAt), URL.Title, strings.Join(URL.Tags, ","), URL.Note,
+			strconv.FormatBool(URL.AlwaysPreview), URL.PasswordHash, strconv.Itoa(URL.MaxClicks),
+			strconv.Itoa(URL.Clicks), rules, variants, strconv.FormatBool(URL.StickyVariants), URL.UTM,
+			URL.QueryPolicy,
+		},
+	)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func (d *csvDecoder) Decode() (store.URL, error) {
+	row, err := d.r.Read()
+	if err != nil {
+		return store.URL{}, err
+	}
+	URL := store.URL{
+		ShortURL:    row[d.columns["short_url"]],
+		OriginalURL: row[d.columns["original_url"]],
+	}
+	if i, ok := d.columns["user_id"]; ok && row[i] != "" {
+		if URL.UserID, err = uuid.Parse(row[i]); err != nil {
+			return store.URL{}, fmt.Errorf("invalid user_id: %w", err)
+		}
+	}
+	for name, b := range map[string]*bool{
+		"is_deleted": &URL.IsDeleted, "always_preview": &URL.AlwaysPreview, "sticky_variants": &URL.StickyVariants,
+	} {
+		if i, ok := d.columns[name]; ok && row[i] != "" {
+			if *b, err = strconv.ParseBool(row[i]); err != nil {
+				return store.URL{}, fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	for name, n := range map[string]*int{"max_clicks": &URL.MaxClicks, "clicks": &URL.Clicks} {
+		if i, ok := d.columns[name]; ok && row[i] != "" {
+			if *n, err = strconv.Atoi(row[i]); err != nil {
+				return store.URL{}, fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	for name, t := range map[string]*time.Time{
+		"deleted_at": &URL.DeletedAt, "created_at": &URL.CreatedAt, "updated_at": &URL.UpdatedAt,
+	} {
+		if i, ok := d.columns[name]; ok && row[i] != "" {
+			if *t, err = time.Parse(time.RFC3339Nano, row[i]); err != nil {
+				return store.URL{}, fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	if i, ok := d.columns["title"]; ok {
+		URL.Title = row[i]
+	}
+	if i, ok := d.columns["tags"]; ok && row[i] != "" {
+		URL.Tags = strings.Split(row[i], ",")
+	}
+	if i, ok := d.columns["note"]; ok {
+		URL.Note = row[i]
+	}
+	if i, ok := d.columns["password_hash"]; ok {
+		URL.PasswordHash = row[i]
+	}
+	if i, ok := d.columns["utm"]; ok {
+		URL.UTM = row[i]
+	}
+	if i, ok := d.columns["query_policy"]; ok {
+		URL.QueryPolicy = row[i]
+	}
+	if i, ok := d.columns["rules"]; ok && row[i] != "" {
+		var rules []rule
+		if err := json.Unmarshal([]byte(row[i]), &rules); err != nil {
+			return store.URL{}, fmt.Errorf("invalid rules: %w", err)
+		}
+		URL.Rules = storeRules(rules)
+	}
+	if i, ok := d.columns["variants"]; ok && row[i] != "" {
+		var variants []variant
+		if err := json.Unmarshal([]byte(row[i]), &variants); err != nil {
+			return store.URL{}, fmt.Errorf("invalid variants: %w", err)
+		}
+		URL.Variants = storeVariants(variants)
+	}
+	if !URL.IsDeleted {
+		URL.DeletedAt = time.Time{}
+	}
+	return URL, nil
+}
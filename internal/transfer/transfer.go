@@ -0,0 +1,234 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"io"
+)
+
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(policy) {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return ConflictPolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, expected one of: skip, overwrite, fail", policy)
+}
+
+func Export(ctx context.Context, iterable store.Iterable, w io.Writer, format Format) (int, error) {
+	enc, err := newEncoder(w, format)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = iterable.ForEachURL(
+		ctx, func(URL store.URL) error {
+			count++
+			return enc.Encode(URL)
+		},
+	)
+	if err != nil {
+		return count, err
+	}
+
+	return count, enc.Flush()
+}
+
+type ImportOptions struct {
+	Format     Format
+	BatchSize  int
+	DryRun     bool
+	OnConflict ConflictPolicy
+}
+
+type ImportResult struct {
+	Read        int
+	Saved       int
+	Overwritten int
+	Skipped     int
+}
+
+type importer struct {
+	reader store.Reader
+	writer store.Writer
+	opts   ImportOptions
+	result ImportResult
+}
+
+// Import saves the records read from r in batches. Records conflicting with
+// already stored ones by short or full URL are handled one by one according to
+// the conflict policy. A dry run only detects short URL conflicts since full
+// URL uniqueness is checked by the writer.
+func Import(
+	ctx context.Context, reader store.Reader, writer store.Writer, r io.Reader, opts ImportOptions,
+) (ImportResult, error) {
+	im := &importer{reader: reader, writer: writer, opts: opts}
+	if opts.BatchSize <= 0 {
+		return im.result, fmt.Errorf("batch size must be positive, got %d", opts.BatchSize)
+	}
+	if _, ok := writer.(store.Purger); !ok && opts.OnConflict == ConflictOverwrite && !opts.DryRun {
+		return im.result, errors.New("storage does not support overwriting records")
+	}
+	dec, err := newDecoder(r, opts.Format)
+	if err != nil {
+		return im.result, err
+	}
+
+	var chunk []store.URL
+	for {
+		URL, err := dec.Decode()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return im.result, fmt.Errorf("failed to read record %d: %w", im.result.Read+1, err)
+		}
+		im.result.Read++
+		chunk = append(chunk, URL)
+		if len(chunk) == opts.BatchSize {
+			if err := im.flush(ctx, chunk); err != nil {
+				return im.result, err
+			}
+			chunk = nil
+		}
+	}
+
+	return im.result, im.flush(ctx, chunk)
+}
+
+func (im *importer) flush(ctx context.Context, chunk []store.URL) error {
+	var batch, conflicting []store.URL
+	seen := make(map[string]bool)
+	for _, URL := range chunk {
+		exists, err := im.exists(ctx, URL.ShortURL)
+		if err != nil {
+			return err
+		}
+		if exists || seen[URL.ShortURL] {
+			conflicting = append(conflicting, URL)
+			continue
+		}
+		seen[URL.ShortURL] = true
+		batch = append(batch, URL)
+	}
+
+	switch {
+	case len(batch) == 0:
+	case im.opts.DryRun:
+		im.result.Saved += len(batch)
+	case im.writer.SaveBatch(ctx, batch) == nil:
+		im.result.Saved += len(batch)
+		if err := im.markDeleted(ctx, batch); err != nil {
+			return err
+		}
+	default:
+		// a single conflicting record fails the whole batch
+		conflicting = append(batch, conflicting...)
+	}
+	for _, URL := range conflicting {
+		if err := im.saveOne(ctx, URL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *importer) saveOne(ctx context.Context, URL store.URL) error {
+	exists, err := im.exists(ctx, URL.ShortURL)
+	if err != nil {
+		return err
+	}
+	overwritten := false
+	if exists {
+		switch im.opts.OnConflict {
+		case ConflictSkip:
+			im.result.Skipped++
+			return nil
+		case ConflictFail:
+			return fmt.Errorf("short url %s already exists", URL.ShortURL)
+		}
+		if im.opts.DryRun {
+			im.result.Overwritten++
+			return nil
+		}
+		if err := im.purge(ctx, URL.ShortURL); err != nil {
+			return err
+		}
+		overwritten = true
+	}
+	if im.opts.DryRun {
+		im.result.Saved++
+		return nil
+	}
+
+	err = im.writer.SaveURL(ctx, URL)
+	var conflictErr *store.ConflictError
+	if errors.As(err, &conflictErr) {
+		switch im.opts.OnConflict {
+		case ConflictSkip:
+			im.result.Skipped++
+			return nil
+		case ConflictFail:
+			return fmt.Errorf("full url %s already exists: %w", URL.OriginalURL, err)
+		}
+		if err := im.purge(ctx, conflictErr.ShortURL); err != nil {
+			return err
+		}
+		overwritten = true
+		err = im.writer.SaveURL(ctx, URL)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save short url %s: %w", URL.ShortURL, err)
+	}
+	if overwritten {
+		im.result.Overwritten++
+	} else {
+		im.result.Saved++
+	}
+
+	return im.markDeleted(ctx, []store.URL{URL})
+}
+
+func (im *importer) exists(ctx context.Context, shortURL string) (bool, error) {
+	_, err := im.reader.GetURL(ctx, shortURL)
+	var deletedErr *store.DeletedURLError
+	switch {
+	case err == nil, errors.As(err, &deletedErr):
+		return true, nil
+	case errors.Is(err, store.ErrNotFound):
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check short url %s: %w", shortURL, err)
+}
+
+func (im *importer) purge(ctx context.Context, shortURL string) error {
+	return im.writer.(store.Purger).PurgeURLs(ctx, []store.URL{{ShortURL: shortURL}})
+}
+
+func (im *importer) markDeleted(ctx context.Context, URLs []store.URL) error {
+	var deleted []store.URL
+	for _, URL := range URLs {
+		if URL.IsDeleted {
+			deleted = append(deleted, URL)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	deleter, ok := im.writer.(store.DeleteURLs)
+	if !ok {
+		return errors.New("storage does not support deleted records")
+	}
+
+	return deleter.DeleteURLs(ctx, deleted)
+}
@@ -0,0 +1,151 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"github.com/ZhuzhomaAL/go-shortener/internal/store"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newMemoryStore() (*store.MemoryReader, *store.MemoryWriter) {
+	urlList := &sync.Map{}
+	return &store.MemoryReader{URLList: urlList}, &store.MemoryWriter{URLList: urlList, FullURLList: &sync.Map{}}
+}
+
+func allURLs(t *testing.T, reader *store.MemoryReader) []store.URL {
+	var urls []store.URL
+	require.NoError(
+		t, reader.ForEachURL(
+			context.Background(), func(URL store.URL) error {
+				urls = append(urls, URL)
+				return nil
+			},
+		),
+	)
+	sort.Slice(urls, func(i, j int) bool { return urls[i].ShortURL < urls[j].ShortURL })
+	return urls
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	source := []store.URL{
+		{
+			ShortURL: "aaaaaaaa", OriginalURL: "https://ya.ru", UserID: userID, Title: "Яндекс, поиск",
+			Tags: []string{"search", "ru"}, Note: "строка\nвторая", AlwaysPreview: true,
+			PasswordHash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", MaxClicks: 5, Clicks: 2,
+			Rules: []store.Rule{
+				{Devices: []string{"ios"}, Target: "https://apps.apple.com/app/id1"},
+				{Languages: []string{"ru"}, Countries: []string{"RU", "BY"}, Target: "https://ya.ru/ru"},
+			},
+			Variants: []store.Variant{
+				{Target: "https://ya.ru/a", Weight: 3, Served: 7}, {Target: "https://ya.ru/b", Weight: 1},
+			},
+			StickyVariants: true,
+			UTM:            "utm_campaign=%D0%B2%D0%B5%D1%81%D0%BD%D0%B0%2C+2024&utm_source=mail",
+			QueryPolicy:    "merge",
+		},
+		{ShortURL: "bbbbbbbb", OriginalURL: "https://practicum.yandex.ru", UserID: userID},
+		{ShortURL: "cccccccc", OriginalURL: "https://google.com?q=a,b", UserID: uuid.New()},
+	}
+	for _, format := range []Format{FormatJSONLines, FormatCSV} {
+		format := format
+		t.Run(
+			string(format), func(t *testing.T) {
+				reader, writer := newMemoryStore()
+				require.NoError(t, writer.SaveBatch(ctx, source))
+				require.NoError(t, writer.DeleteURLs(ctx, source[2:]))
+
+				var buf bytes.Buffer
+				count, err := Export(ctx, reader, &buf, format)
+				require.NoError(t, err)
+				assert.Equal(t, 3, count)
+
+				dstReader, dstWriter := newMemoryStore()
+				opts := ImportOptions{Format: format, BatchSize: 2, OnConflict: ConflictFail}
+				res, err := Import(ctx, dstReader, dstWriter, &buf, opts)
+				require.NoError(t, err)
+				assert.Equal(t, ImportResult{Read: 3, Saved: 3}, res)
+				assert.Equal(t, allURLs(t, reader), allURLs(t, dstReader))
+			},
+		)
+	}
+}
+
+func TestImport_ConflictPolicy(t *testing.T) {
+	ctx := context.Background()
+	existing := []store.URL{
+		{ShortURL: "aaaaaaaa", OriginalURL: "https://ya.ru"},
+		{ShortURL: "bbbbbbbb", OriginalURL: "https://practicum.yandex.ru"},
+	}
+	input := `{"short_url":"aaaaaaaa","original_url":"https://new.ru"}
+{"short_url":"zzzzzzzz","original_url":"https://practicum.yandex.ru"}
+{"short_url":"cccccccc","original_url":"https://google.com"}
+`
+	tests := []struct {
+		name       string
+		policy     ConflictPolicy
+		dryRun     bool
+		wantResult ImportResult
+		wantError  bool
+		wantURLs   map[string]string
+	}{
+		{
+			name:       "skip",
+			policy:     ConflictSkip,
+			wantResult: ImportResult{Read: 3, Saved: 1, Skipped: 2},
+			wantURLs: map[string]string{
+				"aaaaaaaa": "https://ya.ru", "bbbbbbbb": "https://practicum.yandex.ru", "cccccccc": "https://google.com",
+			},
+		},
+		{
+			name:       "overwrite",
+			policy:     ConflictOverwrite,
+			wantResult: ImportResult{Read: 3, Saved: 1, Overwritten: 2},
+			wantURLs: map[string]string{
+				"aaaaaaaa": "https://new.ru", "zzzzzzzz": "https://practicum.yandex.ru", "cccccccc": "https://google.com",
+			},
+		},
+		{
+			name:      "fail",
+			policy:    ConflictFail,
+			wantError: true,
+		},
+		{
+			name:       "dry_run",
+			policy:     ConflictOverwrite,
+			dryRun:     true,
+			wantResult: ImportResult{Read: 3, Saved: 2, Overwritten: 1},
+			wantURLs:   map[string]string{"aaaaaaaa": "https://ya.ru", "bbbbbbbb": "https://practicum.yandex.ru"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				reader, writer := newMemoryStore()
+				require.NoError(t, writer.SaveBatch(ctx, existing))
+
+				opts := ImportOptions{Format: FormatJSONLines, BatchSize: 10, DryRun: tt.dryRun, OnConflict: tt.policy}
+				res, err := Import(ctx, reader, writer, strings.NewReader(input), opts)
+				if tt.wantError {
+					require.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantResult, res)
+				urls := make(map[string]string)
+				for _, URL := range allURLs(t, reader) {
+					urls[URL.ShortURL] = URL.OriginalURL
+				}
+				assert.Equal(t, tt.wantURLs, urls)
+			},
+		)
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type contextAdminKey int
+
+const ContextAdmin contextAdminKey = iota
+
+// DefaultAdminOperator names the operator of a key given without a name.
+const DefaultAdminOperator = "admin"
+
+// ParseAdminKeys parses the comma separated operator:key pairs of the admin
+// API into keys by operator name. A key without a name belongs to
+// DefaultAdminOperator.
+func ParseAdminKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	operators := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		operator, key, ok := strings.Cut(entry, ":")
+		if !ok {
+			operator, key = DefaultAdminOperator, entry
+		}
+		if operator == "" || key == "" {
+			return nil, fmt.Errorf("invalid admin key of operator %q, expected operator:key", operator)
+		}
+		if _, ok := keys[operator]; ok {
+			return nil, fmt.Errorf("duplicate admin operator %q", operator)
+		}
+		if _, ok := operators[key]; ok {
+			return nil, fmt.Errorf("admin operator %q shares the key of %q", operator, operators[key])
+		}
+		keys[operator] = key
+		operators[key] = operator
+	}
+
+	return keys, nil
+}
+
+// AdminMiddleware lets through callers presenting one of keys in X-Admin-Key,
+// empty keys let through nobody. The name of the operator owning the key is
+// put into the context under ContextAdmin.
+func AdminMiddleware(keys map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				key := []byte(r.Header.Get("X-Admin-Key"))
+				var actor string
+				// All keys are compared so the time does not tell which one
+				// matched.
+				for operator, operatorKey := range keys {
+					if subtle.ConstantTimeCompare(key, []byte(operatorKey)) == 1 {
+						actor = operator
+					}
+				}
+				if actor == "" {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+				ctx := context.WithValue(r.Context(), ContextAdmin, actor)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			},
+		)
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseAdminKeys(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		expectedKeys map[string]string
+		wantErr      bool
+	}{
+		{name: "empty", value: "", expectedKeys: map[string]string{}},
+		{name: "bare_key", value: "secret", expectedKeys: map[string]string{DefaultAdminOperator: "secret"}},
+		{
+			name:         "operators",
+			value:        "alice:secret, bob:other:key",
+			expectedKeys: map[string]string{"alice": "secret", "bob": "other:key"},
+		},
+		{name: "empty_operator", value: ":secret", wantErr: true},
+		{name: "empty_key", value: "alice:", wantErr: true},
+		{name: "duplicate_operator", value: "alice:secret,alice:other", wantErr: true},
+		{name: "shared_key", value: "alice:secret,bob:secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				keys, err := ParseAdminKeys(tt.value)
+				if tt.wantErr {
+					assert.Error(t, err, "Ожидалась ошибка разбора ключей")
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedKeys, keys, "Ключи не совпадают с ожидаемыми")
+			},
+		)
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	keys := map[string]string{"alice": "secret", "bob": "other"}
+	tests := []struct {
+		name           string
+		keys           map[string]string
+		key            string
+		expectedStatus int
+		expectedActor  string
+	}{
+		{name: "alice", keys: keys, key: "secret", expectedStatus: http.StatusOK, expectedActor: "alice"},
+		{name: "bob", keys: keys, key: "other", expectedStatus: http.StatusOK, expectedActor: "bob"},
+		{name: "wrong_key", keys: keys, key: "wrong", expectedStatus: http.StatusForbidden},
+		{name: "no_key", keys: keys, expectedStatus: http.StatusForbidden},
+		{name: "no_keys", expectedStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var actor string
+				handler := AdminMiddleware(tt.keys)(
+					http.HandlerFunc(
+						func(w http.ResponseWriter, r *http.Request) {
+							actor, _ = r.Context().Value(ContextAdmin).(string)
+						},
+					),
+				)
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("X-Admin-Key", tt.key)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				assert.Equal(t, tt.expectedStatus, rec.Code, "Код ответа не совпадает с ожидаемым")
+				assert.Equal(t, tt.expectedActor, actor, "Оператор не совпадает с ожидаемым")
+			},
+		)
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// URLHost returns the lower case host of rawURL without port, empty if it
+// can not be parsed.
+func URLHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// MatchDomain reports whether host is the domain or its subdomain.
+func MatchDomain(host, domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+func HSTSMiddleware(maxAge time.Duration) func(http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", int(maxAge.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.TLS != nil {
+					w.Header().Set("Strict-Transport-Security", value)
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// RedirectToHTTPS redirects plain HTTP requests to the same host served over
+// HTTPS on httpsAddr port.
+func RedirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			if port != "" && port != "443" {
+				host = net.JoinHostPort(host, port)
+			}
+			target := "https://" + host + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+		},
+	)
+}
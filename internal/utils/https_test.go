@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHSTSMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxAge        time.Duration
+		tls           bool
+		expectedValue string
+	}{
+		{name: "year", maxAge: 365 * 24 * time.Hour, tls: true, expectedValue: "max-age=31536000; includeSubDomains"},
+		{name: "fraction_of_second", maxAge: 1500 * time.Millisecond, tls: true, expectedValue: "max-age=1; includeSubDomains"},
+		{name: "plain_http", maxAge: time.Hour, expectedValue: ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				handler := HSTSMiddleware(tt.maxAge)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.tls {
+					req.TLS = &tls.ConnectionState{}
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(
+					t, tt.expectedValue, rec.Header().Get("Strict-Transport-Security"),
+					"Заголовок HSTS не совпадает с ожидаемым",
+				)
+			},
+		)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name             string
+		httpsAddr        string
+		host             string
+		expectedLocation string
+	}{
+		{
+			name:             "custom_port",
+			httpsAddr:        ":8443",
+			host:             "example.com:8080",
+			expectedLocation: "https://example.com:8443/abc?x=1",
+		},
+		{
+			name:             "default_port",
+			httpsAddr:        "0.0.0.0:443",
+			host:             "example.com:8080",
+			expectedLocation: "https://example.com/abc?x=1",
+		},
+		{
+			name:             "host_without_port",
+			httpsAddr:        ":8443",
+			host:             "example.com",
+			expectedLocation: "https://example.com:8443/abc?x=1",
+		},
+		{
+			name:             "address_without_port",
+			httpsAddr:        "example.com",
+			host:             "example.com:80",
+			expectedLocation: "https://example.com/abc?x=1",
+		},
+		{
+			name:             "ipv6_host",
+			httpsAddr:        ":8443",
+			host:             "[::1]:8080",
+			expectedLocation: "https://[::1]:8443/abc?x=1",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/abc?x=1", nil)
+				req.Host = tt.host
+				rec := httptest.NewRecorder()
+				RedirectToHTTPS(tt.httpsAddr).ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusPermanentRedirect, rec.Code, "Код ответа не совпадает с ожидаемым")
+				assert.Equal(t, tt.expectedLocation, rec.Header().Get("Location"), "Адрес перенаправления не совпадает")
+			},
+		)
+	}
+}
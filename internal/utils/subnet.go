@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses a comma separated list of subnets, an empty string gives
+// an empty list.
+func ParseCIDRs(value string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", item, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets, nil
+}
+
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the caller. X-Real-IP and X-Forwarded-For
+// are taken into account only when the direct peer is one of trustedProxies,
+// the forwarded chain is walked from the right skipping trusted hops.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !containsIP(trustedProxies, peer) {
+		return peer
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		peer = ip
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+
+	return peer
+}
+
+// TrustedSubnetMiddleware lets through only callers from the trusted subnet,
+// everyone is rejected when it is nil.
+func TrustedSubnetMiddleware(trusted *net.IPNet, trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ip := ClientIP(r, trustedProxies)
+				if trusted == nil || ip == nil || !trusted.Contains(ip) {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
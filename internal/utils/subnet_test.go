@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	proxies, err := ParseCIDRs("192.168.1.1/32, 192.168.2.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		trusted        *net.IPNet
+		remoteAddr     string
+		realIP         string
+		forwardedFor   string
+		expectedStatus int
+	}{
+		{
+			name:           "direct_trusted_peer",
+			trusted:        trusted,
+			remoteAddr:     "10.1.2.3:5000",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "direct_untrusted_peer",
+			trusted:        trusted,
+			remoteAddr:     "172.16.0.1:5000",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "spoofed_header_from_untrusted_peer",
+			trusted:        trusted,
+			remoteAddr:     "172.16.0.1:5000",
+			realIP:         "10.1.2.3",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "real_ip_from_trusted_proxy",
+			trusted:        trusted,
+			remoteAddr:     "192.168.1.1:5000",
+			realIP:         "10.1.2.3",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "forwarded_chain_through_trusted_proxies",
+			trusted:        trusted,
+			remoteAddr:     "192.168.1.1:5000",
+			forwardedFor:   "172.16.0.1, 10.1.2.3, 192.168.2.7",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "spoofed_forwarded_prefix",
+			trusted:        trusted,
+			remoteAddr:     "192.168.1.1:5000",
+			forwardedFor:   "10.1.2.3, 172.16.0.1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "no_trusted_subnet",
+			remoteAddr:     "10.1.2.3:5000",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(
+			tt.name, func(t *testing.T) {
+				handler := TrustedSubnetMiddleware(tt.trusted, proxies)(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				)
+				req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+				req.RemoteAddr = tt.remoteAddr
+				if tt.realIP != "" {
+					req.Header.Set("X-Real-IP", tt.realIP)
+				}
+				if tt.forwardedFor != "" {
+					req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				assert.Equal(t, tt.expectedStatus, rec.Code, "Код ответа не совпадает с ожидаемым")
+			},
+		)
+	}
+}
+
+func TestParseCIDRs_Invalid(t *testing.T) {
+	_, err := ParseCIDRs("10.0.0.0/8,not-a-subnet")
+	assert.Error(t, err)
+}